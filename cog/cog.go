@@ -0,0 +1,251 @@
+// Package cog exports decoded GRIB fields as float32 Cloud-Optimized
+// GeoTIFFs in Web Mercator or geographic coordinates.
+//
+// The field is resampled with the interpolators used for map tiles. The
+// file has internal tiles, overviews down to a single tile, and a nodata
+// value from the missing value of the message, so GIS applications such as
+// QGIS can open it directly.
+package cog
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"strings"
+	"sync"
+
+	"hstin/grib2tiles/parser"
+	"hstin/grib2tiles/tiles"
+)
+
+// CRS is the coordinate reference system of the exported raster, given by
+// its EPSG code.
+type CRS int
+
+const (
+	WebMercator CRS = 3857
+	WGS84       CRS = 4326
+)
+
+// ParseCRS reads a CRS given as "EPSG:3857", "3857", "EPSG:4326" or "4326".
+func ParseCRS(s string) (CRS, error) {
+	switch strings.TrimPrefix(strings.ToUpper(s), "EPSG:") {
+	case "3857":
+		return WebMercator, nil
+	case "4326":
+		return WGS84, nil
+	}
+	return 0, fmt.Errorf("unsupported CRS %q, use EPSG:3857 or EPSG:4326", s)
+}
+
+func (c CRS) String() string {
+	return fmt.Sprintf("EPSG:%d", int(c))
+}
+
+// Options control the export of a field.
+type Options struct {
+	// CRS of the raster, WebMercator when zero.
+	CRS CRS
+
+	// Bounds are the [minLat, minLon, maxLat, maxLon] covered by the
+	// raster, the bounds of the grid when zero.
+	Bounds [4]float64
+
+	// Resolution is the size of a pixel in the units of the CRS, metres or
+	// degrees. When zero it approximates the spacing of the grid.
+	Resolution float64
+
+	// Interpolator resamples the field, parser.Bicubic when nil.
+	Interpolator parser.Interpolator
+
+	// Workers is the number of resampling goroutines, the number of CPUs
+	// when zero.
+	Workers int
+}
+
+// metresPerDegree is the length of a degree of longitude at the equator.
+const metresPerDegree = 2 * math.Pi * tiles.EarthRadius / 360
+
+// maxMercatorLat is the latitude of the edges of the Web Mercator square.
+const maxMercatorLat = 85.05112878
+
+// raster is one level of the image.
+type raster struct {
+	width, height int
+	values        []float32
+}
+
+// Write resamples a field and writes it as a Cloud-Optimized GeoTIFF.
+func Write(filename string, grid *parser.GRIBFile, opts Options) error {
+	if opts.CRS == 0 {
+		opts.CRS = WebMercator
+	}
+	if opts.CRS != WebMercator && opts.CRS != WGS84 {
+		return fmt.Errorf("unsupported CRS %s", opts.CRS)
+	}
+	if opts.Interpolator == nil {
+		opts.Interpolator = parser.Bicubic
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+
+	bounds := opts.Bounds
+	if bounds == [4]float64{} {
+		minLat, minLon, maxLat, maxLon := grid.Bounds()
+		bounds = [4]float64{minLat, minLon, maxLat, maxLon}
+	}
+	bounds[0], bounds[2] = math.Max(bounds[0], -90), math.Min(bounds[2], 90)
+	bounds[1], bounds[3] = math.Max(bounds[1], -180), math.Min(bounds[3], 180)
+	if opts.CRS == WebMercator {
+		bounds[0] = math.Max(bounds[0], -maxMercatorLat)
+		bounds[2] = math.Min(bounds[2], maxMercatorLat)
+	}
+
+	// The extent of the raster in the units of the CRS.
+	minX, minY := project(opts.CRS, bounds[0], bounds[1])
+	maxX, maxY := project(opts.CRS, bounds[2], bounds[3])
+	if maxX <= minX || maxY <= minY {
+		return fmt.Errorf("empty bounds %v", bounds)
+	}
+
+	res := opts.Resolution
+	if res <= 0 {
+		res = nativeResolution(grid, bounds)
+		if opts.CRS == WebMercator {
+			res *= metresPerDegree
+		}
+	}
+
+	width := int(math.Ceil((maxX - minX) / res))
+	height := int(math.Ceil((maxY - minY) / res))
+	if int64(width)*int64(height)*4 > maxTIFFSize {
+		return fmt.Errorf("raster of %d x %d pixels exceeds the size of a TIFF file, choose a coarser resolution", width, height)
+	}
+
+	base := resample(grid, opts, minX, maxY, res, width, height)
+	levels := []raster{base}
+	for level := base; level.width > blockSize || level.height > blockSize; {
+		level = downsample(level, float32(grid.Header.MissingValue))
+		levels = append(levels, level)
+	}
+
+	return writeTIFF(filename, levels, geoInfo{
+		crs:     opts.CRS,
+		originX: minX,
+		originY: maxY,
+		res:     res,
+		noData:  float32(grid.Header.MissingValue),
+	})
+}
+
+// project converts latitude and longitude to the coordinates of a CRS.
+func project(crs CRS, lat, lon float64) (float64, float64) {
+	if crs == WGS84 {
+		return lon, lat
+	}
+	x := tiles.EarthRadius * lon * math.Pi / 180
+	y := tiles.EarthRadius * math.Log(math.Tan(math.Pi/4+lat*math.Pi/360))
+	return x, y
+}
+
+// unproject converts the coordinates of a CRS to latitude and longitude.
+func unproject(crs CRS, x, y float64) (float64, float64) {
+	if crs == WGS84 {
+		return y, x
+	}
+	return tiles.MercatorToLatLon(x, y)
+}
+
+// nativeResolution approximates the spacing of the grid points in degrees.
+func nativeResolution(grid *parser.GRIBFile, bounds [4]float64) float64 {
+	h := grid.Header
+
+	switch h.GridType {
+	case parser.GridLambert, parser.GridPolarStereographic:
+		if h.DX != 0 {
+			return math.Abs(h.DX) / metresPerDegree
+		}
+	case parser.GridReducedGaussian:
+		maxPoints := 0
+		for _, n := range h.PL {
+			maxPoints = max(maxPoints, n)
+		}
+		if maxPoints > 0 {
+			return 360 / float64(maxPoints)
+		}
+	case parser.GridUnstructured:
+		area := (bounds[2] - bounds[0]) * (bounds[3] - bounds[1])
+		return math.Sqrt(area / float64(max(h.Nx, 1)))
+	default:
+		if h.DX != 0 {
+			return math.Abs(h.DX)
+		}
+	}
+
+	return (bounds[3] - bounds[1]) / float64(max(h.Nx, 1))
+}
+
+// resample interpolates the field at the centres of the pixels of the base
+// level.
+func resample(grid *parser.GRIBFile, opts Options, minX, maxY, res float64, width, height int) raster {
+	r := raster{width: width, height: height, values: make([]float32, width*height)}
+
+	rows := make(chan int, height)
+	for j := 0; j < height; j++ {
+		rows <- j
+	}
+	close(rows)
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range rows {
+				y := maxY - (float64(j)+0.5)*res
+				for i := 0; i < width; i++ {
+					x := minX + (float64(i)+0.5)*res
+					lat, lon := unproject(opts.CRS, x, y)
+					r.values[j*width+i] = float32(grid.Interpolate(lat, lon, opts.Interpolator))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return r
+}
+
+// downsample halves the resolution of a level, averaging the valid values
+// of each 2 x 2 block of pixels.
+func downsample(r raster, noData float32) raster {
+	out := raster{width: (r.width + 1) / 2, height: (r.height + 1) / 2}
+	out.values = make([]float32, out.width*out.height)
+
+	for j := 0; j < out.height; j++ {
+		for i := 0; i < out.width; i++ {
+			sum, n := 0.0, 0
+			for dj := 0; dj < 2; dj++ {
+				for di := 0; di < 2; di++ {
+					x, y := 2*i+di, 2*j+dj
+					if x >= r.width || y >= r.height {
+						continue
+					}
+					if v := r.values[y*r.width+x]; v != noData && !math.IsNaN(float64(v)) {
+						sum += float64(v)
+						n++
+					}
+				}
+			}
+
+			value := noData
+			if n > 0 {
+				value = float32(sum / float64(n))
+			}
+			out.values[j*out.width+i] = value
+		}
+	}
+
+	return out
+}
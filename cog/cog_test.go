@@ -0,0 +1,306 @@
+package cog
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"hstin/grib2tiles/parser"
+	"hstin/grib2tiles/tiles"
+)
+
+// tiffField is an IFD entry read back from a file, with its values.
+type tiffField struct {
+	typ   uint16
+	count uint32
+	data  []byte
+}
+
+func (f tiffField) uints() []uint64 {
+	values := make([]uint64, f.count)
+	for i := range values {
+		switch f.typ {
+		case typeShort:
+			values[i] = uint64(binary.LittleEndian.Uint16(f.data[2*i:]))
+		case typeLong:
+			values[i] = uint64(binary.LittleEndian.Uint32(f.data[4*i:]))
+		}
+	}
+	return values
+}
+
+func (f tiffField) doubles() []float64 {
+	values := make([]float64, f.count)
+	for i := range values {
+		values[i] = math.Float64frombits(binary.LittleEndian.Uint64(f.data[8*i:]))
+	}
+	return values
+}
+
+// tiffIFD is an image file directory read back from a file.
+type tiffIFD struct {
+	fields map[uint16]tiffField
+	end    int64 // end of the IFD and the values following it
+}
+
+func (d tiffIFD) uint(t *testing.T, tag uint16) uint64 {
+	t.Helper()
+	f, ok := d.fields[tag]
+	if !ok || f.count != 1 {
+		t.Fatalf("tag %d missing or not a single value", tag)
+	}
+	return f.uints()[0]
+}
+
+// readTIFF reads a little-endian classic TIFF file and follows its chain
+// of IFDs.
+func readTIFF(t *testing.T, filename string) ([]byte, []tiffIFD) {
+	t.Helper()
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	le := binary.LittleEndian
+	if string(data[:2]) != "II" || le.Uint16(data[2:]) != 42 {
+		t.Fatalf("header % x, want a little-endian classic TIFF", data[:4])
+	}
+
+	typeSizes := map[uint16]int{typeASCII: 1, typeShort: 2, typeLong: 4, typeDouble: 8}
+
+	var ifds []tiffIFD
+	for offset := int64(le.Uint32(data[4:])); offset != 0; {
+		if len(ifds) > 16 {
+			t.Fatal("IFD chain does not end")
+		}
+		n := int64(le.Uint16(data[offset:]))
+		d := tiffIFD{fields: make(map[uint16]tiffField), end: offset + 2 + 12*n + 4}
+		prevTag := -1
+		for i := int64(0); i < n; i++ {
+			e := data[offset+2+12*i:]
+			tag, typ, count := le.Uint16(e), le.Uint16(e[2:]), le.Uint32(e[4:])
+			if int(tag) <= prevTag {
+				t.Errorf("tag %d follows tag %d", tag, prevTag)
+			}
+			prevTag = int(tag)
+
+			size := int64(typeSizes[typ]) * int64(count)
+			if size == 0 {
+				t.Fatalf("tag %d has type %d and %d values", tag, typ, count)
+			}
+			value := e[8:12]
+			if size > 4 {
+				start := int64(le.Uint32(e[8:]))
+				value = data[start : start+size]
+				d.end = max(d.end, start+size)
+			}
+			d.fields[tag] = tiffField{typ: typ, count: count, data: value[:size]}
+		}
+		ifds = append(ifds, d)
+		offset = int64(le.Uint32(data[offset+2+12*n:]))
+	}
+	return data, ifds
+}
+
+// readTile inflates a tile and returns its values.
+func readTile(t *testing.T, data []byte, d tiffIFD, index int) []float32 {
+	t.Helper()
+	offset := d.fields[tagTileOffsets].uints()[index]
+	count := d.fields[tagTileByteCounts].uints()[index]
+	zr, err := zlib.NewReader(bytes.NewReader(data[offset : offset+count]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 4*blockSize*blockSize {
+		t.Fatalf("tile of %d bytes, want %d", len(raw), 4*blockSize*blockSize)
+	}
+	values := make([]float32, blockSize*blockSize)
+	for i := range values {
+		values[i] = math.Float32frombits(binary.LittleEndian.Uint32(raw[4*i:]))
+	}
+	return values
+}
+
+// checkTile compares a tile with the pixels of a level, padded with nodata.
+func checkTile(t *testing.T, got []float32, r raster, tx, ty int, noData float32) {
+	t.Helper()
+	for j := 0; j < blockSize; j++ {
+		for i := 0; i < blockSize; i++ {
+			x, y := tx*blockSize+i, ty*blockSize+j
+			want := noData
+			if x < r.width && y < r.height {
+				want = r.values[y*r.width+x]
+			}
+			if got[j*blockSize+i] != want {
+				t.Fatalf("tile %d,%d pixel %d,%d = %g, want %g", tx, ty, i, j, got[j*blockSize+i], want)
+			}
+		}
+	}
+}
+
+func TestWriteTIFF(t *testing.T) {
+	const noData = -9999
+	base := raster{width: 600, height: 300, values: make([]float32, 600*300)}
+	for i := range base.values {
+		base.values[i] = float32(i%997) / 4
+		if i%13 == 0 {
+			base.values[i] = noData
+		}
+	}
+	levels := []raster{base}
+	for level := base; level.width > blockSize || level.height > blockSize; {
+		level = downsample(level, noData)
+		levels = append(levels, level)
+	}
+
+	filename := filepath.Join(t.TempDir(), "field.tif")
+	geo := geoInfo{crs: WGS84, originX: -30, originY: 15, res: 0.1, noData: noData}
+	if err := writeTIFF(filename, levels, geo); err != nil {
+		t.Fatal(err)
+	}
+	data, ifds := readTIFF(t, filename)
+
+	if len(ifds) != 3 {
+		t.Fatalf("%d IFDs, want 3", len(ifds))
+	}
+
+	// The base level comes first, followed by the overviews from the
+	// largest to the smallest.
+	for k, d := range ifds {
+		wantType := uint64(subfileReducedResolution)
+		if k == 0 {
+			wantType = 0
+		}
+		if got := d.uint(t, tagNewSubfileType); got != wantType {
+			t.Errorf("IFD %d has subfile type %d, want %d", k, got, wantType)
+		}
+		w, h := d.uint(t, tagImageWidth), d.uint(t, tagImageLength)
+		if w != uint64(levels[k].width) || h != uint64(levels[k].height) {
+			t.Errorf("IFD %d of %d x %d pixels, want %d x %d", k, w, h, levels[k].width, levels[k].height)
+		}
+		if d.uint(t, tagTileWidth) != blockSize || d.uint(t, tagTileLength) != blockSize {
+			t.Errorf("IFD %d tiles of %d x %d pixels", k, d.uint(t, tagTileWidth), d.uint(t, tagTileLength))
+		}
+		if d.uint(t, tagCompression) != compressionDeflate || d.uint(t, tagSampleFormat) != sampleFormatFloat || d.uint(t, tagBitsPerSample) != 32 {
+			t.Errorf("IFD %d is not deflated 32 bit floats", k)
+		}
+		_, geoKeys := d.fields[tagGeoKeyDirectory]
+		if geoKeys != (k == 0) {
+			t.Errorf("IFD %d has GeoKeys %v", k, geoKeys)
+		}
+	}
+	if got := string(ifds[0].fields[tagGDALNoData].data); got != "-9999\x00" {
+		t.Errorf("GDAL_NODATA %q, want \"-9999\"", got)
+	}
+
+	// The tiles follow the IFDs, from the smallest overview up to the base
+	// level, and end the file.
+	offset := uint64(ifds[len(ifds)-1].end)
+	for k := len(ifds) - 1; k >= 0; k-- {
+		offsets := ifds[k].fields[tagTileOffsets].uints()
+		counts := ifds[k].fields[tagTileByteCounts].uints()
+		across := (levels[k].width + blockSize - 1) / blockSize
+		down := (levels[k].height + blockSize - 1) / blockSize
+		if len(offsets) != across*down || len(counts) != across*down {
+			t.Fatalf("IFD %d has %d offsets and %d byte counts, want %d", k, len(offsets), len(counts), across*down)
+		}
+		for i := range offsets {
+			if offsets[i] != offset {
+				t.Errorf("IFD %d tile %d at %d, want %d", k, i, offsets[i], offset)
+			}
+			offset = offsets[i] + counts[i]
+		}
+	}
+	if offset != uint64(len(data)) {
+		t.Errorf("tiles end at %d, file at %d", offset, len(data))
+	}
+
+	// The second row and column of the base level, padded at the bottom.
+	checkTile(t, readTile(t, data, ifds[0], 4), levels[0], 1, 1, noData)
+	checkTile(t, readTile(t, data, ifds[2], 0), levels[2], 0, 0, noData)
+}
+
+func TestWriteGeoKeys(t *testing.T) {
+	values := make([]float64, 11*6)
+	for i := range values {
+		values[i] = float64(i)
+	}
+	grid := &parser.GRIBFile{
+		Header: parser.GribHeader{
+			GridType: parser.GridRegularLatLon, Nx: 11, Ny: 6,
+			La1: 50, Lo1: 0, La2: 45, Lo2: 10, DX: 1, DY: 1,
+			MissingValue: 9999,
+		},
+		DataValues: values,
+	}
+
+	tests := []struct {
+		crs              CRS
+		keys             []uint64
+		res              float64
+		originX, originY float64
+	}{
+		{
+			crs: WebMercator,
+			keys: []uint64{
+				1, 1, 0, 3,
+				geoKeyModelType, 0, 1, modelTypeProjected,
+				geoKeyRasterType, 0, 1, rasterPixelIsArea,
+				geoKeyProjectedCSType, 0, 1, 3857,
+			},
+			res:     2 * math.Pi * tiles.EarthRadius / 360,
+			originY: tiles.EarthRadius * math.Log(math.Tan(math.Pi/4+50*math.Pi/360)),
+		},
+		{
+			crs: WGS84,
+			keys: []uint64{
+				1, 1, 0, 3,
+				geoKeyModelType, 0, 1, modelTypeGeographic,
+				geoKeyRasterType, 0, 1, rasterPixelIsArea,
+				geoKeyGeographicType, 0, 1, 4326,
+			},
+			res:     1,
+			originY: 50,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.crs.String(), func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "field.tif")
+			if err := Write(filename, grid, Options{CRS: tt.crs, Interpolator: parser.Nearest}); err != nil {
+				t.Fatal(err)
+			}
+			_, ifds := readTIFF(t, filename)
+			d := ifds[0]
+
+			keys := d.fields[tagGeoKeyDirectory].uints()
+			if len(keys) != len(tt.keys) {
+				t.Fatalf("GeoKeys %v, want %v", keys, tt.keys)
+			}
+			for i := range keys {
+				if keys[i] != tt.keys[i] {
+					t.Fatalf("GeoKeys %v, want %v", keys, tt.keys)
+				}
+			}
+
+			scale := d.fields[tagModelPixelScale].doubles()
+			if len(scale) != 3 || math.Abs(scale[0]-tt.res) > 1e-6 || math.Abs(scale[1]-tt.res) > 1e-6 || scale[2] != 0 {
+				t.Errorf("pixel scale %v, want %g", scale, tt.res)
+			}
+			tiepoint := d.fields[tagModelTiepoint].doubles()
+			if len(tiepoint) != 6 || math.Abs(tiepoint[3]-tt.originX) > 1e-6 || math.Abs(tiepoint[4]-tt.originY) > 1e-6 {
+				t.Errorf("tiepoint %v, want the raster point 0,0 at %g,%g", tiepoint, tt.originX, tt.originY)
+			}
+			if got := string(d.fields[tagGDALNoData].data); got != "9999\x00" {
+				t.Errorf("GDAL_NODATA %q, want \"9999\"", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,312 @@
+package cog
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"hstin/grib2tiles/internal/atomicfile"
+)
+
+// blockSize is the width and height of the internal tiles.
+const blockSize = 256
+
+// maxTIFFSize is the largest classic TIFF file.
+const maxTIFFSize = math.MaxUint32
+
+// TIFF field types.
+const (
+	typeASCII  = 2
+	typeShort  = 3
+	typeLong   = 4
+	typeDouble = 12
+)
+
+// TIFF and GeoTIFF tags.
+const (
+	tagNewSubfileType      = 254
+	tagImageWidth          = 256
+	tagImageLength         = 257
+	tagBitsPerSample       = 258
+	tagCompression         = 259
+	tagPhotometric         = 262
+	tagSamplesPerPixel     = 277
+	tagPlanarConfiguration = 284
+	tagTileWidth           = 322
+	tagTileLength          = 323
+	tagTileOffsets         = 324
+	tagTileByteCounts      = 325
+	tagSampleFormat        = 339
+	tagModelPixelScale     = 33550
+	tagModelTiepoint       = 33922
+	tagGeoKeyDirectory     = 34735
+	tagGDALNoData          = 42113
+)
+
+// Tag values.
+const (
+	compressionDeflate       = 8
+	photometricMinIsBlack    = 1
+	sampleFormatFloat        = 3
+	subfileReducedResolution = 1
+)
+
+// GeoTIFF keys and their values.
+const (
+	geoKeyModelType       = 1024
+	geoKeyRasterType      = 1025
+	geoKeyGeographicType  = 2048
+	geoKeyProjectedCSType = 3072
+
+	modelTypeProjected  = 1
+	modelTypeGeographic = 2
+	rasterPixelIsArea   = 1
+)
+
+// geoInfo places the base level of the raster.
+type geoInfo struct {
+	crs              CRS
+	originX, originY float64 // upper left corner
+	res              float64
+	noData           float32
+}
+
+type ifdEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	data  []byte // little endian values
+}
+
+type ifd struct {
+	entries []ifdEntry
+	tiles   [][]byte // compressed tiles
+}
+
+// writeTIFF writes the levels of a raster, the base level first, as a
+// Cloud-Optimized GeoTIFF: all IFDs at the start of the file, followed by
+// the tiles of the smallest overview up to those of the base level. The
+// file replaces any existing one once it is complete.
+func writeTIFF(filename string, levels []raster, geo geoInfo) error {
+	ifds := make([]*ifd, len(levels))
+	for i, level := range levels {
+		tiles, err := encodeTiles(level, geo.noData)
+		if err != nil {
+			return err
+		}
+		ifds[i] = &ifd{tiles: tiles, entries: levelEntries(level, i > 0)}
+		if i == 0 {
+			ifds[i].entries = append(ifds[i].entries, geoEntries(geo)...)
+		}
+		// The tile offsets and byte counts are filled in below.
+		ifds[i].entries = append(ifds[i].entries,
+			ifdEntry{tag: tagTileOffsets, typ: typeLong, count: uint32(len(tiles)), data: make([]byte, 4*len(tiles))},
+			ifdEntry{tag: tagTileByteCounts, typ: typeLong, count: uint32(len(tiles)), data: make([]byte, 4*len(tiles))})
+		sort.Slice(ifds[i].entries, func(a, b int) bool { return ifds[i].entries[a].tag < ifds[i].entries[b].tag })
+	}
+
+	// Lay out the IFDs, then the tiles from the smallest level up.
+	offset := int64(8)
+	ifdOffsets := make([]int64, len(ifds))
+	for i, d := range ifds {
+		ifdOffsets[i] = offset
+		offset += d.size()
+	}
+	for i := len(ifds) - 1; i >= 0; i-- {
+		d := ifds[i]
+		offsets, counts := d.entry(tagTileOffsets), d.entry(tagTileByteCounts)
+		for t, tile := range d.tiles {
+			binary.LittleEndian.PutUint32(offsets.data[4*t:], uint32(offset))
+			binary.LittleEndian.PutUint32(counts.data[4*t:], uint32(len(tile)))
+			offset += int64(len(tile))
+		}
+	}
+	if offset > maxTIFFSize {
+		return fmt.Errorf("GeoTIFF of %d bytes exceeds the size of a TIFF file, choose a coarser resolution", offset)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("II")
+	binary.Write(&buf, binary.LittleEndian, uint16(42))
+	binary.Write(&buf, binary.LittleEndian, uint32(ifdOffsets[0]))
+	for i, d := range ifds {
+		next := int64(0)
+		if i+1 < len(ifds) {
+			next = ifdOffsets[i+1]
+		}
+		d.encode(&buf, ifdOffsets[i], next)
+	}
+
+	out, err := atomicfile.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := out.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	for i := len(ifds) - 1; i >= 0; i-- {
+		for _, tile := range ifds[i].tiles {
+			if _, err := out.Write(tile); err != nil {
+				return err
+			}
+		}
+	}
+	return out.Commit()
+}
+
+// encodeTiles cuts a level into tiles, padding the tiles at the right and
+// bottom edges with nodata, and compresses them.
+func encodeTiles(r raster, noData float32) ([][]byte, error) {
+	across := (r.width + blockSize - 1) / blockSize
+	down := (r.height + blockSize - 1) / blockSize
+
+	tiles := make([][]byte, 0, across*down)
+	raw := make([]byte, 4*blockSize*blockSize)
+	for ty := 0; ty < down; ty++ {
+		for tx := 0; tx < across; tx++ {
+			for j := 0; j < blockSize; j++ {
+				for i := 0; i < blockSize; i++ {
+					x, y := tx*blockSize+i, ty*blockSize+j
+					value := noData
+					if x < r.width && y < r.height {
+						value = r.values[y*r.width+x]
+					}
+					binary.LittleEndian.PutUint32(raw[4*(j*blockSize+i):], math.Float32bits(value))
+				}
+			}
+
+			var buf bytes.Buffer
+			zw := zlib.NewWriter(&buf)
+			if _, err := zw.Write(raw); err != nil {
+				return nil, err
+			}
+			if err := zw.Close(); err != nil {
+				return nil, err
+			}
+			tiles = append(tiles, buf.Bytes())
+		}
+	}
+	return tiles, nil
+}
+
+// levelEntries returns the tags describing the image of a level.
+func levelEntries(r raster, overview bool) []ifdEntry {
+	subfileType := uint32(0)
+	if overview {
+		subfileType = subfileReducedResolution
+	}
+	return []ifdEntry{
+		longEntry(tagNewSubfileType, subfileType),
+		longEntry(tagImageWidth, uint32(r.width)),
+		longEntry(tagImageLength, uint32(r.height)),
+		shortEntry(tagBitsPerSample, 32),
+		shortEntry(tagCompression, compressionDeflate),
+		shortEntry(tagPhotometric, photometricMinIsBlack),
+		shortEntry(tagSamplesPerPixel, 1),
+		shortEntry(tagPlanarConfiguration, 1),
+		shortEntry(tagTileWidth, blockSize),
+		shortEntry(tagTileLength, blockSize),
+		shortEntry(tagSampleFormat, sampleFormatFloat),
+	}
+}
+
+// geoEntries returns the GeoTIFF tags of the base level and its nodata
+// value.
+func geoEntries(geo geoInfo) []ifdEntry {
+	keys := []uint16{
+		1, 1, 0, 3, // version 1.1.0, three keys
+		geoKeyModelType, 0, 1, modelTypeProjected,
+		geoKeyRasterType, 0, 1, rasterPixelIsArea,
+		geoKeyProjectedCSType, 0, 1, uint16(geo.crs),
+	}
+	if geo.crs == WGS84 {
+		keys[7] = modelTypeGeographic
+		keys[12] = geoKeyGeographicType
+	}
+
+	return []ifdEntry{
+		doubleEntry(tagModelPixelScale, geo.res, geo.res, 0),
+		doubleEntry(tagModelTiepoint, 0, 0, 0, geo.originX, geo.originY, 0),
+		shortEntry(tagGeoKeyDirectory, keys...),
+		asciiEntry(tagGDALNoData, strconv.FormatFloat(float64(geo.noData), 'g', -1, 32)),
+	}
+}
+
+func shortEntry(tag uint16, values ...uint16) ifdEntry {
+	data := make([]byte, 2*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint16(data[2*i:], v)
+	}
+	return ifdEntry{tag: tag, typ: typeShort, count: uint32(len(values)), data: data}
+}
+
+func longEntry(tag uint16, value uint32) ifdEntry {
+	return ifdEntry{tag: tag, typ: typeLong, count: 1, data: binary.LittleEndian.AppendUint32(nil, value)}
+}
+
+func doubleEntry(tag uint16, values ...float64) ifdEntry {
+	data := make([]byte, 8*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint64(data[8*i:], math.Float64bits(v))
+	}
+	return ifdEntry{tag: tag, typ: typeDouble, count: uint32(len(values)), data: data}
+}
+
+func asciiEntry(tag uint16, s string) ifdEntry {
+	return ifdEntry{tag: tag, typ: typeASCII, count: uint32(len(s) + 1), data: append([]byte(s), 0)}
+}
+
+func (d *ifd) entry(tag uint16) *ifdEntry {
+	for i := range d.entries {
+		if d.entries[i].tag == tag {
+			return &d.entries[i]
+		}
+	}
+	return nil
+}
+
+// size returns the size of the IFD with the values that do not fit into
+// its entries, which follow it at word boundaries.
+func (d *ifd) size() int64 {
+	size := int64(2 + 12*len(d.entries) + 4)
+	for _, e := range d.entries {
+		if len(e.data) > 4 {
+			size += int64(len(e.data)+1) &^ 1
+		}
+	}
+	return size
+}
+
+// encode appends the IFD at offset to buf, linking it to the next IFD.
+func (d *ifd) encode(buf *bytes.Buffer, offset, next int64) {
+	le := binary.LittleEndian
+	extra := offset + int64(2+12*len(d.entries)+4)
+
+	var values []byte
+	binary.Write(buf, le, uint16(len(d.entries)))
+	for _, e := range d.entries {
+		binary.Write(buf, le, e.tag)
+		binary.Write(buf, le, e.typ)
+		binary.Write(buf, le, e.count)
+
+		if len(e.data) <= 4 {
+			field := make([]byte, 4)
+			copy(field, e.data)
+			buf.Write(field)
+			continue
+		}
+		binary.Write(buf, le, uint32(extra+int64(len(values))))
+		values = append(values, e.data...)
+		if len(values)%2 == 1 {
+			values = append(values, 0)
+		}
+	}
+	binary.Write(buf, le, uint32(next))
+	buf.Write(values)
+}
@@ -0,0 +1,338 @@
+package colormap
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image/color"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type ColorMapEntry struct {
+	ValueThreshold float64
+	Color          color.RGBA
+	Relative       bool // ValueThreshold is a percentage of the range of the field
+}
+
+var defaultColor = color.RGBA{13, 26, 43, 255}
+
+// ColorMap maps the values of a field to colors. It is not modified after
+// loading and may be shared by concurrent renderers.
+type ColorMap struct {
+	entries  []ColorMapEntry // resolved and in ascending order
+	source   []ColorMapEntry // as loaded, before WithRange
+	relative bool            // some source thresholds are percentages
+	sorted   bool            // source entries may be listed in any order
+
+	// gradient interpolates colors between the thresholds instead of
+	// stepping, in the OKLab color space if oklab is set.
+	gradient bool
+	oklab    bool
+
+	// Optional colors of missing values, of values below the first
+	// threshold and of values above the last one, or at or above it for
+	// gradients.
+	noData, below, above *color.RGBA
+}
+
+// Load reads a color map file, or a built-in palette given as "name:" and
+// its name. Besides the threshold format of this project it reads GDAL
+// color-relief, GMT CPT and QGIS color map export files, see detectFormat.
+func Load(filename string) (*ColorMap, error) {
+	if name, ok := strings.CutPrefix(filename, PalettePrefix); ok {
+		filename, data, err := readPalette(name)
+		if err != nil {
+			return nil, err
+		}
+		return parse(filename, data)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening color map file: %v", err)
+	}
+	return parse(filename, data)
+}
+
+// Parse reads a color map in any of the formats read by Load.
+func Parse(data []byte) (*ColorMap, error) {
+	return parse("", data)
+}
+
+func parse(filename string, data []byte) (*ColorMap, error) {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimSpace(scanner.Text()))
+	}
+
+	m := &ColorMap{}
+	format := detectFormat(filename, lines)
+	var err error
+	switch format {
+	case formatGDAL:
+		err = m.parseGDAL(lines)
+	case formatCPT:
+		err = m.parseCPT(lines)
+	case formatQGIS:
+		err = m.parseQGIS(lines)
+	default:
+		err = m.parseThresholds(lines)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(m.entries) == 0 {
+		return nil, fmt.Errorf("no valid entries found in color map file")
+	}
+
+	// The other formats need not list their entries in ascending order.
+	m.sorted = format != formatThresholds
+	m.source = m.entries
+	for _, entry := range m.source {
+		m.relative = m.relative || entry.Relative
+	}
+	m.entries = m.resolve(0, 100)
+	return m, nil
+}
+
+// WithRange returns a copy of the color map whose thresholds that are
+// percentages of the range of the field, as allowed by GDAL color-relief
+// files, are resolved against the minimum and maximum value of the field to
+// be rendered. It returns m itself when there are no such thresholds.
+func (m *ColorMap) WithRange(minValue, maxValue float64) *ColorMap {
+	if !m.relative {
+		return m
+	}
+	resolved := *m
+	resolved.entries = m.resolve(minValue, maxValue)
+	return &resolved
+}
+
+func (m *ColorMap) resolve(minValue, maxValue float64) []ColorMapEntry {
+	entries := make([]ColorMapEntry, len(m.source))
+	for i, entry := range m.source {
+		if entry.Relative {
+			entry.ValueThreshold = minValue + (maxValue-minValue)*entry.ValueThreshold/100
+			entry.Relative = false
+		}
+		entries[i] = entry
+	}
+
+	if m.sorted {
+		sortByThreshold(entries)
+	}
+	return entries
+}
+
+// sortByThreshold sorts entries by threshold, keeping the order of entries
+// with equal thresholds, such as the ends of adjacent CPT slices.
+func sortByThreshold(entries []ColorMapEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].ValueThreshold < entries[j].ValueThreshold
+	})
+}
+
+// parseThresholds reads the threshold format of this project.
+func (m *ColorMap) parseThresholds(lines []string) error {
+	for _, line := range lines {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "mode":
+			if err := m.parseMode(fields[1:]); err != nil {
+				return err
+			}
+			continue
+		case "nodata", "below", "above":
+			c, err := parseRGBA(fields[1:])
+			if err != nil {
+				return fmt.Errorf("invalid %s color: %v", fields[0], err)
+			}
+			switch fields[0] {
+			case "nodata":
+				m.noData = &c
+			case "below":
+				m.below = &c
+			case "above":
+				m.above = &c
+			}
+			continue
+		}
+
+		if len(fields) < 5 {
+			log.Printf("Warning: Invalid line format in color map file: %s", line)
+			continue
+		}
+
+		var threshold float64
+		if fields[0] == "-inf" {
+			threshold = math.Inf(-1)
+		} else {
+			val, err := strconv.ParseFloat(fields[0], 64)
+			if err != nil {
+				log.Printf("Warning: Invalid threshold value: %s", fields[0])
+				continue
+			}
+			threshold = val
+		}
+
+		r, _ := strconv.Atoi(fields[1])
+		g, _ := strconv.Atoi(fields[2])
+		b, _ := strconv.Atoi(fields[3])
+		a, _ := strconv.Atoi(fields[4])
+
+		m.entries = append(m.entries, ColorMapEntry{
+			ValueThreshold: threshold,
+			Color:          color.RGBA{uint8(r), uint8(g), uint8(b), uint8(a)},
+		})
+	}
+
+	return nil
+}
+
+// parseMode reads a mode directive, "mode steps" or "mode gradient",
+// optionally followed by the color space "rgb" or "oklab" of the gradient.
+func (m *ColorMap) parseMode(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("mode directive without a mode")
+	}
+
+	switch args[0] {
+	case "steps":
+		m.gradient = false
+	case "gradient":
+		m.gradient = true
+	default:
+		return fmt.Errorf("unknown color map mode: %s", args[0])
+	}
+
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "#") {
+			break
+		}
+		switch arg {
+		case "rgb":
+			m.oklab = false
+		case "oklab":
+			m.oklab = true
+		default:
+			return fmt.Errorf("unknown color space: %s", arg)
+		}
+	}
+	return nil
+}
+
+// parseRGBA reads the R G B A components of a color.
+func parseRGBA(fields []string) (color.RGBA, error) {
+	if len(fields) < 4 {
+		return color.RGBA{}, fmt.Errorf("expected R G B A")
+	}
+
+	var c [4]uint8
+	for i := range c {
+		v, err := strconv.Atoi(fields[i])
+		if err != nil || v < 0 || v > 255 {
+			return color.RGBA{}, fmt.Errorf("invalid color component: %s", fields[i])
+		}
+		c[i] = uint8(v)
+	}
+	return color.RGBA{c[0], c[1], c[2], c[3]}, nil
+}
+
+// NoDataColor returns the color of missing values, and whether the color map
+// defines one. Missing values are transparent otherwise.
+func (m *ColorMap) NoDataColor() (color.RGBA, bool) {
+	if m.noData == nil {
+		return color.RGBA{}, false
+	}
+	return *m.noData, true
+}
+
+// Color returns the color of a value. NaN is treated as a missing value.
+func (m *ColorMap) Color(value float64) color.RGBA {
+	if math.IsNaN(value) {
+		c, _ := m.NoDataColor()
+		return c
+	}
+
+	if len(m.entries) > 0 {
+		if m.below != nil && value < m.entries[0].ValueThreshold {
+			return *m.below
+		}
+		// Stepped maps draw the last entry for the values at its threshold
+		// and above, as GDAL does, so only larger values take the above
+		// color. Gradients end at the last threshold.
+		last := m.entries[len(m.entries)-1].ValueThreshold
+		if m.above != nil && (value > last || m.gradient && value == last) {
+			return *m.above
+		}
+	}
+
+	if m.gradient {
+		return m.gradientColor(value)
+	}
+
+	for i := len(m.entries) - 1; i >= 0; i-- {
+		if value >= m.entries[i].ValueThreshold {
+			return m.entries[i].Color
+		}
+	}
+
+	if len(m.entries) > 0 {
+		return m.entries[0].Color
+	}
+
+	return defaultColor
+}
+
+// gradientColor interpolates linearly between the colors of the thresholds
+// around value. Values outside the thresholds take the color of the first or
+// last one, and values below a -inf entry are not interpolated.
+func (m *ColorMap) gradientColor(value float64) color.RGBA {
+	if len(m.entries) == 0 {
+		return defaultColor
+	}
+
+	upper := len(m.entries)
+	for i, entry := range m.entries {
+		if value < entry.ValueThreshold {
+			upper = i
+			break
+		}
+	}
+
+	switch {
+	case upper == 0:
+		return m.entries[0].Color
+	case upper == len(m.entries):
+		return m.entries[len(m.entries)-1].Color
+	}
+
+	low, high := m.entries[upper-1], m.entries[upper]
+	if math.IsInf(low.ValueThreshold, -1) {
+		return low.Color
+	}
+
+	t := (value - low.ValueThreshold) / (high.ValueThreshold - low.ValueThreshold)
+	if m.oklab {
+		return mixOKLab(low.Color, high.Color, t)
+	}
+	return mixRGB(low.Color, high.Color, t)
+}
+
+func mixRGB(a, b color.RGBA, t float64) color.RGBA {
+	mix := func(x, y uint8) uint8 {
+		return uint8(math.Round(float64(x) + (float64(y)-float64(x))*t))
+	}
+	return color.RGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), mix(a.A, b.A)}
+}
@@ -0,0 +1,99 @@
+package colormap
+
+import (
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestColorBoundaries(t *testing.T) {
+	var (
+		below = color.RGBA{0, 0, 255, 255}
+		low   = color.RGBA{10, 10, 10, 255}
+		mid   = color.RGBA{20, 20, 20, 255}
+		high  = color.RGBA{30, 30, 30, 255}
+		above = color.RGBA{255, 0, 0, 255}
+		none  = color.RGBA{0, 0, 0, 0}
+	)
+
+	m, err := Parse([]byte(`0 10 10 10 255
+10 20 20 20 255
+20 30 30 30 255
+below 0 0 255 255
+above 255 0 0 255
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := Parse([]byte(`0 10 10 10 255
+10 20 20 20 255
+20 30 30 30 255
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gradient, err := Parse([]byte(`mode gradient
+0 10 10 10 255
+10 20 20 20 255
+20 30 30 30 255
+above 255 0 0 255
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		m     *ColorMap
+		value float64
+		want  color.RGBA
+	}{
+		{"below the first threshold", m, math.Nextafter(0, -1), below},
+		{"at the first threshold", m, 0, low},
+		{"just below a threshold", m, math.Nextafter(10, 0), low},
+		{"at a threshold", m, 10, mid},
+		{"just below the last threshold", m, math.Nextafter(20, 0), mid},
+		{"at the last threshold", m, 20, high},
+		{"just above the last threshold", m, math.Nextafter(20, 21), above},
+		{"above the last threshold", m, 21, above},
+		{"missing", m, math.NaN(), none},
+		{"below without a below color", plain, -1, low},
+		{"at the last threshold without an above color", plain, 20, high},
+		{"above without an above color", plain, 21, high},
+		{"just below the last threshold of a gradient", gradient, math.Nextafter(20, 0), high},
+		{"at the last threshold of a gradient", gradient, 20, above},
+	}
+	for _, tt := range tests {
+		if got := tt.m.Color(tt.value); got != tt.want {
+			t.Errorf("%s: Color(%g) = %v, want %v", tt.name, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want int
+	}{
+		{"thresholds", "-inf 0 0 0 255\n0 10 10 10 255\n", formatThresholds},
+		{"thresholds with a later three component line", "0 10 10 10 255\n10 20 20 20\n", formatThresholds},
+		{"thresholds with a later comma", "# comment\nbelow 0 0 0 255\n0 10 10 10 255\n10 20,20,20 255\n", formatThresholds},
+		{"gdal", "0 10 10 10\n10 20 20 20 255\n", formatGDAL},
+		{"gdal percentages", "0% blue\n100% red\n", formatGDAL},
+		{"gdal nv first", "nv 0 0 0 0\n0 10 10 10 255\n", formatGDAL},
+		{"cpt slices", "0 0/0/0 10 255/255/255\n", formatCPT},
+		{"cpt background after the slices", "0 0 0 0 10 255 255 255\nB 0 0 0\n", formatCPT},
+		{"qgis", "# QGIS Generated Color Map Export File\nINTERPOLATION:DISCRETE\n0,10,10,10,255,0\n", formatQGIS},
+	}
+	for _, tt := range tests {
+		var lines []string
+		for _, line := range strings.Split(tt.data, "\n") {
+			lines = append(lines, strings.TrimSpace(line))
+		}
+		if got := detectFormat("", lines); got != tt.want {
+			t.Errorf("%s: detectFormat = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
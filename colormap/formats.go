@@ -0,0 +1,371 @@
+package colormap
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	formatThresholds = iota
+	formatGDAL
+	formatCPT
+	formatQGIS
+)
+
+// namedColors are the color names understood by gdaldem and GMT.
+var namedColors = map[string]color.RGBA{
+	"white":   {255, 255, 255, 255},
+	"black":   {0, 0, 0, 255},
+	"red":     {255, 0, 0, 255},
+	"green":   {0, 255, 0, 255},
+	"blue":    {0, 0, 255, 255},
+	"yellow":  {255, 255, 0, 255},
+	"magenta": {255, 0, 255, 255},
+	"cyan":    {0, 255, 255, 255},
+	"aqua":    {0, 192, 192, 255},
+	"grey":    {190, 190, 190, 255},
+	"gray":    {190, 190, 190, 255},
+	"orange":  {255, 127, 0, 255},
+	"brown":   {165, 42, 42, 255},
+	"purple":  {127, 0, 127, 255},
+	"violet":  {143, 0, 255, 255},
+	"indigo":  {75, 0, 130, 255},
+}
+
+// detectFormat tells the format of a color map file:
+//   - QGIS color map exports have an INTERPOLATION line,
+//   - GMT CPT files have the .cpt extension, a COLOR_MODEL comment, B, F or N
+//     lines or slices of eight fields,
+//   - GDAL color-relief files have entries without alpha, separated by
+//     commas, or with percentages, nv or color names.
+//
+// Anything else is the threshold format of this project. Only the first
+// entry tells the format of the entries, the parser reports later entries
+// that do not fit it rather than reading the whole file differently. GDAL
+// files whose first entry has four components are read in the threshold
+// format and only differ in interpolating, which "mode gradient" restores.
+func detectFormat(filename string, lines []string) int {
+	if strings.EqualFold(filepath.Ext(filename), ".cpt") {
+		return formatCPT
+	}
+
+	format := -1
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "INTERPOLATION:") || strings.Contains(line, "QGIS Generated Color Map"):
+			return formatQGIS
+		case strings.HasPrefix(line, "#"):
+			if strings.Contains(line, "COLOR_MODEL") {
+				return formatCPT
+			}
+			continue
+		case line == "":
+			continue
+		}
+
+		fields := strings.Fields(stripComment(line))
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "mode", "nodata", "below", "above":
+			continue
+		case "B", "F", "N":
+			return formatCPT
+		}
+		if format >= 0 {
+			continue
+		}
+		switch {
+		case len(fields) == 8 || strings.Contains(line, "/"):
+			format = formatCPT
+		case strings.Contains(line, ",") || len(fields) < 5 || fields[0] == "nv" || strings.HasSuffix(fields[0], "%"):
+			format = formatGDAL
+		default:
+			format = formatThresholds
+		}
+	}
+	if format < 0 {
+		return formatThresholds
+	}
+	return format
+}
+
+func stripComment(line string) string {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
+
+// parseGDAL reads a gdaldem color-relief file, whose entries are a value
+// and a color separated by spaces, tabs or commas. The value may be a
+// percentage of the range of the field, or nv for missing values. The color
+// is R G B with an optional A, or a color name. GDAL interpolates between
+// the entries.
+func (m *ColorMap) parseGDAL(lines []string) error {
+	m.gradient = true
+
+	for _, line := range lines {
+		fields := strings.FieldsFunc(stripComment(line), func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ','
+		})
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return fmt.Errorf("invalid color-relief entry: %s", line)
+		}
+
+		c, err := parseComponents(fields[1:], false)
+		if err != nil {
+			return fmt.Errorf("invalid color-relief entry %q: %v", line, err)
+		}
+
+		entry := ColorMapEntry{Color: c}
+		value := fields[0]
+		switch {
+		case value == "nv":
+			m.noData = &c
+			continue
+		case strings.HasSuffix(value, "%"):
+			entry.Relative = true
+			value = strings.TrimSuffix(value, "%")
+		}
+
+		entry.ValueThreshold, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid color-relief value: %s", fields[0])
+		}
+		m.entries = append(m.entries, entry)
+	}
+	return nil
+}
+
+// parseComponents reads a color given as R G B with an optional A, or as a
+// color name. In HSV mode the components are hue in degrees and saturation
+// and value between 0 and 1.
+func parseComponents(fields []string, hsv bool) (color.RGBA, error) {
+	if len(fields) == 1 {
+		if c, ok := namedColors[strings.ToLower(fields[0])]; ok {
+			return c, nil
+		}
+	}
+	if len(fields) < 3 || len(fields) > 4 {
+		return color.RGBA{}, fmt.Errorf("expected R G B [A]")
+	}
+
+	var v [4]float64
+	v[3] = 255
+	for i, field := range fields {
+		f, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return color.RGBA{}, fmt.Errorf("invalid color component: %s", field)
+		}
+		v[i] = f
+	}
+
+	if hsv {
+		return hsvToRGB(v[0], v[1], v[2]), nil
+	}
+
+	clamp := func(f float64) uint8 { return uint8(math.Round(math.Max(0, math.Min(255, f)))) }
+	return color.RGBA{clamp(v[0]), clamp(v[1]), clamp(v[2]), clamp(v[3])}, nil
+}
+
+func hsvToRGB(h, s, v float64) color.RGBA {
+	h = math.Mod(h, 360)
+	if h < 0 {
+		h += 360
+	}
+	c := v * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := v - c
+
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+
+	component := func(f float64) uint8 { return uint8(math.Round((f + m) * 255)) }
+	return color.RGBA{component(r), component(g), component(b), 255}
+}
+
+// parseCPT reads a GMT color palette table. Each slice "z0 color0 z1 color1"
+// varies linearly from color0 at z0 to color1 at z1, and the B, F and N
+// lines set the colors below the first slice, above the last one and of
+// missing values. Colors are R G B, R/G/B, a gray level or a name, or
+// hue, saturation and value when the COLOR_MODEL comment is HSV, and may
+// have an @transparency percentage.
+func (m *ColorMap) parseCPT(lines []string) error {
+	m.gradient = true
+	hsv := false
+
+	for _, line := range lines {
+		if strings.HasPrefix(line, "#") {
+			if strings.Contains(line, "COLOR_MODEL") && strings.Contains(strings.ToUpper(line), "HSV") {
+				hsv = true
+			}
+			continue
+		}
+
+		// Drop labels and the L, U and B annotation flags.
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if n := len(fields); n > 0 && (fields[n-1] == "L" || fields[n-1] == "U" || (fields[n-1] == "B" && n > 2)) {
+			fields = fields[:n-1]
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "B", "F", "N":
+			c, err := parseCPTColor(fields[1:], hsv)
+			if err != nil {
+				return fmt.Errorf("invalid CPT line %q: %v", line, err)
+			}
+			switch fields[0] {
+			case "B":
+				m.below = &c
+			case "F":
+				m.above = &c
+			case "N":
+				m.noData = &c
+			}
+			continue
+		}
+
+		// z0 color0 z1 color1, with colors of one or three fields.
+		width := (len(fields) - 2) / 2
+		if len(fields) != 2*width+2 || (width != 1 && width != 3) {
+			return fmt.Errorf("invalid CPT slice: %s", line)
+		}
+
+		z0, err0 := strconv.ParseFloat(fields[0], 64)
+		z1, err1 := strconv.ParseFloat(fields[width+1], 64)
+		if err0 != nil || err1 != nil {
+			return fmt.Errorf("invalid CPT slice: %s", line)
+		}
+		c0, err0 := parseCPTColor(fields[1:width+1], hsv)
+		c1, err1 := parseCPTColor(fields[width+2:], hsv)
+		if err0 != nil || err1 != nil {
+			return fmt.Errorf("invalid CPT slice: %s", line)
+		}
+
+		m.entries = append(m.entries,
+			ColorMapEntry{ValueThreshold: z0, Color: c0},
+			ColorMapEntry{ValueThreshold: z1, Color: c1})
+	}
+	return nil
+}
+
+// parseCPTColor reads a CPT color of one or three fields.
+func parseCPTColor(fields []string, hsv bool) (color.RGBA, error) {
+	alpha := uint8(255)
+	if n := len(fields); n > 0 {
+		if i := strings.IndexByte(fields[n-1], '@'); i >= 0 {
+			transparency, err := strconv.ParseFloat(fields[n-1][i+1:], 64)
+			if err != nil {
+				return color.RGBA{}, fmt.Errorf("invalid transparency: %s", fields[n-1])
+			}
+			alpha = uint8(math.Round(255 * (1 - math.Max(0, math.Min(100, transparency))/100)))
+			fields = append(append([]string(nil), fields[:n-1]...), fields[n-1][:i])
+		}
+	}
+
+	if len(fields) == 1 {
+		switch {
+		case strings.Contains(fields[0], "/"):
+			fields = strings.Split(fields[0], "/")
+		case hsv && strings.Count(fields[0], "-") == 2:
+			fields = strings.Split(fields[0], "-")
+		default:
+			if _, named := namedColors[strings.ToLower(fields[0])]; !named {
+				// A gray level.
+				fields = []string{fields[0], fields[0], fields[0]}
+			}
+		}
+	}
+	if len(fields) == 4 {
+		return color.RGBA{}, fmt.Errorf("expected a color of three components")
+	}
+
+	c, err := parseComponents(fields, hsv)
+	c.A = alpha
+	return c, err
+}
+
+// parseQGIS reads a color map exported from the QGIS raster renderer, with
+// "value,R,G,B,A,label" entries. INTERPOLATED maps are gradients, DISCRETE
+// maps color the values up to each entry with its color, and EXACT maps
+// color the values of the entries, which stepping does for integer classes.
+func (m *ColorMap) parseQGIS(lines []string) error {
+	discrete := false
+
+	for _, line := range lines {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if mode, ok := strings.CutPrefix(line, "INTERPOLATION:"); ok {
+			switch strings.TrimSpace(mode) {
+			case "INTERPOLATED":
+				m.gradient = true
+			case "DISCRETE":
+				discrete = true
+			case "EXACT":
+			default:
+				return fmt.Errorf("unknown QGIS interpolation: %s", mode)
+			}
+			continue
+		}
+
+		fields := strings.Split(line, ",")
+		if len(fields) < 5 {
+			return fmt.Errorf("invalid QGIS color map entry: %s", line)
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(fields[0]), 64)
+		if err != nil {
+			return fmt.Errorf("invalid QGIS color map value: %s", fields[0])
+		}
+		for i := 1; i < 5; i++ {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		c, err := parseComponents(fields[1:5], false)
+		if err != nil {
+			return fmt.Errorf("invalid QGIS color map entry %q: %v", line, err)
+		}
+
+		m.entries = append(m.entries, ColorMapEntry{ValueThreshold: value, Color: c})
+	}
+
+	if discrete {
+		// Each entry is the upper bound of its class, move the thresholds
+		// to the lower bounds.
+		sortByThreshold(m.entries)
+		for i := len(m.entries) - 1; i > 0; i-- {
+			m.entries[i].ValueThreshold = m.entries[i-1].ValueThreshold
+		}
+		if len(m.entries) > 0 {
+			m.entries[0].ValueThreshold = math.Inf(-1)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package colormap
+
+import (
+	"image/color"
+	"math"
+)
+
+// OKLab is a perceptual color space, in which gradients change lightness and
+// hue evenly (https://bottosson.github.io/posts/oklab/).
+type okLab struct {
+	l, a, b float64
+}
+
+func toLinear(c uint8) float64 {
+	v := float64(c) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func fromLinear(v float64) uint8 {
+	if v <= 0.0031308 {
+		v *= 12.92
+	} else {
+		v = 1.055*math.Pow(v, 1/2.4) - 0.055
+	}
+	return uint8(math.Round(math.Max(0, math.Min(1, v)) * 255))
+}
+
+func rgbToOKLab(c color.RGBA) okLab {
+	r, g, b := toLinear(c.R), toLinear(c.G), toLinear(c.B)
+
+	l := math.Cbrt(0.4122214708*r + 0.5363325363*g + 0.0514459929*b)
+	m := math.Cbrt(0.2119034982*r + 0.6806995451*g + 0.1073969566*b)
+	s := math.Cbrt(0.0883024619*r + 0.2817188376*g + 0.6299787005*b)
+
+	return okLab{
+		l: 0.2104542553*l + 0.7936177850*m - 0.0040720468*s,
+		a: 1.9779984951*l - 2.4285922050*m + 0.4505937099*s,
+		b: 0.0259040371*l + 0.7827717662*m - 0.8086757660*s,
+	}
+}
+
+func (c okLab) rgba(alpha uint8) color.RGBA {
+	l := c.l + 0.3963377774*c.a + 0.2158037573*c.b
+	m := c.l - 0.1055613458*c.a - 0.0638541728*c.b
+	s := c.l - 0.0894841775*c.a - 1.2914855480*c.b
+	l, m, s = l*l*l, m*m*m, s*s*s
+
+	return color.RGBA{
+		fromLinear(4.0767416621*l - 3.3077115913*m + 0.2309699292*s),
+		fromLinear(-1.2684380046*l + 2.6097574011*m - 0.3413193965*s),
+		fromLinear(-0.0041960863*l - 0.7034186147*m + 1.7076147010*s),
+		alpha,
+	}
+}
+
+// mixOKLab interpolates between two colors in OKLab, and linearly in alpha.
+func mixOKLab(a, b color.RGBA, t float64) color.RGBA {
+	ca, cb := rgbToOKLab(a), rgbToOKLab(b)
+	mixed := okLab{
+		l: ca.l + (cb.l-ca.l)*t,
+		a: ca.a + (cb.a-ca.a)*t,
+		b: ca.b + (cb.b-ca.b)*t,
+	}
+	alpha := uint8(math.Round(float64(a.A) + (float64(b.A)-float64(a.A))*t))
+	return mixed.rgba(alpha)
+}
@@ -0,0 +1,75 @@
+package colormap
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// PalettePrefix selects a built-in palette instead of a file, as in
+// "name:precip".
+const PalettePrefix = "name:"
+
+//go:embed palettes/*.txt
+var palettes embed.FS
+
+// PaletteNames returns the names of the built-in palettes.
+func PaletteNames() []string {
+	files, _ := palettes.ReadDir("palettes")
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, strings.TrimSuffix(file.Name(), ".txt"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func readPalette(name string) (string, []byte, error) {
+	filename := path.Join("palettes", name+".txt")
+	data, err := palettes.ReadFile(filename)
+	if err != nil {
+		return "", nil, fmt.Errorf("unknown palette %q, available: %s", name, strings.Join(PaletteNames(), ", "))
+	}
+	return filename, data, nil
+}
+
+// parameterPalettes maps GRIB2 parameters (discipline, category, number) to
+// the palettes suited to their units.
+var parameterPalettes = map[[3]int]string{
+	{0, 0, 0}:    "temperature",  // temperature
+	{0, 0, 2}:    "temperature",  // potential temperature
+	{0, 0, 4}:    "temperature",  // maximum temperature
+	{0, 0, 5}:    "temperature",  // minimum temperature
+	{0, 0, 6}:    "temperature",  // dew point temperature
+	{0, 0, 17}:   "temperature",  // skin temperature
+	{0, 1, 1}:    "rh",           // relative humidity
+	{0, 1, 7}:    "precip",       // precipitation rate
+	{0, 1, 8}:    "precip-total", // total precipitation
+	{0, 1, 52}:   "precip",       // total precipitation rate
+	{0, 1, 65}:   "precip",       // rain precipitation rate
+	{0, 2, 1}:    "wind",         // wind speed
+	{0, 2, 22}:   "wind",         // wind speed (gust)
+	{0, 6, 1}:    "cloud",        // total cloud cover
+	{0, 6, 3}:    "cloud",        // low cloud cover
+	{0, 6, 4}:    "cloud",        // medium cloud cover
+	{0, 6, 5}:    "cloud",        // high cloud cover
+	{0, 6, 22}:   "cloud",        // cloud cover
+	{0, 7, 6}:    "cape",         // convective available potential energy
+	{0, 15, 1}:   "reflectivity", // base reflectivity
+	{0, 16, 4}:   "reflectivity", // reflectivity
+	{0, 16, 5}:   "reflectivity", // composite reflectivity
+	{0, 16, 195}: "reflectivity", // NCEP reflectivity
+	{0, 16, 196}: "reflectivity", // NCEP composite reflectivity
+}
+
+// DefaultPalette returns the name of the built-in palette for a GRIB2
+// parameter, or viridis over the range of the field for parameters without
+// a palette of their own.
+func DefaultPalette(discipline, category, number int) string {
+	if name, ok := parameterPalettes[[3]int{discipline, category, number}]; ok {
+		return name
+	}
+	return "viridis"
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hstin/grib2tiles/parser"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+type messageInfo struct {
+	Message int `json:"message"`
+	*parser.GribHeader
+	Stats *parser.Stats `json:"stats,omitempty"`
+	Error string        `json:"error,omitempty"` // set instead of the header if the message could not be decoded
+}
+
+func runInfo(args []string) {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s info [options] input.grib\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Lists the messages contained in a GRIB file.\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+	jsonOutput := fs.Bool("json", false, "Print the inventory as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fmt.Fprintf(os.Stderr, "Error: Missing input file\n")
+		fs.Usage()
+		os.Exit(1)
+	}
+	inputFile := fs.Arg(0)
+
+	gribFiles, err := parser.ProcessGRIBFile(inputFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to load GRIB file: %v\n", err)
+		os.Exit(1)
+	}
+
+	inventory := make([]messageInfo, len(gribFiles))
+	for i, g := range gribFiles {
+		inventory[i] = messageInfo{Message: i + 1}
+		if g.Err != nil {
+			inventory[i].Error = errors.Unwrap(g.Err).Error()
+			continue
+		}
+		stats := g.Stats()
+		inventory[i].GribHeader = &g.Header
+		inventory[i].Stats = &stats
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(inventory); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	printInventory(inventory)
+}
+
+func printInventory(inventory []messageInfo) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tshortName\tparameter\ttypeOfLevel\tlevel\tstep\tdataTime\tvalidTime\tgridType\tgrid\tla1\tla2\tlo1\tlo2\tdx\tdy\tscan\tmissing\tmin\tmax\tmean\t")
+
+	for _, m := range inventory {
+		if m.Error != "" {
+			fmt.Fprintf(w, "%d\terror: %s\n", m.Message, m.Error)
+			continue
+		}
+		fmt.Fprintf(w, "%d\t%s\t%d.%d.%d\t%s\t%d\t%d\t%s\t%s\t%s\t%dx%d\t%.4f\t%.4f\t%.4f\t%.4f\t%.4f\t%.4f\t%d\t%g\t%.4g\t%.4g\t%.4g\t\n",
+			m.Message, m.ShortName, m.Discipline, m.ParameterCategory, m.ParameterNumber,
+			m.TypeOfLevel, m.Level, m.EndStep,
+			m.DataTime.Format(time.RFC3339), formatTime(m.ValidTime),
+			m.GridType, m.Nx, m.Ny, m.La1, m.La2, m.Lo1, m.Lo2, m.DX, m.DY, m.ScanMode, m.MissingValue,
+			m.Stats.Min, m.Stats.Max, m.Stats.Mean)
+	}
+
+	w.Flush()
+}
+
+// formatTime formats t for the inventory table, or "-" if it is unknown.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,63 @@
+// Package atomicfile writes output files under a temporary name next to
+// them and renames them into place once they are complete, so readers of
+// the file never see it half-written and a failed run leaves the previous
+// file untouched.
+package atomicfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// File is a temporary file that replaces its destination on Commit.
+type File struct {
+	*os.File
+	dest string
+	done bool
+}
+
+// Create creates an empty temporary file in the directory of dest.
+func Create(dest string) (*File, error) {
+	f, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &File{File: f, dest: dest}, nil
+}
+
+// Commit closes the file, unless it has been closed already, and renames
+// it over its destination. The file gets the permissions of the file it
+// replaces, or 0644.
+func (f *File) Commit() error {
+	if err := f.File.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+		return err
+	}
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(f.dest); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.Chmod(f.Name(), mode); err != nil {
+		return err
+	}
+
+	if err := os.Rename(f.Name(), f.dest); err != nil {
+		return err
+	}
+	f.done = true
+	return nil
+}
+
+// Close removes the file unless it has been committed.
+func (f *File) Close() error {
+	if f.done {
+		return nil
+	}
+	err := f.File.Close()
+	os.Remove(f.Name())
+	if errors.Is(err, os.ErrClosed) {
+		return nil
+	}
+	return err
+}
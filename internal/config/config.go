@@ -1,20 +1,28 @@
 package config
 
+import "hstin/grib2tiles/colormap"
+
 type Config struct {
-	GribFile   string
-	ColorMap   string
-	OutputFile string
-	MinZoom    int
-	MaxZoom    int
-	NumWorkers int
-	Bounds     [4]float64 // [minLat, minLon, maxLat, maxLon]
-	Quality    int
-	Verbose    bool
+	GribFile     string
+	GridFile     string             // grid file with the cell coordinates of unstructured grids
+	ColorMap     string             // color map file or "name:" palette, chosen per message when empty
+	Colors       *colormap.ColorMap // loaded color map, takes precedence over ColorMap
+	OutputFile   string
+	Format       string  // output format, see render.OutputFormats; from the extension of OutputFile when empty
+	TileTemplate string  // path of the tiles in a directory tree, tiles.DefaultTemplate when empty
+	TMS          bool    // number the rows of a directory tree from the south
+	Update       bool    // add tiles to an existing MBTiles, replacing tiles rendered again
+	CRS          string  // CRS of GeoTIFF output, EPSG:3857 or EPSG:4326
+	Resolution   float64 // pixel size of GeoTIFF output in units of the CRS, from the grid when zero
+	MinZoom      int
+	MaxZoom      int
+	NumWorkers   int
+	Bounds       [4]float64 // [minLat, minLon, maxLat, maxLon]
+	Quality      int
+	Verbose      bool
+	Messages     []int // 1-based message numbers, empty selects the first message
+	AllMessages  bool
+	Filter       string // grib_copy style selection, e.g. "shortName=t,level=850"
+	RowOffset    bool   // apply parser.CorrectRowOffset to the decoded fields
+	Interp       string // interpolation method, one of parser.InterpolatorNames
 }
-
-const (
-	TileSize    = 256
-	WorldSizeWM = 40075016.685578488
-	OffsetWM    = 20037508.342789244
-	EarthRadius = 6378137.0
-)
@@ -4,7 +4,10 @@ import (
 	"database/sql"
 	"fmt"
 	"hstin/grib2tiles/internal/config"
+	"math"
 	"os"
+	"strconv"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -57,30 +60,202 @@ func InitDB(dbPath string) (*sql.DB, error) {
 	return db, nil
 }
 
-func UpdateMetadata(db *sql.DB, config *config.Config) error {
-	_, err := db.Exec("UPDATE metadata SET value = ? WHERE name = 'minzoom'", config.MinZoom)
+// OpenDB opens an existing MBTiles database to add tiles to it. The file
+// must hold webp tiles in a tiles table with a unique index on their
+// coordinates, so tiles that are rendered again replace the old ones.
+func OpenDB(dbPath string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
+		return nil, err
+	}
+
+	if err := checkDB(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot update %s: %v", dbPath, err)
+	}
+	return db, nil
+}
+
+func checkDB(db *sql.DB) error {
+	tables := make(map[string]string)
+	rows, err := db.Query("SELECT name, type FROM sqlite_master WHERE name IN ('tiles', 'metadata')")
+	if err != nil {
+		return fmt.Errorf("not an MBTiles file: %v", err)
+	}
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			rows.Close()
+			return err
+		}
+		tables[name] = typ
+	}
+	rows.Close()
+
+	switch {
+	case tables["tiles"] == "view":
+		return fmt.Errorf("tiles are stored in a view, which cannot be updated")
+	case tables["tiles"] != "table" || tables["metadata"] != "table":
+		return fmt.Errorf("not an MBTiles file, tiles or metadata table missing")
+	}
+
+	var format string
+	err = db.QueryRow("SELECT value FROM metadata WHERE name = 'format'").Scan(&format)
+	if err != nil && err != sql.ErrNoRows {
 		return err
 	}
-	_, err = db.Exec("UPDATE metadata SET value = ? WHERE name = 'maxzoom'", config.MaxZoom)
+	if format != "" && format != "webp" {
+		return fmt.Errorf("holds %s tiles, not webp", format)
+	}
+
+	unique, err := hasTileIndex(db)
 	if err != nil {
 		return err
 	}
+	if !unique {
+		return fmt.Errorf("tiles table has no unique index on zoom_level, tile_column, tile_row")
+	}
+	return nil
+}
 
-	bounds := fmt.Sprintf("%f,%f,%f,%f",
-		config.Bounds[1], config.Bounds[0], config.Bounds[3], config.Bounds[2])
-	_, err = db.Exec("UPDATE metadata SET value = ? WHERE name = 'bounds'", bounds)
+// hasTileIndex reports whether the tiles table has a unique index on the
+// tile coordinates, which INSERT OR REPLACE needs to replace tiles.
+func hasTileIndex(db *sql.DB) (bool, error) {
+	rows, err := db.Query("SELECT name FROM pragma_index_list('tiles') WHERE \"unique\" = 1")
 	if err != nil {
+		return false, err
+	}
+	var indexes []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return false, err
+		}
+		indexes = append(indexes, name)
+	}
+	rows.Close()
+
+	for _, index := range indexes {
+		var columns []string
+		rows, err := db.Query("SELECT name FROM pragma_index_info(?) ORDER BY seqno", index)
+		if err != nil {
+			return false, err
+		}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return false, err
+			}
+			columns = append(columns, name)
+		}
+		rows.Close()
+
+		if strings.Join(columns, ",") == "zoom_level,tile_column,tile_row" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CopyDB writes a consistent copy of a database to dbPath, which must not
+// exist or be empty.
+func CopyDB(db *sql.DB, dbPath string) error {
+	_, err := db.Exec("VACUUM INTO ?", dbPath)
+	return err
+}
+
+func UpdateMetadata(db *sql.DB, config *config.Config) error {
+	return writeMetadata(db, config.MinZoom, config.MaxZoom, config.Bounds)
+}
+
+// MergeMetadata extends the zoom levels and bounds in the metadata of an
+// existing database by those of the config.
+func MergeMetadata(db *sql.DB, config *config.Config) error {
+	minZoom, maxZoom, bounds := config.MinZoom, config.MaxZoom, config.Bounds
+
+	var value string
+	if err := db.QueryRow("SELECT value FROM metadata WHERE name = 'minzoom'").Scan(&value); err == nil {
+		if z, err := strconv.Atoi(value); err == nil {
+			minZoom = min(minZoom, z)
+		}
+	}
+	if err := db.QueryRow("SELECT value FROM metadata WHERE name = 'maxzoom'").Scan(&value); err == nil {
+		if z, err := strconv.Atoi(value); err == nil {
+			maxZoom = max(maxZoom, z)
+		}
+	}
+	if err := db.QueryRow("SELECT value FROM metadata WHERE name = 'bounds'").Scan(&value); err == nil {
+		// Bounds are stored as minLon,minLat,maxLon,maxLat.
+		var minLon, minLat, maxLon, maxLat float64
+		if n, _ := fmt.Sscanf(value, "%g,%g,%g,%g", &minLon, &minLat, &maxLon, &maxLat); n == 4 {
+			bounds = [4]float64{
+				math.Min(bounds[0], minLat),
+				math.Min(bounds[1], minLon),
+				math.Max(bounds[2], maxLat),
+				math.Max(bounds[3], maxLon),
+			}
+		}
+	}
+
+	return writeMetadata(db, minZoom, maxZoom, bounds)
+}
+
+func writeMetadata(db *sql.DB, minZoom, maxZoom int, bounds [4]float64) error {
+	if err := setMetadata(db, "minzoom", strconv.Itoa(minZoom)); err != nil {
+		return err
+	}
+	if err := setMetadata(db, "maxzoom", strconv.Itoa(maxZoom)); err != nil {
+		return err
+	}
+
+	boundsValue := fmt.Sprintf("%f,%f,%f,%f", bounds[1], bounds[0], bounds[3], bounds[2])
+	if err := setMetadata(db, "bounds", boundsValue); err != nil {
 		return err
 	}
 
-	centerLat := (config.Bounds[0] + config.Bounds[2]) / 2
-	centerLon := (config.Bounds[1] + config.Bounds[3]) / 2
-	center := fmt.Sprintf("%f,%f,%d", centerLon, centerLat, (config.MinZoom+config.MaxZoom)/2)
-	_, err = db.Exec("UPDATE metadata SET value = ? WHERE name = 'center'", center)
+	centerLat := (bounds[0] + bounds[2]) / 2
+	centerLon := (bounds[1] + bounds[3]) / 2
+	center := fmt.Sprintf("%f,%f,%d", centerLon, centerLat, (minZoom+maxZoom)/2)
+	return setMetadata(db, "center", center)
+}
+
+// setMetadata updates a metadata value, adding it when missing.
+func setMetadata(db *sql.DB, name, value string) error {
+	result, err := db.Exec("UPDATE metadata SET value = ? WHERE name = ?", value, name)
 	if err != nil {
 		return err
 	}
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		return nil
+	}
+	_, err = db.Exec("INSERT INTO metadata (name, value) VALUES (?, ?)", name, value)
+	return err
+}
 
-	return nil
+// TileWriter inserts tiles into an MBTiles database, as a tiles.Sink,
+// replacing existing tiles with the same coordinates.
+type TileWriter struct {
+	stmt *sql.Stmt
+}
+
+func NewTileWriter(db *sql.DB) (*TileWriter, error) {
+	stmt, err := db.Prepare("INSERT OR REPLACE INTO tiles (zoom_level, tile_column, tile_row, tile_data) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return nil, err
+	}
+	return &TileWriter{stmt: stmt}, nil
+}
+
+// WriteTile inserts the XYZ tile z/x/y, flipping the row to the TMS scheme
+// of MBTiles.
+func (w *TileWriter) WriteTile(z, x, y int, data []byte) error {
+	tmsY := (1 << z) - 1 - y
+	_, err := w.stmt.Exec(z, x, tmsY, data)
+	return err
+}
+
+func (w *TileWriter) Close() error {
+	return w.stmt.Close()
 }
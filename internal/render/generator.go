@@ -2,22 +2,25 @@ package render
 
 import (
 	"fmt"
-	"io/ioutil"
-	"log"
 	"math"
-	"sync"
 	"sync/atomic"
 	"time"
 
-	"database/sql"
-	"hstin/grib2tiles/internal/colormap"
+	"hstin/grib2tiles/colormap"
 	"hstin/grib2tiles/internal/config"
-	"hstin/grib2tiles/internal/db"
 	"hstin/grib2tiles/parser"
+	"hstin/grib2tiles/tiles"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
+type gribMessage struct {
+	Number int
+	File   *parser.GRIBFile
+}
+
 func Generate(cfg *config.Config) error {
 	startTime := time.Now()
 
@@ -30,78 +33,260 @@ func Generate(cfg *config.Config) error {
 		fmt.Println("Loading GRIB file...")
 	}
 
-	fileContent, err := ioutil.ReadFile(cfg.GribFile)
+	gribFiles, err := parser.ProcessGRIBFile(cfg.GribFile)
 	if err != nil {
-		return fmt.Errorf("failed to read GRIB file: %v", err)
+		return fmt.Errorf("failed to load GRIB file: %w", err)
 	}
 
-	grib := parser.ProcessGRIB(fileContent)
-	gribFile := &grib
+	messages, err := selectMessages(gribFiles, cfg)
+	if err != nil {
+		return err
+	}
 
-	if cfg.Bounds[0] == -90.0 && cfg.Bounds[1] == -180.0 &&
-		cfg.Bounds[2] == 90.0 && cfg.Bounds[3] == 180.0 {
+	if err := loadUnstructuredGrid(messages, cfg); err != nil {
+		return err
+	}
 
-		computeBoundsFromGRIB(cfg, gribFile)
+	if cfg.RowOffset {
+		for _, message := range messages {
+			parser.CorrectRowOffset(message.File)
+		}
 	}
 
 	if cfg.Verbose {
-		fmt.Println("Loading color map...")
+		fmt.Printf("  Found %d messages, rendering %d\n", len(gribFiles), len(messages))
 	}
-	if err := colormap.Load(cfg.ColorMap); err != nil {
-		return fmt.Errorf("failed to load color map: %v", err)
+	// Resolve the format once, the per-message output paths may lose the
+	// trailing separator of a directory.
+	format, err := outputFormat(cfg)
+	if err != nil {
+		return err
+	}
+	cfg.Format = format
+	if cfg.Update && format != FormatMBTiles && format != FormatXYZ {
+		return fmt.Errorf("-update only supports MBTiles and xyz output, not %s", format)
 	}
 
-	if cfg.Verbose {
-		fmt.Println("Initializing tiles database...")
+	colors := cfg.Colors
+	if colors == nil && cfg.ColorMap != "" {
+		if cfg.Verbose {
+			fmt.Println("Loading color map...")
+		}
+		colors, err = colormap.Load(cfg.ColorMap)
+		if err != nil {
+			return fmt.Errorf("failed to load color map: %v", err)
+		}
 	}
-	database, err := db.InitDB(cfg.OutputFile)
-	if err != nil {
-		return fmt.Errorf("failed to initialize database: %v", err)
+
+	for _, message := range messages {
+		messageCfg := *cfg
+		if len(messages) > 1 {
+			messageCfg.OutputFile = messageOutputFile(cfg.OutputFile, message.Number)
+			fmt.Printf("Rendering message %d to %s\n", message.Number, messageCfg.OutputFile)
+		}
+
+		messageCfg.Colors = colors
+		if colors == nil {
+			messageCfg.Colors, err = loadDefaultPalette(message, cfg)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := generateMessage(&messageCfg, message.File); err != nil {
+			return fmt.Errorf("message %d: %v", message.Number, err)
+		}
 	}
-	defer database.Close()
 
-	db.UpdateMetadata(database, cfg)
+	elapsed := time.Since(startTime)
+
+	fmt.Printf("Tile generation complete! Took %s\n", elapsed)
 
-	if cfg.Verbose {
-		fmt.Println("Generating tiles...")
+	return nil
+}
+
+func selectMessages(gribFiles []parser.GRIBFile, cfg *config.Config) ([]gribMessage, error) {
+	var messages []gribMessage
+
+	if cfg.Filter != "" {
+		filter, err := parser.ParseFilter(cfg.Filter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid message filter: %v", err)
+		}
+
+		var numbers []string
+		for _, i := range filter.Select(gribFiles) {
+			messages = append(messages, gribMessage{Number: i + 1, File: &gribFiles[i]})
+			numbers = append(numbers, strconv.Itoa(i+1))
+		}
+
+		// Parameters without a shortName never match one, say so rather
+		// than silently leaving them out.
+		var unresolved []string
+		for _, i := range filter.Unresolved(gribFiles) {
+			h := gribFiles[i].Header
+			unresolved = append(unresolved, fmt.Sprintf("%d (parameter=%d.%d.%d)", i+1, h.Discipline, h.ParameterCategory, h.ParameterNumber))
+		}
+		if len(messages) == 0 && len(unresolved) > 0 {
+			return nil, fmt.Errorf("no message matches filter %q, messages %s have no shortName, select them by parameter",
+				cfg.Filter, strings.Join(unresolved, ", "))
+		}
+		if len(unresolved) > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: messages %s have no shortName and cannot match filter %q, select them by parameter\n",
+				strings.Join(unresolved, ", "), cfg.Filter)
+		}
+
+		// Messages that could not be decoded have no header to match.
+		var failed []string
+		for _, g := range gribFiles {
+			if g.Err != nil {
+				failed = append(failed, g.Err.Error())
+			}
+		}
+		if len(messages) == 0 && len(failed) > 0 {
+			return nil, fmt.Errorf("no message matches filter %q, some messages could not be decoded: %s",
+				cfg.Filter, strings.Join(failed, "; "))
+		}
+		if len(failed) > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: skipping messages that could not be decoded: %s\n", strings.Join(failed, "; "))
+		}
+
+		if len(messages) == 0 {
+			return nil, fmt.Errorf("no message matches filter %q", cfg.Filter)
+		}
+		if len(messages) > 1 && !cfg.AllMessages {
+			return nil, fmt.Errorf("%d messages match filter %q (messages %s), narrow the filter or render all matches with -messages all",
+				len(messages), cfg.Filter, strings.Join(numbers, ", "))
+		}
+		return messages, nil
 	}
 
-	if err := generateTiles(database, cfg, gribFile); err != nil {
-		return fmt.Errorf("failed to generate tiles: %v", err)
+	if cfg.AllMessages {
+		for i := range gribFiles {
+			if err := gribFiles[i].Err; err != nil {
+				return nil, err
+			}
+			messages = append(messages, gribMessage{Number: i + 1, File: &gribFiles[i]})
+		}
+		return messages, nil
 	}
 
-	if cfg.Verbose {
-		fmt.Println("Optimizing database...")
+	numbers := cfg.Messages
+	if len(numbers) == 0 {
+		numbers = []int{1}
 	}
-	database.Exec("VACUUM")
 
-	elapsed := time.Since(startTime)
+	for _, n := range numbers {
+		if n < 1 || n > len(gribFiles) {
+			return nil, fmt.Errorf("message %d does not exist, file contains %d messages", n, len(gribFiles))
+		}
+		if err := gribFiles[n-1].Err; err != nil {
+			return nil, err
+		}
+		messages = append(messages, gribMessage{Number: n, File: &gribFiles[n-1]})
+	}
 
-	fmt.Printf("Tile generation complete! Took %s\n", elapsed)
+	return messages, nil
+}
+
+// loadUnstructuredGrid attaches the grid file to the messages on an
+// unstructured grid, whose cell coordinates are not part of the GRIB file.
+func loadUnstructuredGrid(messages []gribMessage, cfg *config.Config) error {
+	var grid *parser.UnstructuredGrid
+
+	for _, message := range messages {
+		if message.File.Header.GridType != parser.GridUnstructured {
+			continue
+		}
+		if cfg.GridFile == "" {
+			return fmt.Errorf("message %d is on an unstructured grid, pass its grid file with -grid", message.Number)
+		}
+
+		if grid == nil {
+			if cfg.Verbose {
+				fmt.Println("Loading grid file...")
+			}
+			var err error
+			grid, err = parser.LoadUnstructuredGrid(cfg.GridFile)
+			if err != nil {
+				return fmt.Errorf("failed to load grid file: %w", err)
+			}
+		}
+
+		if err := message.File.SetUnstructuredGrid(grid); err != nil {
+			return fmt.Errorf("message %d: %w", message.Number, err)
+		}
+	}
 
 	return nil
 }
 
-func computeBoundsFromGRIB(config *config.Config, gribFile *parser.GRIBFile) {
-	la1 := gribFile.Header.La1
-	la2 := gribFile.Header.La2
-	lo1 := gribFile.Header.Lo1
-	lo2 := gribFile.Header.Lo2
-	dx := gribFile.Header.DX
-	dy := gribFile.Header.DY
-
-	// Normalize Lo1 if needed
-	if lo1 > 180 {
-		lo1 -= 360
+// loadDefaultPalette loads the built-in palette for the parameter of a
+// message, used when no color map is given.
+func loadDefaultPalette(message gribMessage, cfg *config.Config) (*colormap.ColorMap, error) {
+	h := message.File.Header
+	name := colormap.DefaultPalette(h.Discipline, h.ParameterCategory, h.ParameterNumber)
+	if cfg.Verbose {
+		fmt.Printf("Using palette %s for parameter %d.%d.%d\n", name, h.Discipline, h.ParameterCategory, h.ParameterNumber)
 	}
+	colors, err := colormap.Load(colormap.PalettePrefix + name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load color map: %v", err)
+	}
+	return colors, nil
+}
 
-	gribMinLat := math.Min(la1, la2)
-	gribMaxLat := math.Max(la1, la2)
-	gribMinLon := math.Min(lo1, lo2)
-	gribMaxLon := math.Max(lo1, lo2)
+// messageOutputFile derives a per-message output path, e.g. out.mbtiles -> out_3.mbtiles
+// or tiles/ -> tiles_3.
+func messageOutputFile(outputFile string, number int) string {
+	outputFile = strings.TrimRight(outputFile, "/"+string(filepath.Separator))
+	ext := filepath.Ext(outputFile)
+	return fmt.Sprintf("%s_%d%s", strings.TrimSuffix(outputFile, ext), number, ext)
+}
 
-	latBuffer := math.Abs(dy)
-	lonBuffer := math.Abs(dx)
+func generateMessage(cfg *config.Config, gribFile *parser.GRIBFile) error {
+	if cfg.Bounds[0] == -90.0 && cfg.Bounds[1] == -180.0 &&
+		cfg.Bounds[2] == 90.0 && cfg.Bounds[3] == 180.0 {
+
+		computeBoundsFromGRIB(cfg, gribFile)
+	}
+
+	if cfg.Format == FormatCOG {
+		return writeCOG(cfg, gribFile)
+	}
+
+	out, err := openOutput(cfg)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	// Resolve percentage thresholds of the color map against this message.
+	stats := gribFile.Stats()
+	if stats.Valid > 0 {
+		cfg.Colors = cfg.Colors.WithRange(stats.Min, stats.Max)
+	}
+
+	if cfg.Verbose {
+		fmt.Println("Generating tiles...")
+	}
+
+	if err := generateTiles(out, cfg, gribFile); err != nil {
+		return fmt.Errorf("failed to generate tiles: %v", err)
+	}
+
+	return out.Finish()
+}
+
+func computeBoundsFromGRIB(config *config.Config, gribFile *parser.GRIBFile) {
+	gribMinLat, gribMinLon, gribMaxLat, gribMaxLon := gribFile.Bounds()
+
+	// Pad regular grids by one cell; the increments of projected grids are in metres.
+	latBuffer, lonBuffer := 0.0, 0.0
+	if gribFile.Header.GridType == parser.GridRegularLatLon {
+		latBuffer = math.Abs(gribFile.Header.DY)
+		lonBuffer = math.Abs(gribFile.Header.DX)
+	}
 
 	config.Bounds = [4]float64{
 		gribMinLat - latBuffer,
@@ -116,56 +301,35 @@ func computeBoundsFromGRIB(config *config.Config, gribFile *parser.GRIBFile) {
 	}
 }
 
-func generateTiles(db *sql.DB, cfg *config.Config, gribFile *parser.GRIBFile) error {
-	stmt, err := db.Prepare("INSERT INTO tiles (zoom_level, tile_column, tile_row, tile_data) VALUES (?, ?, ?, ?)")
+func generateTiles(sink tiles.Sink, cfg *config.Config, gribFile *parser.GRIBFile) error {
+	renderer, err := newRenderer(gribFile, cfg)
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
-
-	var wg sync.WaitGroup
-	jobQueue := make(chan TileJob, 1000)
-	resultQueue := make(chan TileResult, 1000)
-
-	for i := 0; i < cfg.NumWorkers; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for job := range jobQueue {
-				tileData, err := RenderTile(gribFile, int(job.Z), int(job.X), int(job.Y), cfg)
-				if err != nil {
-					continue
-				}
 
-				resultQueue <- TileResult{
-					Z:    job.Z,
-					X:    job.X,
-					Y:    job.Y,
-					Data: tileData,
-				}
-			}
-		}()
+	var completedTiles int64 = 0
+	pipeline := &tiles.Pipeline{
+		Renderer: renderer,
+		MinZoom:  cfg.MinZoom,
+		MaxZoom:  cfg.MaxZoom,
+		Bounds:   cfg.Bounds,
+		Workers:  cfg.NumWorkers,
+		Progress: func(done, total int64) {
+			atomic.StoreInt64(&completedTiles, done)
+		},
 	}
 
-	var totalTiles int64 = 0
-	for z := cfg.MinZoom; z <= cfg.MaxZoom; z++ {
-		minX, minY := LatLonToTile(cfg.Bounds[0], cfg.Bounds[1], z)
-		maxX, maxY := LatLonToTile(cfg.Bounds[2], cfg.Bounds[3], z)
+	totalTiles := pipeline.TileCount()
+	fmt.Printf("Generating %d tiles across zoom levels %d-%d\n", totalTiles, cfg.MinZoom, cfg.MaxZoom)
 
-		if minX > maxX {
-			minX, maxX = maxX, minX
-		}
-		if minY > maxY {
-			minY, maxY = maxY, minY
+	if cfg.Verbose {
+		for z := cfg.MinZoom; z <= cfg.MaxZoom; z++ {
+			minX, minY, maxX, maxY := tiles.TileRange(cfg.Bounds, z)
+			fmt.Printf("Zoom level %d: generating %d x %d = %d tiles\n",
+				z, (maxX - minX + 1), (maxY - minY + 1), (maxX-minX+1)*(maxY-minY+1))
 		}
-
-		tilesAtZoom := int64((maxX - minX + 1) * (maxY - minY + 1))
-		totalTiles += tilesAtZoom
 	}
 
-	fmt.Printf("Generating %d tiles across zoom levels %d-%d\n", totalTiles, cfg.MinZoom, cfg.MaxZoom)
-
-	var completedTiles int64 = 0
 	startTime := time.Now()
 
 	ticker := time.NewTicker(2 * time.Second)
@@ -212,56 +376,11 @@ func generateTiles(db *sql.DB, cfg *config.Config, gribFile *parser.GRIBFile) er
 		}
 	}()
 
-	var dbWg sync.WaitGroup
-	dbWg.Add(1)
-	go func() {
-		defer dbWg.Done()
-
-		for result := range resultQueue {
-			tmsY := (1 << result.Z) - 1 - result.Y
-			_, err := stmt.Exec(result.Z, result.X, tmsY, result.Data)
-			if err != nil {
-				log.Printf("Error inserting tile: %v", err)
-			}
-
-			atomic.AddInt64(&completedTiles, 1)
-		}
-	}()
-
-	for z := cfg.MinZoom; z <= cfg.MaxZoom; z++ {
-		minX, minY := LatLonToTile(cfg.Bounds[0], cfg.Bounds[1], z)
-		maxX, maxY := LatLonToTile(cfg.Bounds[2], cfg.Bounds[3], z)
-
-		if minX > maxX {
-			minX, maxX = maxX, minX
-		}
-		if minY > maxY {
-			minY, maxY = maxY, minY
-		}
-
-		if cfg.Verbose {
-			fmt.Printf("Zoom level %d: generating %d x %d = %d tiles\n",
-				z, (maxX - minX + 1), (maxY - minY + 1), (maxX-minX+1)*(maxY-minY+1))
-		}
-
-		for x := minX; x <= maxX; x++ {
-			for y := minY; y <= maxY; y++ {
-				jobQueue <- TileJob{
-					Z: uint8(z),
-					X: uint32(x),
-					Y: uint32(y),
-				}
-			}
-		}
-	}
-
-	close(jobQueue)
-	wg.Wait()
-
-	close(resultQueue)
-	dbWg.Wait()
-
+	err = pipeline.Run(sink)
 	done <- true
+	if err != nil {
+		return err
+	}
 
 	final := atomic.LoadInt64(&completedTiles)
 	totalTime := time.Since(startTime).Seconds()
@@ -0,0 +1,282 @@
+package render
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"hstin/grib2tiles/cog"
+	"hstin/grib2tiles/internal/atomicfile"
+	"hstin/grib2tiles/internal/config"
+	"hstin/grib2tiles/internal/db"
+	"hstin/grib2tiles/parser"
+	"hstin/grib2tiles/tiles"
+)
+
+// Output formats.
+const (
+	FormatMBTiles = "mbtiles"
+	FormatPMTiles = "pmtiles"
+	FormatXYZ     = "xyz"
+	FormatCOG     = "cog"
+)
+
+// OutputFormats returns the formats accepted by config.Config.Format.
+func OutputFormats() []string {
+	return []string{FormatMBTiles, FormatPMTiles, FormatXYZ, FormatCOG}
+}
+
+// outputFormat returns the format of the output file, given by the config
+// or by its extension, a directory tree for paths ending in a separator or
+// naming a directory, and MBTiles otherwise.
+func outputFormat(cfg *config.Config) (string, error) {
+	if cfg.Format != "" {
+		for _, format := range OutputFormats() {
+			if cfg.Format == format {
+				return format, nil
+			}
+		}
+		return "", fmt.Errorf("unknown output format %q", cfg.Format)
+	}
+
+	switch strings.ToLower(filepath.Ext(cfg.OutputFile)) {
+	case ".pmtiles":
+		return FormatPMTiles, nil
+	case ".tif", ".tiff":
+		return FormatCOG, nil
+	}
+	if strings.HasSuffix(cfg.OutputFile, "/") || strings.HasSuffix(cfg.OutputFile, string(filepath.Separator)) {
+		return FormatXYZ, nil
+	}
+	if info, err := os.Stat(cfg.OutputFile); err == nil && info.IsDir() {
+		return FormatXYZ, nil
+	}
+	return FormatMBTiles, nil
+}
+
+// output is a tile sink that writes an output file. The tiles go to a
+// temporary file or directory next to it, so a tile server reading the
+// output sees the previous version until Finish puts the new one in place.
+type output interface {
+	tiles.Sink
+
+	// Finish completes the output once all tiles are written and replaces
+	// the output file with it.
+	Finish() error
+
+	// Close releases the output, removing the temporary file or directory
+	// when Finish has not succeeded.
+	Close() error
+}
+
+func openOutput(cfg *config.Config) (output, error) {
+	format, err := outputFormat(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatPMTiles:
+		return openPMTiles(cfg)
+	case FormatXYZ:
+		return openXYZ(cfg)
+	default:
+		return openMBTiles(cfg)
+	}
+}
+
+// mbtilesOutput builds the database under a temporary name, which
+// replaces the output file once it is complete.
+type mbtilesOutput struct {
+	file     *atomicfile.File
+	database *sql.DB
+	writer   *db.TileWriter
+	verbose  bool
+}
+
+func openMBTiles(cfg *config.Config) (*mbtilesOutput, error) {
+	file, err := atomicfile.Create(cfg.OutputFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create database: %v", err)
+	}
+	// SQLite opens the file by its name.
+	file.File.Close()
+
+	database, err := openDatabase(file.Name(), cfg)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	writer, err := db.NewTileWriter(database)
+	if err != nil {
+		database.Close()
+		file.Close()
+		return nil, err
+	}
+
+	return &mbtilesOutput{file: file, database: database, writer: writer, verbose: cfg.Verbose}, nil
+}
+
+// openDatabase initializes the temporary database, or with cfg.Update
+// fills it with a copy of the existing output file.
+func openDatabase(tempFile string, cfg *config.Config) (*sql.DB, error) {
+	if !cfg.Update {
+		if cfg.Verbose {
+			fmt.Println("Initializing tiles database...")
+		}
+		database, err := db.InitDB(tempFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize database: %v", err)
+		}
+
+		db.UpdateMetadata(database, cfg)
+		return database, nil
+	}
+
+	// Updating a file that is not there is most likely a wrong path, which
+	// would otherwise silently write a tileset of just the new tiles.
+	if _, err := os.Stat(cfg.OutputFile); err != nil {
+		return nil, fmt.Errorf("cannot update %s: %w", cfg.OutputFile, err)
+	}
+
+	if cfg.Verbose {
+		fmt.Println("Opening tiles database...")
+	}
+	existing, err := db.OpenDB(cfg.OutputFile)
+	if err != nil {
+		return nil, err
+	}
+	err = db.CopyDB(existing, tempFile)
+	existing.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy database: %v", err)
+	}
+
+	database, err := db.OpenDB(tempFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.MergeMetadata(database, cfg); err != nil {
+		database.Close()
+		return nil, fmt.Errorf("failed to update metadata: %v", err)
+	}
+	return database, nil
+}
+
+func (o *mbtilesOutput) WriteTile(z, x, y int, data []byte) error {
+	return o.writer.WriteTile(z, x, y, data)
+}
+
+func (o *mbtilesOutput) Finish() error {
+	if o.verbose {
+		fmt.Println("Optimizing database...")
+	}
+	if _, err := o.database.Exec("VACUUM"); err != nil {
+		return fmt.Errorf("failed to optimize database: %v", err)
+	}
+
+	if err := o.closeDatabase(); err != nil {
+		return err
+	}
+	return o.file.Commit()
+}
+
+func (o *mbtilesOutput) closeDatabase() error {
+	if o.database == nil {
+		return nil
+	}
+	o.writer.Close()
+	err := o.database.Close()
+	o.database = nil
+	return err
+}
+
+// Close closes the database and removes it unless it has replaced the
+// output file.
+func (o *mbtilesOutput) Close() error {
+	err := o.closeDatabase()
+	o.file.Close()
+	return err
+}
+
+type pmtilesOutput struct {
+	*tiles.PMTilesWriter
+	metadata tiles.Metadata
+	verbose  bool
+}
+
+func openPMTiles(cfg *config.Config) (*pmtilesOutput, error) {
+	writer, err := tiles.CreatePMTiles(cfg.OutputFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PMTiles archive: %v", err)
+	}
+
+	return &pmtilesOutput{
+		PMTilesWriter: writer,
+		metadata:      tiles.DefaultMetadata(cfg.MinZoom, cfg.MaxZoom, cfg.Bounds),
+		verbose:       cfg.Verbose,
+	}, nil
+}
+
+func (o *pmtilesOutput) Finish() error {
+	if o.verbose {
+		fmt.Println("Writing PMTiles archive...")
+	}
+	return o.PMTilesWriter.Finish(o.metadata)
+}
+
+type xyzOutput struct {
+	*tiles.DirWriter
+	metadata tiles.Metadata
+}
+
+func openXYZ(cfg *config.Config) (*xyzOutput, error) {
+	writer, err := tiles.CreateDir(cfg.OutputFile, cfg.TileTemplate, cfg.TMS, cfg.Update)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tile directory: %v", err)
+	}
+
+	return &xyzOutput{
+		DirWriter: writer,
+		metadata:  tiles.DefaultMetadata(cfg.MinZoom, cfg.MaxZoom, cfg.Bounds),
+	}, nil
+}
+
+func (o *xyzOutput) Finish() error {
+	return o.DirWriter.Finish(o.metadata)
+}
+
+// writeCOG exports the field itself as a Cloud-Optimized GeoTIFF instead of
+// rendering tiles.
+func writeCOG(cfg *config.Config, gribFile *parser.GRIBFile) error {
+	crs := cog.WebMercator
+	if cfg.CRS != "" {
+		var err error
+		crs, err = cog.ParseCRS(cfg.CRS)
+		if err != nil {
+			return err
+		}
+	}
+
+	interp, err := parser.InterpolatorByName(cfg.Interp)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Verbose {
+		fmt.Printf("Writing %s GeoTIFF...\n", crs)
+	}
+	if err := cog.Write(cfg.OutputFile, gribFile, cog.Options{
+		CRS:          crs,
+		Bounds:       cfg.Bounds,
+		Resolution:   cfg.Resolution,
+		Interpolator: interp,
+		Workers:      cfg.NumWorkers,
+	}); err != nil {
+		return fmt.Errorf("failed to write GeoTIFF: %v", err)
+	}
+	return nil
+}
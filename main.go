@@ -3,34 +3,66 @@ package main
 import (
 	"flag"
 	"fmt"
+	"hstin/grib2tiles/cog"
+	"hstin/grib2tiles/colormap"
 	"hstin/grib2tiles/internal/config"
+	"hstin/grib2tiles/parser"
+	"hstin/grib2tiles/tiles"
 
 	"hstin/grib2tiles/internal/render"
 	"os"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "info" {
+		runInfo(os.Args[2:])
+		return
+	}
+
 	// Setup custom usage
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Light Pollution Tiles Generator\n\n")
-		fmt.Fprintf(os.Stderr, "Usage: %s [options] input.grib output.mbtiles\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] input.grib output.mbtiles\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s info [-json] input.grib\n\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nExamples:\n")
 		fmt.Fprintf(os.Stderr, "  Basic:    %s input.grib output.mbtiles\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  With zoom:  %s -zoom 3-12 input.grib output.mbtiles\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  Preview:  %s -preview input.grib output.mbtiles\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  Messages: %s -messages 2,5-7 input.grib output.mbtiles\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  Select:   %s -select shortName=t,level=850,step=6 input.grib output.mbtiles\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  Palette:  %s -colors name:precip input.grib output.mbtiles\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  PMTiles:  %s input.grib output.pmtiles\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  XYZ:      %s -template {z}/{x}/{y}.webp input.grib tiles/\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  Update:   %s -update -zoom 8-10 input.grib output.mbtiles\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  GeoTIFF:  %s -crs EPSG:4326 input.grib output.tif\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  ICON:     %s -grid icon_grid_0026_R03B07_G.nc input.grib output.mbtiles\n", os.Args[0])
 	}
 
 	zoom := flag.String("zoom", "0-7", "Zoom levels to render (MIN-MAX)")
-	colors := flag.String("colors", "colors.txt", "Color map file for visualization")
+	colors := flag.String("colors", "", "Color map file, or built-in palette as name:NAME ("+strings.Join(colormap.PaletteNames(), ", ")+"); chosen from the GRIB parameter when empty")
 	area := flag.String("area", "", "Bounding box (minLon,minLat,maxLon,maxLat)")
 	workers := flag.Int("workers", runtime.NumCPU(), "Number of parallel workers (default: all available CPUs)")
 	quality := flag.Int("quality", 90, "WebP quality (1-100)")
 	verbose := flag.Bool("verbose", false, "Show detailed progress")
+	messages := flag.String("messages", "1", "GRIB messages to render (e.g. 1, 2,5-7 or all)")
+	filter := flag.String("select", "", "Select messages by key, e.g. shortName=t,level=850,step=6 (keys: "+strings.Join(parser.FilterKeys(), ", ")+")")
+	gridFile := flag.String("grid", "", "Grid file with the cell coordinates of unstructured grids (ICON clat/clon NetCDF or CLAT/CLON GRIB)")
+	interp := flag.String("interp", "bicubic", "Interpolation method ("+strings.Join(parser.InterpolatorNames(), ", ")+"), use nearest for categorical fields; reduced Gaussian and unstructured grids are interpolated linearly unless nearest is given")
+	rowOffset := flag.Bool("row-offset", false, "Shift every even row, counted from the north after reordering to scanning mode 0, by half the i increment (legacy correction for misaligned datasets)")
+	format := flag.String("format", "", "Output format ("+strings.Join(render.OutputFormats(), ", ")+"), from the output file extension when empty")
+	template := flag.String("template", tiles.DefaultTemplate, "Path of the tiles in an xyz output directory")
+	tms := flag.Bool("tms", false, "Number the rows of an xyz output directory from the south (TMS) instead of the north")
+	var update bool
+	flag.BoolVar(&update, "update", false, "Add tiles to an existing MBTiles file or xyz directory instead of replacing it, merging zoom levels and bounds; the output must exist")
+	flag.BoolVar(&update, "append", false, "Same as -update")
+	crs := flag.String("crs", "EPSG:3857", "CRS of cog output (EPSG:3857 or EPSG:4326)")
+	resolution := flag.Float64("resolution", 0, "Pixel size of cog output in units of the CRS (default: spacing of the grid)")
 	help := flag.Bool("help", false, "Show help")
 
 	// Parse flags
@@ -107,26 +139,95 @@ func main() {
 		bounds = [4]float64{minLat, minLon, maxLat, maxLon}
 	}
 
+	if _, err := parser.InterpolatorByName(*interp); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *format != "" && !slices.Contains(render.OutputFormats(), *format) {
+		fmt.Fprintf(os.Stderr, "Error: unknown output format %q\n", *format)
+		os.Exit(1)
+	}
+
+	if _, err := cog.ParseCRS(*crs); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := tiles.CheckTemplate(*template); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Parse message selection
+	var messageNumbers []int
+	allMessages := *messages == "all"
+	if *filter != "" {
+		if _, err := parser.ParseFilter(*filter); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		explicitMessages := false
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "messages" {
+				explicitMessages = true
+			}
+		})
+		if explicitMessages && !allMessages {
+			fmt.Fprintf(os.Stderr, "Error: -select can only be combined with -messages all\n")
+			os.Exit(1)
+		}
+	} else if !allMessages {
+		var err error
+		messageNumbers, err = parseMessageList(*messages)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// Create config
 	cfg := &config.Config{
-		GribFile:   inputFile,
-		ColorMap:   *colors,
-		OutputFile: outputFile,
-		MinZoom:    minZoom,
-		MaxZoom:    maxZoom,
-		NumWorkers: *workers,
-		Bounds:     bounds,
-		Quality:    *quality,
-		Verbose:    *verbose,
+		GribFile:     inputFile,
+		GridFile:     *gridFile,
+		ColorMap:     *colors,
+		OutputFile:   outputFile,
+		Format:       *format,
+		TileTemplate: *template,
+		TMS:          *tms,
+		Update:       update,
+		CRS:          *crs,
+		Resolution:   *resolution,
+		MinZoom:      minZoom,
+		MaxZoom:      maxZoom,
+		NumWorkers:   *workers,
+		Bounds:       bounds,
+		Quality:      *quality,
+		Verbose:      *verbose,
+		Messages:     messageNumbers,
+		AllMessages:  allMessages,
+		Filter:       *filter,
+		RowOffset:    *rowOffset,
+		Interp:       *interp,
 	}
 
 	// Show configuration summary if verbose
 	if *verbose {
 		fmt.Println("Configuration:")
 		fmt.Printf("  Input: %s\n", inputFile)
+		if *gridFile != "" {
+			fmt.Printf("  Grid: %s\n", *gridFile)
+		}
 		fmt.Printf("  Output: %s\n", outputFile)
 		fmt.Printf("  Zoom: %d to %d\n", minZoom, maxZoom)
 		fmt.Printf("  Workers: %d\n", *workers)
+		fmt.Printf("  Interpolation: %s\n", *interp)
+		if *filter != "" {
+			fmt.Printf("  Select: %s\n", *filter)
+		} else {
+			fmt.Printf("  Messages: %s\n", *messages)
+		}
 
 		if *area != "" {
 			fmt.Printf("  Area: %s\n", *area)
@@ -141,3 +242,37 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// parseMessageList parses a comma separated list of message numbers and
+// MIN-MAX ranges, e.g. "1,3,5-7".
+func parseMessageList(list string) ([]int, error) {
+	var numbers []int
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil || first < 1 {
+			return nil, fmt.Errorf("invalid message number: %s", part)
+		}
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil || last < first {
+				return nil, fmt.Errorf("invalid message range: %s", part)
+			}
+		}
+
+		for n := first; n <= last; n++ {
+			numbers = append(numbers, n)
+		}
+	}
+
+	if len(numbers) == 0 {
+		return nil, fmt.Errorf("no messages selected")
+	}
+	return numbers, nil
+}
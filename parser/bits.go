@@ -0,0 +1,89 @@
+package parser
+
+// bitReader reads big-endian unsigned integers of arbitrary width from a
+// byte slice, as used by the GRIB2 packing schemes.
+type bitReader struct {
+	data []byte
+	pos  int // bit position
+}
+
+func newBitReader(data []byte) *bitReader {
+	return &bitReader{data: data}
+}
+
+// read returns the next n bits (n <= 64). Bits past the end of the data read as zero.
+func (r *bitReader) read(n int) uint64 {
+	var v uint64
+	for n > 0 {
+		byteIndex := r.pos >> 3
+		if byteIndex >= len(r.data) {
+			v <<= uint(n)
+			r.pos += n
+			return v
+		}
+
+		bitOffset := r.pos & 7
+		available := 8 - bitOffset
+		take := available
+		if take > n {
+			take = n
+		}
+
+		b := uint64(r.data[byteIndex]>>uint(available-take)) & (1<<uint(take) - 1)
+		v = v<<uint(take) | b
+
+		r.pos += take
+		n -= take
+	}
+	return v
+}
+
+// align advances to the next octet boundary.
+func (r *bitReader) align() {
+	r.pos = (r.pos + 7) &^ 7
+}
+
+// remaining returns the number of unread bits.
+func (r *bitReader) remaining() int {
+	return len(r.data)*8 - r.pos
+}
+
+func uint16At(b []byte, offset int) int {
+	return int(b[offset])<<8 | int(b[offset+1])
+}
+
+func uint32At(b []byte, offset int) int {
+	return int(b[offset])<<24 | int(b[offset+1])<<16 | int(b[offset+2])<<8 | int(b[offset+3])
+}
+
+// int16At and int32At decode GRIB2 sign-and-magnitude integers.
+func int16At(b []byte, offset int) int {
+	v := uint16At(b, offset)
+	if v&0x8000 != 0 {
+		return -(v & 0x7FFF)
+	}
+	return v
+}
+
+func int32At(b []byte, offset int) int {
+	v := uint32At(b, offset)
+	if v&0x80000000 != 0 {
+		return -(v & 0x7FFFFFFF)
+	}
+	return v
+}
+
+// signMagnitude decodes an n-octet sign-and-magnitude integer.
+func signMagnitude(b []byte) int {
+	if len(b) == 0 {
+		return 0
+	}
+	v := int(b[0] & 0x7F)
+	for _, c := range b[1:] {
+		v = v<<8 | int(c)
+	}
+	if b[0]&0x80 != 0 {
+		return -v
+	}
+	return v
+}
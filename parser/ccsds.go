@@ -0,0 +1,195 @@
+package parser
+
+import "fmt"
+
+// CCSDS 121.0-B adaptive entropy coding as used by GRIB2 data
+// representation template 5.42, following the libaec bit stream layout.
+
+// ccsdsFlags (template 5.42 octet 22), matching the libaec AEC_* flags.
+const (
+	aecDataSigned     = 1
+	aecDataPreprocess = 8
+	aecRestricted     = 16
+	aecPadRSI         = 32
+)
+
+// aecRunOfZeros is the zero-block count signalling "zeros until the end of the segment".
+const aecRunOfZeros = 5
+
+type secondExtension struct {
+	beta  int
+	start int
+}
+
+var secondExtensionTable = func() []secondExtension {
+	var table []secondExtension
+	for beta := 0; beta < 13; beta++ {
+		start := len(table)
+		for j := 0; j <= beta; j++ {
+			table = append(table, secondExtension{beta: beta, start: start})
+		}
+	}
+	return table
+}()
+
+// decodeCCSDS returns numSamples unsigned samples from an AEC encoded stream.
+func decodeCCSDS(data []byte, numSamples, bitsPerSample, blockSize, rsi, flags int) ([]int64, error) {
+	if bitsPerSample < 1 || bitsPerSample > 32 || blockSize <= 0 || rsi <= 0 {
+		return nil, fmt.Errorf("ccsds: invalid parameters (bits %d, block size %d, rsi %d)", bitsPerSample, blockSize, rsi)
+	}
+	if flags&aecDataSigned != 0 {
+		return nil, fmt.Errorf("ccsds: signed samples are not supported")
+	}
+
+	idLen := 3
+	switch {
+	case bitsPerSample > 16:
+		idLen = 5
+	case bitsPerSample > 8:
+		idLen = 4
+	case flags&aecRestricted != 0 && bitsPerSample <= 2:
+		idLen = 1
+	case flags&aecRestricted != 0 && bitsPerSample <= 4:
+		idLen = 2
+	}
+	uncompressed := uint64(1)<<uint(idLen) - 1
+	preprocess := flags&aecDataPreprocess != 0
+
+	r := newBitReader(data)
+	readFS := func() (int, error) {
+		n := 0
+		for r.read(1) == 0 {
+			n++
+			if r.remaining() <= 0 {
+				return 0, fmt.Errorf("ccsds: truncated stream")
+			}
+		}
+		return n, nil
+	}
+
+	samples := make([]int64, 0, numSamples)
+	buf := make([]int64, 0, rsi*blockSize)
+
+	for len(samples) < numSamples {
+		if r.remaining() <= 0 {
+			return nil, fmt.Errorf("ccsds: stream ends after %d of %d samples", len(samples), numSamples)
+		}
+
+		buf = buf[:0]
+		for block := 0; block < rsi && len(samples)+len(buf) < numSamples; block++ {
+			ref := 0
+			if preprocess && block == 0 {
+				ref = 1
+			}
+
+			id := r.read(idLen)
+			switch {
+			case id == 0:
+				secondExt := r.read(1) == 1
+				if ref == 1 {
+					buf = append(buf, int64(r.read(bitsPerSample)))
+				}
+
+				if secondExt {
+					for i := ref; i < blockSize; {
+						m, err := readFS()
+						if err != nil {
+							return nil, err
+						}
+						if m >= len(secondExtensionTable) {
+							return nil, fmt.Errorf("ccsds: invalid second extension code %d", m)
+						}
+						se := secondExtensionTable[m]
+						d1 := m - se.start
+						if i%2 == 0 {
+							buf = append(buf, int64(se.beta-d1))
+							i++
+						}
+						buf = append(buf, int64(d1))
+						i++
+					}
+					continue
+				}
+
+				fs, err := readFS()
+				if err != nil {
+					return nil, err
+				}
+				zeroBlocks := fs + 1
+				if zeroBlocks == aecRunOfZeros {
+					zeroBlocks = min(rsi-block, 64-block%64)
+				} else if zeroBlocks > aecRunOfZeros {
+					zeroBlocks--
+				}
+				for i := 0; i < zeroBlocks*blockSize-ref; i++ {
+					buf = append(buf, 0)
+				}
+				block += zeroBlocks - 1
+			case id == uncompressed:
+				for i := 0; i < blockSize; i++ {
+					buf = append(buf, int64(r.read(bitsPerSample)))
+				}
+			default:
+				k := int(id) - 1
+				if ref == 1 {
+					buf = append(buf, int64(r.read(bitsPerSample)))
+				}
+				start := len(buf)
+				for i := ref; i < blockSize; i++ {
+					fs, err := readFS()
+					if err != nil {
+						return nil, err
+					}
+					buf = append(buf, int64(fs)<<uint(k))
+				}
+				for i := start; i < len(buf); i++ {
+					buf[i] += int64(r.read(k))
+				}
+			}
+		}
+
+		if preprocess {
+			postprocess(buf, bitsPerSample)
+		}
+		samples = append(samples, buf...)
+
+		if flags&aecPadRSI != 0 {
+			r.align()
+		}
+	}
+
+	return samples[:numSamples], nil
+}
+
+// postprocess inverts the unit-delay predictor and the prediction error
+// mapping of one reference sample interval in place.
+func postprocess(buf []int64, bitsPerSample int) {
+	if len(buf) == 0 {
+		return
+	}
+
+	xmax := int64(1)<<uint(bitsPerSample) - 1
+	med := int64(1) << uint(bitsPerSample-1)
+
+	data := buf[0]
+	for i := 1; i < len(buf); i++ {
+		d := buf[i]
+		halfD := d>>1 + d&1
+
+		mask := int64(0)
+		if data&med != 0 {
+			mask = xmax
+		}
+
+		if halfD <= mask^data {
+			if d&1 == 0 {
+				data += d >> 1
+			} else {
+				data -= (d + 1) >> 1
+			}
+		} else {
+			data = mask ^ d
+		}
+		buf[i] = data
+	}
+}
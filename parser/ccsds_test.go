@@ -0,0 +1,374 @@
+package parser
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+// ccsdsEncoder is a CCSDS 121.0-B encoder with the libaec bit stream layout,
+// which codes every block with the cheapest of the coding options. It counts
+// the options it uses, so tests can check that they cover all of them.
+type ccsdsEncoder struct {
+	bits, blockSize, rsi, flags int
+	idLen                       int
+	w                           testBitWriter
+	options                     map[string]int
+}
+
+func newCCSDSEncoder(bits, blockSize, rsi, flags int) *ccsdsEncoder {
+	idLen := 3
+	switch {
+	case bits > 16:
+		idLen = 5
+	case bits > 8:
+		idLen = 4
+	case flags&aecRestricted != 0 && bits <= 2:
+		idLen = 1
+	case flags&aecRestricted != 0 && bits <= 4:
+		idLen = 2
+	}
+	return &ccsdsEncoder{bits: bits, blockSize: blockSize, rsi: rsi, flags: flags, idLen: idLen, options: make(map[string]int)}
+}
+
+// fs writes the fundamental sequence code of n.
+func (e *ccsdsEncoder) fs(n int) {
+	for ; n > 0; n-- {
+		e.w.write(0, 1)
+	}
+	e.w.write(1, 1)
+}
+
+// mapResidual maps the difference between a sample and its prediction to a
+// non-negative integer (CCSDS 121.0-B section 4.3.2).
+func mapResidual(x, pred, xmax int64) int64 {
+	theta := min64(pred, xmax-pred)
+	delta := x - pred
+	switch {
+	case delta >= 0 && delta <= theta:
+		return 2 * delta
+	case delta < 0 && -delta <= theta:
+		return -2*delta - 1
+	case delta < 0:
+		return theta - delta
+	}
+	return theta + delta
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (e *ccsdsEncoder) encode(samples []int64) []byte {
+	preprocess := e.flags&aecDataPreprocess != 0
+	xmax := int64(1)<<uint(e.bits) - 1
+
+	for offset := 0; offset < len(samples); offset += e.rsi * e.blockSize {
+		rsi := samples[offset:min(offset+e.rsi*e.blockSize, len(samples))]
+
+		// Pad the last block by repeating the last sample.
+		blocks := (len(rsi) + e.blockSize - 1) / e.blockSize
+		raw := append([]int64(nil), rsi...)
+		for len(raw) < blocks*e.blockSize {
+			raw = append(raw, raw[len(raw)-1])
+		}
+
+		coded := raw
+		if preprocess {
+			coded = make([]int64, len(raw))
+			coded[0] = raw[0]
+			for i := 1; i < len(raw); i++ {
+				coded[i] = mapResidual(raw[i], raw[i-1], xmax)
+			}
+		}
+
+		for b := 0; b < blocks; {
+			ref := 0
+			if preprocess && b == 0 {
+				ref = 1
+			}
+
+			// Runs of zero blocks end at the end of a segment of 64 blocks
+			// or of the reference sample interval.
+			run := 0
+			for b+run < blocks && isZeroBlock(coded[(b+run)*e.blockSize:(b+run+1)*e.blockSize], ref, run) {
+				run++
+				if (b+run)%64 == 0 {
+					break
+				}
+			}
+			if run > 0 {
+				e.w.write(0, e.idLen)
+				e.w.write(0, 1)
+				if ref == 1 {
+					e.w.write(uint64(coded[0]), e.bits)
+				}
+				end := b+run == blocks || (b+run)%64 == 0
+				switch {
+				case run >= aecRunOfZeros && end:
+					e.fs(aecRunOfZeros - 1)
+					e.options["remainder of segment"]++
+				case run < aecRunOfZeros:
+					e.fs(run - 1)
+					e.options["zero blocks"]++
+				default:
+					e.fs(run)
+					e.options["zero blocks"]++
+				}
+				b += run
+				continue
+			}
+
+			e.block(coded[b*e.blockSize:(b+1)*e.blockSize], ref)
+			b++
+		}
+
+		if e.flags&aecPadRSI != 0 {
+			e.w.align()
+		}
+	}
+	return e.w.buf
+}
+
+func isZeroBlock(block []int64, ref, run int) bool {
+	if run > 0 {
+		ref = 0
+	}
+	for _, v := range block[ref:] {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// block codes a block that is not all zeros with the cheapest option.
+func (e *ccsdsEncoder) block(block []int64, ref int) {
+	best, bestK := e.bits*len(block), -1
+
+	// The second extension codes pairs of samples. A pair starts at an
+	// even index, so with a reference sample the first pair holds only the
+	// sample after it.
+	var pairs []int
+	for i := ref; i < len(block); {
+		a, b := int64(0), block[i]
+		if i%2 == 0 {
+			a, b = block[i], block[i+1]
+			i += 2
+		} else {
+			i++
+		}
+		if a+b > 12 {
+			pairs = nil
+			break
+		}
+		pairs = append(pairs, int((a+b)*(a+b+1)/2+b))
+	}
+	if pairs != nil {
+		cost := 1 + ref*e.bits
+		for _, m := range pairs {
+			cost += m + 1
+		}
+		if cost < best {
+			best, bestK = cost, -2
+		}
+	}
+
+	for k := 0; k <= 1<<uint(e.idLen)-3; k++ {
+		cost := ref*e.bits + k*(len(block)-ref)
+		for _, v := range block[ref:] {
+			cost += int(v>>uint(k)) + 1
+		}
+		if cost < best {
+			best, bestK = cost, k
+		}
+	}
+
+	switch bestK {
+	case -1:
+		e.w.write(1<<uint(e.idLen)-1, e.idLen)
+		for _, v := range block {
+			e.w.write(uint64(v), e.bits)
+		}
+		e.options["uncompressed"]++
+		return
+	case -2:
+		e.w.write(0, e.idLen)
+		e.w.write(1, 1)
+	default:
+		e.w.write(uint64(bestK+1), e.idLen)
+	}
+
+	if ref == 1 {
+		e.w.write(uint64(block[0]), e.bits)
+	}
+	if bestK == -2 {
+		for _, m := range pairs {
+			e.fs(m)
+		}
+		e.options["second extension"]++
+		return
+	}
+	for _, v := range block[ref:] {
+		e.fs(int(v >> uint(bestK)))
+	}
+	for _, v := range block[ref:] {
+		e.w.write(uint64(v), bestK)
+	}
+	e.options["split"]++
+}
+
+// ccsdsSamples returns n samples of the given width that alternate between
+// constant stretches, gentle ramps, low noise and noise over the full range.
+func ccsdsSamples(n, bits int, seed int64) []int64 {
+	rng := rand.New(rand.NewSource(seed))
+	xmax := int64(1)<<uint(bits) - 1
+	samples := make([]int64, n)
+	v := xmax / 3
+	for i := range samples {
+		switch i / 700 % 4 {
+		case 0:
+		case 1:
+			if i%5 == 0 {
+				v++
+			}
+		case 2:
+			v += rng.Int63n(3) - 1
+		case 3:
+			v = rng.Int63n(xmax + 1)
+		}
+		v = max(0, min64(v, xmax))
+		samples[i] = v
+	}
+	return samples
+}
+
+func TestCCSDSReferenceStream(t *testing.T) {
+	tests := []struct {
+		name                    string
+		data                    []byte
+		bits, blockSize, rsi, n int
+		flags                   int
+		want                    []int64
+	}{
+		{
+			// Option k=1 (identifier 010), the reference sample 10, the
+			// fundamental sequences of the mapped residuals 2 0 3 6 0 0 2
+			// shifted right by one, then their least significant bits.
+			name: "split", data: []byte{0x41, 0x4d, 0x1d, 0x20},
+			bits: 8, blockSize: 8, rsi: 1, n: 8, flags: aecDataPreprocess,
+			want: []int64{10, 11, 11, 9, 12, 12, 12, 13},
+		},
+		{
+			// Identifier 000, no second extension, then the fundamental
+			// sequence 00001 for a run of zero blocks to the end of the
+			// reference sample interval.
+			name: "remainder of segment", data: []byte{0x00, 0x80},
+			bits: 8, blockSize: 8, rsi: 4, n: 32,
+			want: make([]int64, 32),
+		},
+		{
+			// Identifier 1111 (uncompressed) and two 12 bit samples.
+			name: "uncompressed", data: []byte{0xfa, 0xbc, 0xde, 0xf0},
+			bits: 12, blockSize: 8, rsi: 1, n: 2,
+			want: []int64{0xabc, 0xdef},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeCCSDS(tt.data, tt.n, tt.bits, tt.blockSize, tt.rsi, tt.flags)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSamples(t, got, tt.want)
+		})
+	}
+}
+
+func TestCCSDSRoundTrip(t *testing.T) {
+	tests := []struct {
+		name                 string
+		bits, blockSize, rsi int
+		flags                int
+	}{
+		{"preprocess", 8, 16, 128, aecDataPreprocess},
+		{"padded", 12, 8, 32, aecDataPreprocess | aecPadRSI},
+		{"restricted 2 bits", 2, 16, 64, aecDataPreprocess | aecRestricted},
+		{"restricted 4 bits", 4, 32, 16, aecDataPreprocess | aecRestricted},
+		{"4 bits", 4, 8, 128, aecDataPreprocess},
+		{"no preprocessing", 10, 8, 100, 0},
+		{"24 bits", 24, 16, 128, aecDataPreprocess | aecPadRSI},
+		{"32 bits", 32, 64, 8, aecDataPreprocess},
+	}
+
+	options := make(map[string]int)
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples := ccsdsSamples(3001, tt.bits, int64(i))
+			if tt.flags&aecDataPreprocess == 0 {
+				// Without preprocessing only zero samples form zero blocks.
+				for j := range samples[:800] {
+					samples[j] = 0
+				}
+			}
+
+			e := newCCSDSEncoder(tt.bits, tt.blockSize, tt.rsi, tt.flags)
+			data := e.encode(samples)
+			for option, n := range e.options {
+				options[option] += n
+			}
+
+			got, err := decodeCCSDS(data, len(samples), tt.bits, tt.blockSize, tt.rsi, tt.flags)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSamples(t, got, samples)
+		})
+	}
+
+	for _, option := range []string{"zero blocks", "remainder of segment", "second extension", "split", "uncompressed"} {
+		if options[option] == 0 {
+			t.Errorf("no block coded with option %s", option)
+		}
+	}
+}
+
+func TestCCSDSSigned(t *testing.T) {
+	if _, err := decodeCCSDS([]byte{0}, 1, 8, 8, 1, aecDataSigned); err == nil {
+		t.Error("signed samples decoded")
+	}
+}
+
+func TestNativeCCSDS(t *testing.T) {
+	grid := testGrid{nx: 37, ny: 29, la1: 60, lo1: -10, la2: 32, lo2: 26}
+	samples := ccsdsSamples(grid.nx*grid.ny, 16, 1)
+	sec5, data := packCCSDS(samples, 16, 1000, -4, 1)
+	g, err := decodeNative(testMessage(grid, sec5, nil, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValues(t, g.DataValues, unpacked(samples, nil, 1000, -4, 1))
+}
+
+// packCCSDS packs integers with CCSDS packing (template 5.42).
+func packCCSDS(samples []int64, bits int, reference float32, binaryScale, decimalScale int) (sec5, data []byte) {
+	const flags, blockSize, rsi = aecDataPreprocess | aecPadRSI, 32, 128
+	data = newCCSDSEncoder(bits, blockSize, rsi, flags).encode(samples)
+	specific := binary.BigEndian.AppendUint16([]byte{flags, blockSize}, rsi)
+	return testDataRepresentation(42, len(samples), reference, binaryScale, decimalScale, bits, specific...), data
+}
+
+func checkSamples(t *testing.T, got, want []int64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("decoded %d samples, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("sample %d = %d, want %d", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,335 @@
+//go:build cgo && !purego
+
+package parser
+
+/*
+#cgo CFLAGS: -I/usr/include/x86_64-linux-gnu/
+#cgo LDFLAGS: -leccodes
+#include "eccodes.h"
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+func getString(gid *C.codes_handle, key string) string {
+	cKey := C.CString(key)
+	defer C.free(unsafe.Pointer(cKey))
+
+	var length C.size_t
+	if C.codes_get_length(gid, cKey, &length) != C.CODES_SUCCESS || length == 0 {
+		return ""
+	}
+
+	buf := (*C.char)(C.malloc(length))
+	defer C.free(unsafe.Pointer(buf))
+
+	if C.codes_get_string(gid, cKey, buf, &length) != C.CODES_SUCCESS {
+		return ""
+	}
+	return C.GoString(buf)
+}
+
+// keyReader reads ecCodes keys of a message, keeping the first error.
+type keyReader struct {
+	gid *C.codes_handle
+	err error
+}
+
+func (r *keyReader) check(key string, ret C.int) {
+	if ret == C.CODES_SUCCESS || r.err != nil {
+		return
+	}
+	if ret == C.CODES_NOT_FOUND {
+		r.err = &MissingKeyError{Key: key}
+		return
+	}
+	r.err = &DecodeError{Err: fmt.Errorf("%s: %s", key, C.GoString(C.codes_get_error_message(ret)))}
+}
+
+func (r *keyReader) long(key string) int {
+	cKey := C.CString(key)
+	defer C.free(unsafe.Pointer(cKey))
+
+	var value C.long
+	r.check(key, C.codes_get_long(r.gid, cKey, &value))
+	return int(value)
+}
+
+func (r *keyReader) double(key string) float64 {
+	cKey := C.CString(key)
+	defer C.free(unsafe.Pointer(cKey))
+
+	var value C.double
+	r.check(key, C.codes_get_double(r.gid, cKey, &value))
+	return float64(value)
+}
+
+func (r *keyReader) longArray(key string) []int {
+	cKey := C.CString(key)
+	defer C.free(unsafe.Pointer(cKey))
+
+	var size C.size_t
+	r.check(key, C.codes_get_size(r.gid, cKey, &size))
+	if r.err != nil || size == 0 {
+		return nil
+	}
+
+	values := make([]C.long, size)
+	r.check(key, C.codes_get_long_array(r.gid, cKey, &values[0], &size))
+
+	result := make([]int, size)
+	for i := range result {
+		result[i] = int(values[i])
+	}
+	return result
+}
+
+// optionalDouble returns the value of key, or 0 if the message does not define it.
+func (r *keyReader) optionalDouble(key string) float64 {
+	cKey := C.CString(key)
+	defer C.free(unsafe.Pointer(cKey))
+
+	var value C.double
+	if C.codes_get_double(r.gid, cKey, &value) != C.CODES_SUCCESS {
+		return 0
+	}
+	return float64(value)
+}
+
+func ProcessGRIB(gribData []byte) (GRIBFile, error) {
+	if len(gribData) == 0 {
+		return GRIBFile{}, ErrEmptyMessage
+	}
+
+	dataPtr := unsafe.Pointer(&gribData[0])
+	dataSize := C.size_t(len(gribData))
+
+	var gid *C.codes_handle = C.codes_handle_new_from_message(C.codes_context_get_default(), dataPtr, dataSize)
+	if gid == nil {
+		return GRIBFile{}, &DecodeError{Err: errors.New("cannot create handle from message")}
+	}
+	defer C.codes_handle_delete(gid)
+
+	r := &keyReader{gid: gid}
+
+	var header GribHeader
+	if err := readGrid(r, &header); err != nil {
+		return GRIBFile{}, err
+	}
+
+	header.MissingValue = r.double("missingValue")
+
+	// Extract reference time
+	year := r.long("year")
+	month := r.long("month")
+	day := r.long("day")
+	hour := r.long("hour")
+	minute := r.long("minute")
+	second := r.long("second")
+	timeUnit := r.long("indicatorOfUnitOfTimeRange")
+	header.ForecastTime = r.long("forecastTime")
+	header.EndStep = r.long("endStep")
+	header.Discipline = r.long("discipline")
+	header.ParameterCategory = r.long("parameterCategory")
+	header.ParameterNumber = r.long("parameterNumber")
+	header.Level = r.long("level")
+
+	if r.err != nil {
+		return GRIBFile{}, r.err
+	}
+
+	header.ShortName = getString(gid, "shortName")
+	header.TypeOfLevel = getString(gid, "typeOfLevel")
+
+	header.Type = int32((header.Discipline & 0xFF) | ((header.ParameterCategory & 0xFF) << 8) | ((header.ParameterNumber & 0xFF) << 16))
+
+	header.DataTime = time.Date(year, time.Month(month), day, hour, minute, second, 0, time.UTC)
+
+	header.ReferenceTime = header.DataTime
+	if step, ok := forecastDuration(timeUnit, header.ForecastTime); ok {
+		header.ValidTime = header.DataTime.Add(step)
+		header.ReferenceTime = header.ValidTime
+	}
+
+	// Getting the values
+	dataValues, err := getValues(r, gribData, header.MissingValue)
+	if err != nil {
+		return GRIBFile{}, decodeError(err)
+	}
+
+	parsedGrib := GRIBFile{Header: header, DataValues: dataValues}
+	if err := parsedGrib.checkGrid(); err != nil {
+		return GRIBFile{}, err
+	}
+
+	return newGRIBFile(canonicalScan(header, dataValues)), nil
+}
+
+// readGrid reads the grid description of the message into header.
+func readGrid(r *keyReader, header *GribHeader) error {
+	template := r.long("gridDefinitionTemplateNumber")
+	if r.err != nil {
+		return r.err
+	}
+
+	if template == 101 {
+		// Unstructured grids define neither the size of the Earth nor a
+		// scanning mode, and their cell coordinates are in a separate grid
+		// file, see UnstructuredGrid.
+		header.GridType = GridUnstructured
+		header.EarthMajorAxis, header.EarthMinorAxis = earthShape(r.long("shapeOfTheEarth"), 0, 0, 0)
+		header.Nx = r.long("numberOfDataPoints")
+		header.Ny = 1
+		header.GridNumber = r.long("numberOfGridUsed")
+		header.GridReference = r.long("numberOfGridInReference")
+		header.GridUUID = strings.ToLower(getString(r.gid, "uuidOfHGrid"))
+		return r.err
+	}
+
+	header.EarthMajorAxis, header.EarthMinorAxis = earthShape(r.long("shapeOfTheEarth"),
+		scaledEarthValue(r.long("scaleFactorOfRadiusOfSphericalEarth"), r.long("scaledValueOfRadiusOfSphericalEarth")),
+		scaledEarthValue(r.long("scaleFactorOfEarthMajorAxis"), r.long("scaledValueOfEarthMajorAxis")),
+		scaledEarthValue(r.long("scaleFactorOfEarthMinorAxis"), r.long("scaledValueOfEarthMinorAxis")))
+	header.ScanMode = r.long("scanMode")
+
+	switch template {
+	case 0, 1:
+		header.GridType = GridRegularLatLon
+		if template == 1 {
+			header.GridType = GridRotatedLatLon
+			header.SouthPoleLat = r.double("latitudeOfSouthernPoleInDegrees")
+			header.SouthPoleLon = r.double("longitudeOfSouthernPoleInDegrees")
+			header.RotationAngle = r.double("angleOfRotationInDegrees")
+		}
+		header.Nx = r.long("Ni")
+		header.Ny = r.long("Nj")
+		header.La1 = r.double("latitudeOfFirstGridPointInDegrees")
+		header.La2 = r.double("latitudeOfLastGridPointInDegrees")
+		header.Lo1 = r.double("longitudeOfFirstGridPointInDegrees")
+		header.Lo2 = r.double("longitudeOfLastGridPointInDegrees")
+		dx := r.double("iDirectionIncrement")
+		dy := r.double("jDirectionIncrement")
+		basicAngle := r.optionalDouble("basicAngleOfTheInitialProductionDomain")
+		subdivisions := r.optionalDouble("subdivisionsOfBasicAngle")
+
+		scale := 1e6 // Default scale if no basicAngle is defined
+
+		if basicAngle != 0 && subdivisions != 0 {
+			scale = basicAngle / subdivisions
+		}
+
+		header.DX = dx / scale
+		header.DY = dy / scale
+	case 40:
+		header.Ny = r.long("Nj")
+		header.N = r.long("N")
+		header.La1 = r.double("latitudeOfFirstGridPointInDegrees")
+		header.La2 = r.double("latitudeOfLastGridPointInDegrees")
+		header.Lo1 = r.double("longitudeOfFirstGridPointInDegrees")
+		header.Lo2 = r.double("longitudeOfLastGridPointInDegrees")
+		if header.N > 0 {
+			header.DY = 90 / float64(header.N)
+		}
+
+		if r.long("PLPresent") == 1 {
+			header.GridType = GridReducedGaussian
+			header.PL = r.longArray("pl")
+			for _, n := range header.PL {
+				header.Nx = max(header.Nx, n)
+			}
+		} else {
+			header.GridType = GridRegularGaussian
+			header.Nx = r.long("Ni")
+			header.DX = r.double("iDirectionIncrementInDegrees")
+		}
+	case 30:
+		header.GridType = GridLambert
+		header.Nx = r.long("Nx")
+		header.Ny = r.long("Ny")
+		header.La1 = r.double("latitudeOfFirstGridPointInDegrees")
+		header.Lo1 = r.double("longitudeOfFirstGridPointInDegrees")
+		header.LaD = r.double("LaDInDegrees")
+		header.LoV = r.double("LoVInDegrees")
+		header.Latin1 = r.double("Latin1InDegrees")
+		header.Latin2 = r.double("Latin2InDegrees")
+		header.DX = r.double("DxInMetres")
+		header.DY = r.double("DyInMetres")
+		header.ProjectionCentre = r.long("projectionCentreFlag")
+
+		if r.err == nil {
+			header.La2, header.Lo2 = GRIBFile{Header: *header}.GetLatLng(header.Nx-1, header.Ny-1)
+		}
+	case 20:
+		header.GridType = GridPolarStereographic
+		header.Nx = r.long("Nx")
+		header.Ny = r.long("Ny")
+		header.La1 = r.double("latitudeOfFirstGridPointInDegrees")
+		header.Lo1 = r.double("longitudeOfFirstGridPointInDegrees")
+		header.LaD = r.double("LaDInDegrees")
+		header.LoV = r.double("orientationOfTheGridInDegrees")
+		header.DX = r.double("DxInMetres")
+		header.DY = r.double("DyInMetres")
+		header.ProjectionCentre = r.long("projectionCentreFlag")
+
+		if r.err == nil {
+			header.La2, header.Lo2 = GRIBFile{Header: *header}.GetLatLng(header.Nx-1, header.Ny-1)
+		}
+	default:
+		return &UnsupportedTemplateError{Section: 3, Template: template}
+	}
+
+	return r.err
+}
+
+// nativePackings are the data representation templates unpacked in Go, so
+// that they work with ecCodes builds lacking OpenJPEG, libpng or libaec.
+var nativePackings = map[int]bool{
+	40: true, // JPEG 2000
+	41: true, // PNG
+	42: true, // CCSDS
+}
+
+func getValues(r *keyReader, gribData []byte, missingValue float64) ([]float64, error) {
+	template := r.long("dataRepresentationTemplateNumber")
+	numPoints := r.long("numberOfDataPoints")
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if nativePackings[template] {
+		sections, err := readSections(gribData)
+		if err != nil {
+			return nil, err
+		}
+		return decodeData(sections, numPoints, missingValue)
+	}
+
+	cKey := C.CString("values")
+	defer C.free(unsafe.Pointer(cKey))
+
+	var numValues C.size_t
+	r.check("values", C.codes_get_size(r.gid, cKey, &numValues))
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	values := (*C.double)(C.malloc(numValues * C.sizeof_double))
+	defer C.free(unsafe.Pointer(values))
+
+	r.check("values", C.codes_get_double_array(r.gid, cKey, values, &numValues))
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	dataValues := make([]float64, numValues)
+	for i := C.size_t(0); i < numValues; i++ {
+		dataValues[i] = float64(*(*C.double)(unsafe.Pointer(uintptr(unsafe.Pointer(values)) + uintptr(i)*uintptr(C.sizeof_double))))
+	}
+	return dataValues, nil
+}
@@ -0,0 +1,54 @@
+//go:build cgo && !purego
+
+package parser
+
+import (
+	"math"
+	"testing"
+)
+
+// TestNativeMatchesECCodes decodes the test messages with ecCodes and with
+// the native decoder, which must agree on the header and the values.
+func TestNativeMatchesECCodes(t *testing.T) {
+	for name, msg := range testMessages(t) {
+		t.Run(name, func(t *testing.T) {
+			want, err := ProcessGRIB(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := decodeNative(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			g, w := got.Header, want.Header
+			if g.GridType != w.GridType || g.Nx != w.Nx || g.Ny != w.Ny || g.ScanMode != w.ScanMode {
+				t.Errorf("grid %s of %dx%d in mode %d, ecCodes %s of %dx%d in mode %d",
+					g.GridType, g.Nx, g.Ny, g.ScanMode, w.GridType, w.Nx, w.Ny, w.ScanMode)
+			}
+			if g.La1 != w.La1 || g.Lo1 != w.Lo1 || g.La2 != w.La2 || g.Lo2 != w.Lo2 || g.DX != w.DX || g.DY != w.DY {
+				t.Errorf("corners %g,%g %g,%g with increments %g,%g, ecCodes %g,%g %g,%g with increments %g,%g",
+					g.La1, g.Lo1, g.La2, g.Lo2, g.DX, g.DY, w.La1, w.Lo1, w.La2, w.Lo2, w.DX, w.DY)
+			}
+			if g.Discipline != w.Discipline || g.ParameterCategory != w.ParameterCategory || g.ParameterNumber != w.ParameterNumber ||
+				g.ShortName != w.ShortName || g.TypeOfLevel != w.TypeOfLevel || g.Level != w.Level {
+				t.Errorf("parameter %d.%d.%d %s on %s %d, ecCodes %d.%d.%d %s on %s %d",
+					g.Discipline, g.ParameterCategory, g.ParameterNumber, g.ShortName, g.TypeOfLevel, g.Level,
+					w.Discipline, w.ParameterCategory, w.ParameterNumber, w.ShortName, w.TypeOfLevel, w.Level)
+			}
+			if !g.DataTime.Equal(w.DataTime) || !g.ValidTime.Equal(w.ValidTime) || g.ForecastTime != w.ForecastTime {
+				t.Errorf("data time %s, valid time %s, step %d, ecCodes %s, %s, %d",
+					g.DataTime, g.ValidTime, g.ForecastTime, w.DataTime, w.ValidTime, w.ForecastTime)
+			}
+
+			if len(got.DataValues) != len(want.DataValues) {
+				t.Fatalf("%d values, ecCodes %d", len(got.DataValues), len(want.DataValues))
+			}
+			for i, v := range want.DataValues {
+				if math.Abs(got.DataValues[i]-v) > 1e-6*math.Max(1, math.Abs(v)) {
+					t.Errorf("value %d = %g, ecCodes %g", i, got.DataValues[i], v)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrEmptyMessage is returned when ProcessGRIB is given no data.
+var ErrEmptyMessage = errors.New("empty GRIB message")
+
+// UnsupportedTemplateError reports a GRIB2 template number that cannot be decoded.
+type UnsupportedTemplateError struct {
+	Section  int
+	Template int
+}
+
+func (e *UnsupportedTemplateError) Error() string {
+	kind := "section"
+	switch e.Section {
+	case 3:
+		kind = "grid definition"
+	case 4:
+		kind = "product definition"
+	case 5:
+		kind = "data representation"
+	}
+	return fmt.Sprintf("unsupported %s template %d.%d", kind, e.Section, e.Template)
+}
+
+// MissingKeyError reports a key that is required but not present in a message.
+type MissingKeyError struct {
+	Key string
+}
+
+func (e *MissingKeyError) Error() string {
+	return fmt.Sprintf("missing key %q", e.Key)
+}
+
+// DecodeError reports a message that is malformed or whose data cannot be unpacked.
+type DecodeError struct {
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode message: %v", e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// MessageError records the 1-based number of the message of a file that
+// failed. Each field of a message that repeats sections counts as a message.
+type MessageError struct {
+	Message int
+	Err     error
+}
+
+func (e *MessageError) Error() string {
+	return fmt.Sprintf("message %d: %v", e.Message, e.Err)
+}
+
+func (e *MessageError) Unwrap() error {
+	return e.Err
+}
+
+// decodeError wraps err in a DecodeError unless it already is one of the
+// typed errors of this package.
+func decodeError(err error) error {
+	var unsupported *UnsupportedTemplateError
+	var missing *MissingKeyError
+	var decode *DecodeError
+	if errors.As(err, &unsupported) || errors.As(err, &missing) || errors.As(err, &decode) || errors.Is(err, ErrEmptyMessage) {
+		return err
+	}
+	return &DecodeError{Err: err}
+}
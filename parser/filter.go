@@ -0,0 +1,140 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Filter selects GRIB messages by header keys using the grib_copy -w syntax:
+// comma separated key=value conditions that must all hold, "/" separated
+// alternatives and != for negation, e.g. "shortName=t,level=850/500,step!=0".
+type Filter []filterCondition
+
+type filterCondition struct {
+	Key    string
+	Values []string
+	Negate bool
+}
+
+var filterKeys = map[string]func(h GribHeader) string{
+	"shortName":         func(h GribHeader) string { return h.ShortName },
+	"typeOfLevel":       func(h GribHeader) string { return h.TypeOfLevel },
+	"level":             func(h GribHeader) string { return strconv.Itoa(h.Level) },
+	"step":              func(h GribHeader) string { return strconv.Itoa(h.EndStep) },
+	"forecastTime":      func(h GribHeader) string { return strconv.Itoa(h.ForecastTime) },
+	"discipline":        func(h GribHeader) string { return strconv.Itoa(h.Discipline) },
+	"parameterCategory": func(h GribHeader) string { return strconv.Itoa(h.ParameterCategory) },
+	"parameterNumber":   func(h GribHeader) string { return strconv.Itoa(h.ParameterNumber) },
+	"parameter": func(h GribHeader) string {
+		return fmt.Sprintf("%d.%d.%d", h.Discipline, h.ParameterCategory, h.ParameterNumber)
+	},
+}
+
+func ParseFilter(expr string) (Filter, error) {
+	var filter Filter
+
+	for _, term := range strings.Split(expr, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		negate := false
+		key, value, found := strings.Cut(term, "!=")
+		if found {
+			negate = true
+		} else {
+			key, value, found = strings.Cut(term, "=")
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		if !found || key == "" || value == "" {
+			return nil, fmt.Errorf("invalid filter condition %q, expected key=value", term)
+		}
+		if _, ok := filterKeys[key]; !ok {
+			return nil, fmt.Errorf("unknown filter key %q, supported keys: %s", key, strings.Join(FilterKeys(), ", "))
+		}
+
+		values := strings.Split(value, "/")
+		for i := range values {
+			values[i] = strings.TrimSpace(values[i])
+		}
+
+		filter = append(filter, filterCondition{Key: key, Values: values, Negate: negate})
+	}
+
+	if len(filter) == 0 {
+		return nil, fmt.Errorf("empty filter expression")
+	}
+	return filter, nil
+}
+
+// FilterKeys returns the header keys that can be used in a filter expression.
+func FilterKeys() []string {
+	keys := make([]string, 0, len(filterKeys))
+	for key := range filterKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f Filter) Match(g GRIBFile) bool {
+	for _, condition := range f {
+		actual := filterKeys[condition.Key](g.Header)
+
+		matched := false
+		for _, value := range condition.Values {
+			if actual == value {
+				matched = true
+				break
+			}
+		}
+
+		if matched == condition.Negate {
+			return false
+		}
+	}
+	return true
+}
+
+// Select returns the 0-based indices of all messages matching the filter.
+// Messages that could not be decoded never match.
+func (f Filter) Select(gribFiles []GRIBFile) []int {
+	var indices []int
+	for i, g := range gribFiles {
+		if g.Err == nil && f.Match(g) {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// unknownShortName is the shortName of parameters missing from the tables.
+const unknownShortName = "unknown"
+
+// Unresolved returns the 0-based indices of the messages whose parameter has
+// no shortName, if the filter selects by shortName. Those messages never
+// match a shortName, select them by parameter instead.
+func (f Filter) Unresolved(gribFiles []GRIBFile) []int {
+	byName := false
+	for _, condition := range f {
+		if condition.Key == "shortName" {
+			byName = true
+		}
+	}
+	if !byName {
+		return nil
+	}
+
+	var indices []int
+	for i, g := range gribFiles {
+		if g.Err == nil && (g.Header.ShortName == "" || g.Header.ShortName == unknownShortName) {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
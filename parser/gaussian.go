@@ -0,0 +1,313 @@
+package parser
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+var gaussianCache = struct {
+	sync.Mutex
+	latitudes map[int][]float64
+}{latitudes: map[int][]float64{}}
+
+// gaussianLatitudes returns the 2n latitudes of a Gaussian grid with n
+// parallels between a pole and the equator, from north to south. These are
+// the arcsines of the roots of the Legendre polynomial of degree 2n.
+func gaussianLatitudes(n int) []float64 {
+	gaussianCache.Lock()
+	defer gaussianCache.Unlock()
+
+	if lats, ok := gaussianCache.latitudes[n]; ok {
+		return lats
+	}
+
+	degree := 2 * n
+	lats := make([]float64, degree)
+	for k := 0; k < n; k++ {
+		// Newton iteration from the asymptotic estimate of the k-th root.
+		x := math.Cos(math.Pi * (float64(k) + 0.75) / (float64(degree) + 0.5))
+		for i := 0; i < 100; i++ {
+			p0, p1 := 1.0, x
+			for l := 2; l <= degree; l++ {
+				p0, p1 = p1, ((2*float64(l)-1)*x*p1-(float64(l)-1)*p0)/float64(l)
+			}
+			dp := float64(degree) * (x*p1 - p0) / (x*x - 1)
+			dx := p1 / dp
+			x -= dx
+			if math.Abs(dx) < 1e-15 {
+				break
+			}
+		}
+
+		lat := degrees(math.Asin(x))
+		lats[k] = lat
+		lats[degree-1-k] = -lat
+	}
+
+	gaussianCache.latitudes[n] = lats
+	return lats
+}
+
+// gridInfo holds the row layout of Gaussian grids.
+type gridInfo struct {
+	latitudes  []float64 // latitude of every row, in scanning order
+	rowStart   []int     // index of the first value of every row (reduced grids)
+	poleToPole bool      // the rows include all Gaussian latitudes
+}
+
+func newGridInfo(h GribHeader) *gridInfo {
+	if h.GridType != GridRegularGaussian && h.GridType != GridReducedGaussian {
+		return nil
+	}
+
+	info := &gridInfo{}
+
+	// The rows are a consecutive run of the global Gaussian latitudes,
+	// which may be a sub-area starting at La1.
+	global := gaussianLatitudes(h.N)
+	first := 0
+	for i, lat := range global {
+		if math.Abs(lat-h.La1) < math.Abs(global[first]-h.La1) {
+			first = i
+		}
+	}
+	step := 1
+	if h.La1 < h.La2 {
+		step = -1
+	}
+	for j := 0; j < h.Ny; j++ {
+		i := first + j*step
+		if i < 0 || i >= len(global) {
+			break
+		}
+		info.latitudes = append(info.latitudes, global[i])
+	}
+	info.poleToPole = len(info.latitudes) == len(global)
+
+	if len(h.PL) > 0 {
+		info.rowStart = make([]int, len(h.PL)+1)
+		for j, n := range h.PL {
+			info.rowStart[j+1] = info.rowStart[j] + n
+		}
+	}
+
+	return info
+}
+
+// rows returns the row layout of a Gaussian grid.
+func (g GRIBFile) rows() *gridInfo {
+	if g.grid != nil {
+		return g.grid
+	}
+	return newGridInfo(g.Header)
+}
+
+// gaussianRow returns the fractional row of a latitude in a Gaussian grid,
+// and whether it lies within the grid. Latitudes between the outermost rows
+// and the poles of global grids map onto the outermost rows.
+func (info *gridInfo) gaussianRow(lat float64) (float64, bool) {
+	lats := info.latitudes
+	n := len(lats)
+	if n == 0 {
+		return 0, false
+	}
+
+	descending := n == 1 || lats[0] > lats[n-1]
+	top, bottom := lats[0], lats[n-1]
+	if !descending {
+		top, bottom = bottom, top
+	}
+
+	if lat > top || lat < bottom {
+		if !info.poleToPole {
+			return 0, false
+		}
+		if (lat > top) == descending {
+			return 0, true
+		}
+		return float64(n - 1), true
+	}
+	if n == 1 {
+		return 0, true
+	}
+
+	// Index of the first row beyond lat in scanning order.
+	k := sort.Search(n, func(i int) bool {
+		if descending {
+			return lats[i] < lat
+		}
+		return lats[i] > lat
+	})
+	if k == 0 {
+		return 0, true
+	}
+	if k == n {
+		return float64(n - 1), true
+	}
+
+	return float64(k-1) + (lat-lats[k-1])/(lats[k]-lats[k-1]), true
+}
+
+// isGlobal reports whether a grid with the given row length spans all
+// longitudes, so that its last point is followed by its first one.
+func isGlobal(lo1, lo2 float64, points int) bool {
+	if points < 2 {
+		return false
+	}
+	span := lo2 - lo1
+	for span < 0 {
+		span += 360
+	}
+	spacing := span / float64(points-1)
+	return math.Abs(span+spacing-360) < spacing/2
+}
+
+// rowSpacing returns the longitude of the first point and the spacing of
+// row j of a reduced Gaussian grid, and whether the row is global. A row
+// without points has no spacing.
+func (h GribHeader) rowSpacing(j int) (float64, float64, bool) {
+	lo1 := h.Lo1
+	if lo1 > 180 {
+		lo1 -= 360
+	}
+
+	// Nx holds the length of the longest row.
+	points := h.PL[j]
+	if points == 0 {
+		return lo1, 0, false
+	}
+	if isGlobal(h.Lo1, h.Lo2, h.Nx) {
+		return lo1, 360 / float64(points), true
+	}
+	if points < 2 {
+		return lo1, 0, false
+	}
+
+	span := h.Lo2 - h.Lo1
+	for span < 0 {
+		span += 360
+	}
+	return lo1, span / float64(points-1), false
+}
+
+// reducedColumn returns the fractional column of a longitude in row j of a
+// reduced Gaussian grid, and whether it lies within the row.
+func (h GribHeader) reducedColumn(j int, lng float64) (float64, bool) {
+	lo1, dx, global := h.rowSpacing(j)
+	if dx == 0 {
+		return 0, h.PL[j] == 1
+	}
+
+	x := math.Mod(lng-lo1, 360)
+	if x < 0 {
+		x += 360
+	}
+	x /= dx
+
+	if !global && x > float64(h.PL[j]-1) {
+		return 0, false
+	}
+	return x, true
+}
+
+// reducedValue returns the value at column i of row j of a reduced
+// Gaussian grid, wrapping the columns of global rows. Rows without points
+// have no values.
+func (g GRIBFile) reducedValue(info *gridInfo, j, i int) (float64, bool) {
+	points := g.Header.PL[j]
+	if points == 0 {
+		return 0, false
+	}
+	if i >= points || i < 0 {
+		if _, _, global := g.Header.rowSpacing(j); !global {
+			return 0, false
+		}
+		i = ((i % points) + points) % points
+	}
+
+	v := g.DataValues[info.rowStart[j]+i]
+	return v, v != g.Header.MissingValue
+}
+
+// reducedRowValue interpolates linearly along row j of a reduced Gaussian grid.
+func (g GRIBFile) reducedRowValue(info *gridInfo, j int, lng float64) (float64, bool) {
+	x, ok := g.Header.reducedColumn(j, lng)
+	if !ok {
+		return 0, false
+	}
+
+	i := int(math.Floor(x))
+	u := x - float64(i)
+
+	v0, ok0 := g.reducedValue(info, j, i)
+	v1, ok1 := g.reducedValue(info, j, i+1)
+	switch {
+	case ok0 && ok1:
+		return v0*(1-u) + v1*u, true
+	case ok0 && u < 0.5:
+		return v0, true
+	case ok1 && u >= 0.5:
+		return v1, true
+	}
+	return 0, false
+}
+
+// interpolateReduced interpolates a reduced Gaussian grid linearly along
+// the two rows around lat, each with its own longitude spacing, and then
+// linearly between them.
+func (g GRIBFile) interpolateReduced(lat, lng float64) float64 {
+	h := g.Header
+	info := g.rows()
+
+	y, ok := info.gaussianRow(lat)
+	if !ok {
+		return h.MissingValue
+	}
+
+	j := int(math.Floor(y))
+	v := y - float64(j)
+
+	v0, ok0 := g.reducedRowValue(info, j, lng)
+	if j+1 >= h.Ny || v == 0 {
+		if ok0 {
+			return v0
+		}
+		return h.MissingValue
+	}
+	v1, ok1 := g.reducedRowValue(info, j+1, lng)
+
+	switch {
+	case ok0 && ok1:
+		return v0*(1-v) + v1*v
+	case ok0:
+		return v0
+	case ok1:
+		return v1
+	}
+	return h.MissingValue
+}
+
+// reducedNearest returns the value of the grid point of a reduced Gaussian
+// grid nearest to a point.
+func (g GRIBFile) reducedNearest(lat, lng float64) float64 {
+	h := g.Header
+	info := g.rows()
+
+	y, ok := info.gaussianRow(lat)
+	if !ok {
+		return h.MissingValue
+	}
+	j := int(math.Round(y))
+
+	x, ok := h.reducedColumn(j, lng)
+	if !ok {
+		return h.MissingValue
+	}
+
+	v, ok := g.reducedValue(info, j, int(math.Round(x)))
+	if !ok {
+		return h.MissingValue
+	}
+	return v
+}
@@ -0,0 +1,39 @@
+package parser
+
+import "testing"
+
+func TestReducedGridEmptyRow(t *testing.T) {
+	h := GribHeader{
+		GridType:     GridReducedGaussian,
+		N:            1,
+		Nx:           4,
+		Ny:           2,
+		La1:          35.26,
+		La2:          -35.26,
+		Lo1:          0,
+		Lo2:          270,
+		PL:           []int{4, 0},
+		MissingValue: defaultMissingValue,
+	}
+	g := newGRIBFile(h, []float64{1, 2, 3, 4})
+	lats := g.rows().latitudes
+
+	tests := []struct {
+		lat, lng float64
+		want     float64
+	}{
+		{lats[0], 90, 2},
+		{lats[0], 135, 2.5},
+		{lats[1], 90, defaultMissingValue},
+		{0, 90, 2},
+	}
+	for _, tt := range tests {
+		if got := g.interpolateReduced(tt.lat, tt.lng); got != tt.want {
+			t.Errorf("interpolateReduced(%g, %g) = %g, want %g", tt.lat, tt.lng, got, tt.want)
+		}
+	}
+
+	if got := g.reducedNearest(lats[1], 90); got != defaultMissingValue {
+		t.Errorf("reducedNearest on the empty row = %g, want missing", got)
+	}
+}
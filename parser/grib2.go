@@ -1,17 +1,11 @@
 package parser
 
-/*
-#cgo CFLAGS: -I/usr/include/x86_64-linux-gnu/
-#cgo LDFLAGS: -leccodes
-#include "eccodes.h"
-#include <stdlib.h>
-*/
-import "C"
 import (
+	"errors"
 	"fmt"
 	"math"
+	"os"
 	"time"
-	"unsafe"
 )
 
 type GribHeader struct {
@@ -25,9 +19,34 @@ type GribHeader struct {
 	DX                 float64   `json:"dx"`
 	DY                 float64   `json:"dy"`
 	ScanMode           int       `json:"scanMode"`
+	GridType           string    `json:"gridType"`
+	LaD                float64   `json:"laD,omitempty"`
+	LoV                float64   `json:"loV,omitempty"`
+	Latin1             float64   `json:"latin1,omitempty"`
+	Latin2             float64   `json:"latin2,omitempty"`
+	SouthPoleLat       float64   `json:"southPoleLat,omitempty"`
+	SouthPoleLon       float64   `json:"southPoleLon,omitempty"`
+	RotationAngle      float64   `json:"rotationAngle,omitempty"`
+	N                  int       `json:"n,omitempty"`
+	PL                 []int     `json:"-"`
+	GridNumber         int       `json:"numberOfGridUsed,omitempty"`
+	GridReference      int       `json:"numberOfGridInReference,omitempty"`
+	GridUUID           string    `json:"uuidOfHGrid,omitempty"`
+	ProjectionCentre   int       `json:"projectionCentre,omitempty"`
+	EarthMajorAxis     float64   `json:"earthMajorAxis"`
+	EarthMinorAxis     float64   `json:"earthMinorAxis"`
 	Discipline         int       `json:"discipline"`
 	ParameterCategory  int       `json:"parameterCategory"`
 	ParameterNumber    int       `json:"parameterNumber"`
+	ShortName          string    `json:"shortName"`
+	TypeOfLevel        string    `json:"typeOfLevel"`
+	Level              int       `json:"level"`
+	DataTime           time.Time `json:"dataTime"`      // reference time of the field, the ecCodes dataDate and dataTime
+	ValidTime          time.Time `json:"validTime"`     // DataTime plus ForecastTime, zero if the time unit is missing or unsupported
+	// ReferenceTime is the valid time of the field, despite its name. It
+	// keeps the meaning it had before DataTime and ValidTime were added.
+	//
+	// Deprecated: use ValidTime, or DataTime for the reference time.
 	ReferenceTime      time.Time `json:"referenceTime"`
 	ForecastTime       int       `json:"forecastTime"`
 	EndStep            int       `json:"endStep"`
@@ -37,6 +56,15 @@ type GribHeader struct {
 type GRIBFile struct {
 	Header     GribHeader
 	DataValues []float64
+	Err        error // *MessageError if the message could not be decoded, Header and DataValues are empty then
+
+	grid  *gridInfo
+	cells *UnstructuredGrid
+}
+
+// newGRIBFile returns a decoded message, precomputing the row layout of its grid.
+func newGRIBFile(header GribHeader, dataValues []float64) GRIBFile {
+	return GRIBFile{Header: header, DataValues: dataValues, grid: newGridInfo(header)}
 }
 
 func (g GRIBFile) GetLatLng(x, y int) (float64, float64) {
@@ -44,302 +72,110 @@ func (g GRIBFile) GetLatLng(x, y int) (float64, float64) {
 		return 9999, 9999
 	}
 
-	lo1 := g.Header.Lo1
-	if lo1 > 180 {
-		lo1 -= 360
-	}
-
-	lat := g.Header.La1 + float64(y)*g.Header.DY
-	lng := lo1 + float64(x)*g.Header.DX
-
-	return lat, lng
-}
-
-func (g GRIBFile) GetData(lat, lng float64) float64 {
-	lo1 := g.Header.Lo1
-	if lo1 > 180 {
-		lo1 -= 360
+	if p := g.Header.projection(); p != nil {
+		return projectedLatLng(g.Header, p, float64(x), float64(y))
 	}
 
-	x := int((lng - lo1) / g.Header.DX)
-	y := int((lat - g.Header.La1) / g.Header.DY)
-
-	if x < 0 || x >= g.Header.Nx || y < 0 || y >= g.Header.Ny {
-		return g.Header.MissingValue
+	if g.Header.GridType == GridUnstructured {
+		if g.cells == nil {
+			return 9999, 9999
+		}
+		return g.cells.lats[x], g.cells.lons[x]
 	}
 
-	return g.DataValues[y*g.Header.Nx+x]
-}
-
-func (g GRIBFile) GetInterpolatedData(lat, lng float64) float64 {
-	la1 := g.Header.La1
 	lo1 := g.Header.Lo1
-	la2 := g.Header.La2
-	lo2 := g.Header.Lo2
-	dx := g.Header.DX
-	dy := g.Header.DY
-	width := g.Header.Nx
-	height := g.Header.Ny
-	missingValue := g.Header.MissingValue
-	data := g.DataValues
-
 	if lo1 > 180 {
 		lo1 -= 360
 	}
-	if lng > 180 {
-		lng -= 360
-	} else if lng < -180 {
-		lng += 360
-	}
-	
-	absDy := math.Abs(dy)
-	
-	minLat := math.Min(la1, la2)
-	maxLat := math.Max(la1, la2)
-	minLon := math.Min(lo1, lo2)
-	maxLon := math.Max(lo1, lo2)
-
-	if lat < minLat || lat > maxLat || lng < minLon || lng > maxLon {
-		return missingValue
-	}
-
-	// Calculate fractional grid coordinates directly from lat/lon
-	var x, y float64
-	if la1 < la2 {
-		// Ascending latitudes
-		y = (lat - la1) / absDy
-	} else {
-		// Descending latitudes
-		y = (la1 - lat) / absDy
-	}
-	x = (lng - lo1) / dx
-
-	if x < 0 {
-		x = 0
-	}
-	if x >= float64(width-1) {
-		x = float64(width) - 1.001 // Slightly less than width to ensure valid index
-	}
-	if y < 0 {
-		y = 0
-	}
-	if y >= float64(height-1) {
-		y = float64(height) - 1.001 // Slightly less than height to ensure valid index
-	}
-
-	x0 := int(math.Floor(x))
-	y0 := int(math.Floor(y))
-
-	u := x - float64(x0)
-	v := y - float64(y0)
-
-	edgeMargin := 3
-
-	if x0 < edgeMargin || x0 >= width-(edgeMargin+1) || y0 < edgeMargin || y0 >= height-(edgeMargin+1) {
-		return adaptiveInterpolation(data, x, y, x0, y0, width, height, missingValue, edgeMargin)
-	}
-
-	// Calculate bicubic weights
-	u2 := u * u
-	u3 := u2 * u
-	v2 := v * v
-	v3 := v2 * v
-
-	wx0 := (-0.5 * u3) + (u2) - (0.5 * u)
-	wx1 := (1.5 * u3) - (2.5 * u2) + 1
-	wx2 := (-1.5 * u3) + (2.0 * u2) + (0.5 * u)
-	wx3 := (0.5 * u3) - (0.5 * u2)
-	wy0 := (-0.5 * v3) + (v2) - (0.5 * v)
-	wy1 := (1.5 * v3) - (2.5 * v2) + 1
-	wy2 := (-1.5 * v3) + (2.0 * v2) + (0.5 * v)
-	wy3 := (0.5 * v3) - (0.5 * v2)
-
-	values := make([]float64, 16)
-	missingMask := make([]bool, 16)
-
-	indices := [16][2]int{
-		{y0 - 1, x0 - 1}, {y0 - 1, x0}, {y0 - 1, x0 + 1}, {y0 - 1, x0 + 2},
-		{y0, x0 - 1}, {y0, x0}, {y0, x0 + 1}, {y0, x0 + 2},
-		{y0 + 1, x0 - 1}, {y0 + 1, x0}, {y0 + 1, x0 + 1}, {y0 + 1, x0 + 2},
-		{y0 + 2, x0 - 1}, {y0 + 2, x0}, {y0 + 2, x0 + 1}, {y0 + 2, x0 + 2},
-	}
 
-	hasMissing := false
-	for i, idx := range indices {
-		row, col := idx[0], idx[1]
-		values[i] = data[row*width+col]
-		missingMask[i] = (values[i] == missingValue)
-		if missingMask[i] {
-			hasMissing = true
+	switch g.Header.GridType {
+	case GridRegularGaussian:
+		lats := g.rows().latitudes
+		if y >= len(lats) {
+			return 9999, 9999
 		}
+		return lats[y], lo1 + float64(x)*g.Header.DX
+	case GridReducedGaussian:
+		lats := g.rows().latitudes
+		if y >= len(lats) || x >= g.Header.PL[y] {
+			return 9999, 9999
+		}
+		rowLo1, dx, _ := g.Header.rowSpacing(y)
+		return lats[y], rowLo1 + float64(x)*dx
 	}
 
-	if hasMissing {
-		return adaptiveInterpolation(data, x, y, x0, y0, width, height, missingValue, edgeMargin)
-	}
-
-	weights := []float64{
-		wx0 * wy0, wx1 * wy0, wx2 * wy0, wx3 * wy0,
-		wx0 * wy1, wx1 * wy1, wx2 * wy1, wx3 * wy1,
-		wx0 * wy2, wx1 * wy2, wx2 * wy2, wx3 * wy2,
-		wx0 * wy3, wx1 * wy3, wx2 * wy3, wx3 * wy3,
+	dy := math.Abs(g.Header.DY)
+	if g.Header.La1 > g.Header.La2 {
+		dy = -dy
 	}
+	lat := g.Header.La1 + float64(y)*dy
+	lng := lo1 + float64(x)*g.Header.DX
 
-	result := 0.0
-	for i := 0; i < 16; i++ {
-		result += values[i] * weights[i]
+	if g.Header.GridType == GridRotatedLatLon {
+		return newRotatedPole(g.Header).unrotate(lat, lng)
 	}
-
-	return result
+	return lat, lng
 }
 
-func adaptiveInterpolation(data []float64, x, y float64, x0, y0, width, height int, missingValue float64, edgeMargin int) float64 {
-	maxLeft := min(x0, edgeMargin)
-	maxRight := min(width-1-x0, edgeMargin)
-	maxTop := min(y0, edgeMargin)
-	maxBottom := min(height-1-y0, edgeMargin)
-
-
-	if maxLeft >= 1 && maxRight >= 2 && maxTop >= 1 && maxBottom >= 2 {
-		if maxTop >= 1 && maxBottom >= 1 && maxLeft >= 1 && maxRight >= 1 {
-			result, valid := tryBilinearInterpolation(data, x, y, x0, y0, width, height, missingValue)
-			if valid {
-				return result
-			}
+func (g GRIBFile) GetData(lat, lng float64) float64 {
+	var x, y int
+	if g.Header.GridType == GridUnstructured {
+		return g.unstructuredNearest(lat, lng)
+	} else if g.Header.GridType == GridReducedGaussian {
+		return g.reducedNearest(lat, lng)
+	} else if p := g.Header.projection(); p != nil {
+		fx, fy := projectedIndex(g.Header, p, lat, lng)
+		x, y = int(math.Round(fx)), int(math.Round(fy))
+	} else {
+		fx, fy, inside := g.gridCoordinates(lat, lng)
+		if !inside {
+			return g.Header.MissingValue
 		}
-	}
-
-	return gradientAdaptiveInterpolation(data, x, y, x0, y0, width, height, missingValue)
-}
-
-func tryBilinearInterpolation(data []float64, x, y float64, x0, y0, width, height int, missingValue float64) (float64, bool) {
-	getPixel := func(row, col int) (float64, bool) {
-		if row < 0 || row >= height || col < 0 || col >= width {
-			return missingValue, false
+		x, y = int(math.Round(fx)), int(math.Round(fy))
+		if g.Header.wrapsLongitude() {
+			x %= g.Header.Nx
 		}
-		val := data[row*width+col]
-		return val, val != missingValue
-	}
-
-	u := x - float64(x0)
-	v := y - float64(y0)
-
-	v00, valid00 := getPixel(y0, x0)
-	v01, valid01 := getPixel(y0, x0+1)
-	v10, valid10 := getPixel(y0+1, x0)
-	v11, valid11 := getPixel(y0+1, x0+1)
-
-	validCount := 0
-	if valid00 {
-		validCount++
-	}
-	if valid01 {
-		validCount++
-	}
-	if valid10 {
-		validCount++
-	}
-	if valid11 {
-		validCount++
-	}
-
-	if validCount < 2 {
-		return 0, false
 	}
 
-	if validCount == 4 {
-		return v00*(1-u)*(1-v) + v01*u*(1-v) + v10*(1-u)*v + v11*u*v, true
-	}
-
-	totalWeight := 0.0
-	result := 0.0
-
-	if valid00 {
-		weight := (1 - u) * (1 - v)
-		result += v00 * weight
-		totalWeight += weight
-	}
-	if valid01 {
-		weight := u * (1 - v)
-		result += v01 * weight
-		totalWeight += weight
-	}
-	if valid10 {
-		weight := (1 - u) * v
-		result += v10 * weight
-		totalWeight += weight
-	}
-	if valid11 {
-		weight := u * v
-		result += v11 * weight
-		totalWeight += weight
-	}
-
-	if totalWeight > 0 {
-		return result / totalWeight, true
+	if x < 0 || x >= g.Header.Nx || y < 0 || y >= g.Header.Ny {
+		return g.Header.MissingValue
 	}
 
-	return 0, false
+	return g.DataValues[y*g.Header.Nx+x]
 }
 
-func gradientAdaptiveInterpolation(data []float64, x, y float64, x0, y0, width, height int, missingValue float64) float64 {
-	radius := 3.0
-
-	type DataPoint struct {
-		value    float64
-		distance float64
-	}
-
-	var validPoints []DataPoint
-
-	for dy := -int(radius); dy <= int(radius); dy++ {
-		for dx := -int(radius); dx <= int(radius); dx++ {
-			nx := x0 + dx
-			ny := y0 + dy
-
-			if nx < 0 || nx >= width || ny < 0 || ny >= height {
-				continue
-			}
+// GetInterpolatedData returns the value of the field at a point, interpolated
+// bicubically from the surrounding grid points.
+func (g GRIBFile) GetInterpolatedData(lat, lng float64) float64 {
+	return g.Interpolate(lat, lng, Bicubic)
+}
 
-			val := data[ny*width+nx]
-			if val != missingValue {
-				px := float64(nx) + 0.5
-				py := float64(ny) + 0.5
-				dist := math.Sqrt((px-x)*(px-x) + (py-y)*(py-y))
-
-				if dist <= radius {
-					validPoints = append(validPoints, DataPoint{
-						value:    val,
-						distance: dist,
-					})
-				}
-			}
+// Interpolate returns the value of the field at a point, estimated by interp
+// from the surrounding grid points. Reduced Gaussian and unstructured grids
+// have their own linear interpolation, which is used by every interpolator
+// except Nearest.
+func (g GRIBFile) Interpolate(lat, lng float64, interp Interpolator) float64 {
+	_, nearest := interp.(nearestInterpolator)
+	switch g.Header.GridType {
+	case GridReducedGaussian:
+		if nearest {
+			return g.reducedNearest(lat, lng)
 		}
+		return g.interpolateReduced(lat, lng)
+	case GridUnstructured:
+		if nearest {
+			return g.unstructuredNearest(lat, lng)
+		}
+		return g.interpolateUnstructured(lat, lng)
 	}
 
-	if len(validPoints) == 0 {
-		return missingValue
-	}
-
-	if len(validPoints) == 1 {
-		return validPoints[0].value
-	}
-
-	var totalWeight float64
-	var weightedSum float64
-
-	for _, point := range validPoints {
-		weight := 1.0 / (point.distance*point.distance + 0.001)
-
-		weightedSum += point.value * weight
-		totalWeight += weight
-	}
-
-	if totalWeight > 0 {
-		return weightedSum / totalWeight
+	// Calculate fractional grid coordinates from lat/lon
+	x, y, inside := g.gridCoordinates(lat, lng)
+	if !inside {
+		return g.Header.MissingValue
 	}
 
-	return missingValue
+	return interp.Interpolate(g.values(), x, y)
 }
 
 func min(a, b int) int {
@@ -349,164 +185,151 @@ func min(a, b int) int {
 	return b
 }
 
-func ProcessGRIB(gribData []byte) GRIBFile {
-	dataPtr := unsafe.Pointer(&gribData[0])
-	dataSize := C.size_t(len(gribData))
-
-	var gid *C.codes_handle = C.codes_handle_new_from_message(C.codes_context_get_default(), dataPtr, dataSize)
-	if gid == nil {
-		fmt.Println("Failed to create handle from message")
-		return GRIBFile{}
-	}
-	defer C.codes_handle_delete(gid)
-
-	// Extract grid information
-	var nx, ny C.long
-	var la1, la2, lo1, lo2, dx, dy, basicAngle, subdivisions C.double
-	var values *C.double
-	var numValues C.size_t
-	var year, month, day, hour, minute, second, timeUnit, forecastTime, scanMode, endStep C.long
+// forecastDuration converts a forecast time expressed in the given unit
+// (code table 4.4) into a duration. It reports false if the unit is missing
+// or not supported, in which case the step of the field is unknown.
+func forecastDuration(timeUnit, forecastTime int) (time.Duration, bool) {
+	var duration time.Duration
 
-	var discipline, parameterCategory, parameterNumber C.long
-
-	var missingValue C.double
-
-	C.codes_get_long(gid, C.CString("Ni"), &nx)
-	C.codes_get_long(gid, C.CString("Nj"), &ny)
-	C.codes_get_double(gid, C.CString("latitudeOfFirstGridPointInDegrees"), &la1)
-	C.codes_get_double(gid, C.CString("latitudeOfLastGridPointInDegrees"), &la2)
-	C.codes_get_double(gid, C.CString("longitudeOfFirstGridPointInDegrees"), &lo1)
-	C.codes_get_double(gid, C.CString("longitudeOfLastGridPointInDegrees"), &lo2)
-	C.codes_get_double(gid, C.CString("iDirectionIncrement"), &dx)
-	C.codes_get_double(gid, C.CString("jDirectionIncrement"), &dy)
-	C.codes_get_double(gid, C.CString("basicAngleOfTheInitialProductionDomain"), &basicAngle)
-	C.codes_get_double(gid, C.CString("subdivisionsOfBasicAngle"), &subdivisions)
-
-	C.codes_get_double(gid, C.CString("missingValue"), &missingValue)
-
-	scale := 1e6 // Default scale if no basicAngle is defined
-
-	if basicAngle != 0 {
-		scale = float64(basicAngle) / float64(subdivisions)
-	}
-
-	// Extract reference time
-	C.codes_get_long(gid, C.CString("year"), &year)
-	C.codes_get_long(gid, C.CString("month"), &month)
-	C.codes_get_long(gid, C.CString("day"), &day)
-	C.codes_get_long(gid, C.CString("hour"), &hour)
-	C.codes_get_long(gid, C.CString("minute"), &minute)
-	C.codes_get_long(gid, C.CString("second"), &second)
-	C.codes_get_long(gid, C.CString("indicatorOfUnitOfTimeRange"), &timeUnit)
-	C.codes_get_long(gid, C.CString("forecastTime"), &forecastTime)
-	C.codes_get_long(gid, C.CString("endStep"), &endStep)
-	C.codes_get_long(gid, C.CString("scanMode"), &scanMode)
-	C.codes_get_long(gid, C.CString("discipline"), &discipline)
-	C.codes_get_long(gid, C.CString("parameterCategory"), &parameterCategory)
-	C.codes_get_long(gid, C.CString("parameterNumber"), &parameterNumber)
-
-	gribType := int32((discipline & 0xFF) | ((parameterCategory & 0xFF) << 8) | ((parameterNumber & 0xFF) << 16))
-
-	referenceTime := time.Date(int(year), time.Month(month), int(day), int(hour), int(minute), int(second), 0, time.UTC)
-	var forecastDuration time.Duration
-
-	// Adjust reference time by forecast period
 	// https://codes.ecmwf.int/grib/format/grib2/ctables/4/4/
 	switch timeUnit {
 	case 0: // Minute
-		forecastDuration = time.Duration(forecastTime) * time.Minute
+		duration = time.Duration(forecastTime) * time.Minute
 	case 1: // Hour
-		forecastDuration = time.Duration(forecastTime) * time.Hour
+		duration = time.Duration(forecastTime) * time.Hour
 	case 2: // Day
-		forecastDuration = time.Duration(forecastTime) * 24 * time.Hour
+		duration = time.Duration(forecastTime) * 24 * time.Hour
 	case 3: // Month
-		forecastDuration = time.Duration(forecastTime) * 24 * time.Hour * 30
+		duration = time.Duration(forecastTime) * 24 * time.Hour * 30
 	case 4: // Year
-		forecastDuration = time.Duration(forecastTime) * 24 * time.Hour * 365
+		duration = time.Duration(forecastTime) * 24 * time.Hour * 365
 	case 5: // Decade (10 years)
-		forecastDuration = time.Duration(forecastTime) * 24 * time.Hour * 365 * 10
+		duration = time.Duration(forecastTime) * 24 * time.Hour * 365 * 10
 	case 6: // Normal (30 years)
-		forecastDuration = time.Duration(forecastTime) * 24 * time.Hour * 365 * 30
+		duration = time.Duration(forecastTime) * 24 * time.Hour * 365 * 30
 	case 7: // Century (100 years)
-		forecastDuration = time.Duration(forecastTime) * 24 * time.Hour * 365 * 100
+		duration = time.Duration(forecastTime) * 24 * time.Hour * 365 * 100
 	case 10: // 3 hours
-		forecastDuration = time.Duration(forecastTime) * 3 * time.Hour
+		duration = time.Duration(forecastTime) * 3 * time.Hour
 	case 11: // 6 hours
-		forecastDuration = time.Duration(forecastTime) * 6 * time.Hour
+		duration = time.Duration(forecastTime) * 6 * time.Hour
 	case 12: // 12 hours
-		forecastDuration = time.Duration(forecastTime) * 12 * time.Hour
+		duration = time.Duration(forecastTime) * 12 * time.Hour
 	case 13: // Second
-		forecastDuration = time.Duration(forecastTime) * time.Second
-	case 255: // Missing
-		fmt.Println("Forecast time is missing.")
-	default:
-		fmt.Printf("Unsupported time unit: %d\n", timeUnit)
+		duration = time.Duration(forecastTime) * time.Second
+	default: // 255 is missing
+		return 0, false
 	}
 
-	forecastReferenceTime := referenceTime.Add(forecastDuration)
+	return duration, true
+}
 
-	// Getting the values
-	if C.codes_get_size(gid, C.CString("values"), &numValues) == C.CODES_SUCCESS {
-		values = (*C.double)(C.malloc(numValues * C.sizeof_double))
-		defer C.free(unsafe.Pointer(values))
+// CorrectRowOffset shifts the values of every even row by half the i
+// increment. This is not part of the GRIB specification, it corrects a
+// dataset whose rows were written misaligned, and corrupts any other grid.
+// It works on decoded messages, whose values are in scanning mode 0: rows
+// are counted from the north, starting with row 0, whatever the scanning
+// mode of the file.
+func CorrectRowOffset(parsedGrib *GRIBFile) {
+	if len(parsedGrib.Header.PL) > 0 {
+		return
+	}
 
-		if C.codes_get_double_array(gid, C.CString("values"), values, &numValues) == C.CODES_SUCCESS {
-			dataValues := make([]float64, numValues)
-			for i := C.size_t(0); i < numValues; i++ {
-				dataValues[i] = float64(*(*C.double)(unsafe.Pointer(uintptr(unsafe.Pointer(values)) + uintptr(i)*uintptr(C.sizeof_double))))
-			}
+	var correctedDataValues []float64
 
-			la1 := float64(la1)
-			la2 := float64(la2)
-			lo1 := float64(lo1)
-			lo2 := float64(lo2)
-
-			dx := float64(dx) / scale
-			dy := float64(dy) / scale
-
-			parsedGrib := GRIBFile{
-				Header: GribHeader{
-					Type:               gribType,
-					Nx:                 int(nx),
-					Ny:                 int(ny),
-					La1:                la1,
-					La2:                la2,
-					Lo1:                lo1,
-					Lo2:                lo2,
-					DX:                 dx,
-					DY:                 dy,
-					ScanMode:           int(scanMode),
-					Discipline:         int(discipline),
-					ParameterCategory:  int(parameterCategory),
-					ParameterNumber:    int(parameterNumber),
-					ReferenceTime:      forecastReferenceTime,
-					ForecastTime:       int(forecastTime),
-					EndStep:            int(endStep),
-					MissingValue:       float64(missingValue),
-				},
-				DataValues: dataValues,
+	offset := int(parsedGrib.Header.DX / 2)
+
+	for y := 0; y < parsedGrib.Header.Ny; y++ {
+		for x := 0; x < parsedGrib.Header.Nx; x++ {
+			index := y*parsedGrib.Header.Nx + x
+			if y%2 == 0 && (index+offset) < len(parsedGrib.DataValues) {
+				correctedDataValues = append(correctedDataValues, parsedGrib.DataValues[index+offset])
+			} else {
+				correctedDataValues = append(correctedDataValues, parsedGrib.DataValues[index])
 			}
+		}
+	}
 
-			var correctedDataValues []float64
+	parsedGrib.DataValues = correctedDataValues
+}
 
-			offset := int(parsedGrib.Header.DX / 2)
+// checkGrid verifies that a decoded message holds one value per grid point.
+func (g GRIBFile) checkGrid() error {
+	if g.Header.Nx <= 0 || g.Header.Ny <= 0 {
+		return &DecodeError{Err: fmt.Errorf("empty grid of %dx%d points", g.Header.Nx, g.Header.Ny)}
+	}
+	if len(g.Header.PL) > 0 {
+		if len(g.Header.PL) != g.Header.Ny {
+			return &DecodeError{Err: fmt.Errorf("%d row lengths for a grid of %d rows", len(g.Header.PL), g.Header.Ny)}
+		}
+		points := 0
+		for _, n := range g.Header.PL {
+			points += n
+		}
+		if len(g.DataValues) != points {
+			return &DecodeError{Err: fmt.Errorf("%d values for a reduced grid of %d points", len(g.DataValues), points)}
+		}
+		return nil
+	}
+	if len(g.DataValues) != g.Header.Nx*g.Header.Ny {
+		return &DecodeError{Err: fmt.Errorf("%d values for a grid of %dx%d points", len(g.DataValues), g.Header.Nx, g.Header.Ny)}
+	}
+	return nil
+}
 
-			for y := 0; y < parsedGrib.Header.Ny; y++ {
-				for x := 0; x < parsedGrib.Header.Nx; x++ {
-					index := y*parsedGrib.Header.Nx + x
-					if y%2 == 0 && (index+offset) < len(parsedGrib.DataValues) {
-						correctedDataValues = append(correctedDataValues, parsedGrib.DataValues[index+offset])
-					} else {
-						correctedDataValues = append(correctedDataValues, parsedGrib.DataValues[index])
-					}
-				}
+// ProcessGRIBMessages decodes every message of a GRIB file. The fields of a
+// message that repeats sections are returned one by one and numbered like
+// messages, as ecCodes does. A message that cannot be decoded is returned
+// with a *MessageError in its Err field, so the other messages remain
+// usable. It fails only if no message can be decoded.
+func ProcessGRIBMessages(gribData []byte) ([]GRIBFile, error) {
+	messages := SplitMessages(gribData)
+	if len(messages) == 0 {
+		return nil, &DecodeError{Err: errors.New("no GRIB messages found")}
+	}
+
+	gribFiles := make([]GRIBFile, 0, len(messages))
+	var firstErr error
+	fail := func(err error) {
+		gribFile := GRIBFile{Err: &MessageError{Message: len(gribFiles) + 1, Err: err}}
+		if firstErr == nil {
+			firstErr = gribFile.Err
+		}
+		gribFiles = append(gribFiles, gribFile)
+	}
+	for _, message := range messages {
+		fields, err := splitFields(message)
+		if err != nil {
+			fail(&DecodeError{Err: err})
+			continue
+		}
+		for _, field := range fields {
+			gribFile, err := ProcessGRIB(field)
+			if err != nil {
+				fail(err)
+				continue
 			}
-
-			parsedGrib.DataValues = correctedDataValues
-
-			return parsedGrib
+			gribFiles = append(gribFiles, gribFile)
+		}
+	}
+	for _, g := range gribFiles {
+		if g.Err == nil {
+			return gribFiles, nil
 		}
 	}
+	return nil, firstErr
+}
+
+// ProcessGRIBFile reads and decodes every message of the named GRIB file.
+func ProcessGRIBFile(filename string) ([]GRIBFile, error) {
+	gribData, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
 
-	return GRIBFile{}
+	gribFiles, err := ProcessGRIBMessages(gribData)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return gribFiles, nil
 }
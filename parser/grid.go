@@ -0,0 +1,189 @@
+package parser
+
+import "math"
+
+// Grid types, named after the ecCodes gridType key.
+const (
+	GridRegularLatLon      = "regular_ll"
+	GridLambert            = "lambert"
+	GridPolarStereographic = "polar_stereographic"
+	GridRotatedLatLon      = "rotated_ll"
+	GridRegularGaussian    = "regular_gg"
+	GridReducedGaussian    = "reduced_gg"
+	GridUnstructured       = "unstructured_grid"
+)
+
+// projection returns the map projection of a projected grid, or nil for
+// grids defined on latitude and longitude.
+func (h GribHeader) projection() gridProjection {
+	switch h.GridType {
+	case GridLambert:
+		return newLambertConformal(h)
+	case GridPolarStereographic:
+		return newPolarStereographic(h)
+	}
+	return nil
+}
+
+// gridCoordinates returns the fractional column and row of a point in the
+// grid, and whether the point lies within the grid.
+func (g GRIBFile) gridCoordinates(lat, lng float64) (float64, float64, bool) {
+	h := g.Header
+
+	if p := h.projection(); p != nil {
+		x, y := projectedIndex(h, p, lat, lng)
+		inside := x >= 0 && x <= float64(h.Nx-1) && y >= 0 && y <= float64(h.Ny-1)
+		return x, y, inside
+	}
+
+	if h.GridType == GridRotatedLatLon {
+		lat, lng = newRotatedPole(h).rotate(lat, lng)
+	}
+
+	la1 := h.La1
+	lo1 := h.Lo1
+	la2 := h.La2
+	lo2 := h.Lo2
+
+	if lo1 > 180 {
+		lo1 -= 360
+	}
+	if lng > 180 {
+		lng -= 360
+	} else if lng < -180 {
+		lng += 360
+	}
+
+	// Measure the longitude east of the first column, so that grids crossing
+	// the antimeridian are contiguous. Points past the last column of a
+	// global grid lie between the last and the first column.
+	x := math.Mod(lng-lo1, 360)
+	if x < 0 {
+		x += 360
+	}
+	span := math.Mod(lo2-lo1, 360)
+	if span < 0 {
+		span += 360
+	}
+	if x > span && !h.wrapsLongitude() {
+		return 0, 0, false
+	}
+	x /= h.DX
+
+	if h.GridType == GridRegularGaussian {
+		y, inside := g.rows().gaussianRow(lat)
+		return x, y, inside
+	}
+
+	absDy := math.Abs(h.DY)
+
+	minLat := math.Min(la1, la2)
+	maxLat := math.Max(la1, la2)
+
+	if lat < minLat || lat > maxLat {
+		return 0, 0, false
+	}
+
+	var y float64
+	if la1 < la2 {
+		// Ascending latitudes
+		y = (lat - la1) / absDy
+	} else {
+		// Descending latitudes
+		y = (la1 - lat) / absDy
+	}
+
+	return x, y, true
+}
+
+// wrapsLongitude reports whether the rows of a grid cover the full circle of
+// longitudes, so that the first column follows the last one.
+func (h GribHeader) wrapsLongitude() bool {
+	switch h.GridType {
+	case GridRegularLatLon, GridRotatedLatLon, GridRegularGaussian:
+		return isGlobal(h.Lo1, h.Lo2, h.Nx)
+	}
+	return false
+}
+
+// projectedIndex returns the fractional column and row of a point of a
+// projected grid, following the i and j scanning directions.
+func projectedIndex(h GribHeader, p gridProjection, lat, lng float64) (float64, float64) {
+	x0, y0 := p.forward(h.La1, h.Lo1)
+	px, py := p.forward(lat, lng)
+
+	x := (px - x0) / h.DX
+	y := (py - y0) / h.DY
+	if h.ScanMode&0x80 != 0 {
+		x = -x
+	}
+	if h.ScanMode&0x40 == 0 {
+		y = -y
+	}
+	return x, y
+}
+
+// projectedLatLng is the inverse of projectedIndex.
+func projectedLatLng(h GribHeader, p gridProjection, x, y float64) (float64, float64) {
+	if h.ScanMode&0x80 != 0 {
+		x = -x
+	}
+	if h.ScanMode&0x40 == 0 {
+		y = -y
+	}
+
+	x0, y0 := p.forward(h.La1, h.Lo1)
+	return p.inverse(x0+x*h.DX, y0+y*h.DY)
+}
+
+// Bounds returns the latitude and longitude extent of the grid as
+// minLat, minLon, maxLat, maxLon.
+func (g GRIBFile) Bounds() (float64, float64, float64, float64) {
+	h := g.Header
+
+	if h.GridType == GridUnstructured {
+		if g.cells == nil {
+			return -90, -180, 90, 180
+		}
+		return g.cells.minLat, g.cells.minLon, g.cells.maxLat, g.cells.maxLon
+	}
+
+	if h.wrapsLongitude() && h.GridType != GridRotatedLatLon {
+		return math.Min(h.La1, h.La2), -180, math.Max(h.La1, h.La2), 180
+	}
+
+	if h.projection() == nil && h.GridType != GridRotatedLatLon {
+		lo1 := h.Lo1
+		if lo1 > 180 {
+			lo1 -= 360
+		}
+		return math.Min(h.La1, h.La2), math.Min(lo1, h.Lo2), math.Max(h.La1, h.La2), math.Max(lo1, h.Lo2)
+	}
+
+	minLat, minLon := math.Inf(1), math.Inf(1)
+	maxLat, maxLon := math.Inf(-1), math.Inf(-1)
+	add := func(x, y int) {
+		lat, lon := g.GetLatLng(x, y)
+		minLat, maxLat = math.Min(minLat, lat), math.Max(maxLat, lat)
+		minLon, maxLon = math.Min(minLon, lon), math.Max(maxLon, lon)
+	}
+
+	// The extremes of a projected or rotated grid lie on its edges, unless it contains a pole.
+	for x := 0; x < h.Nx; x++ {
+		add(x, 0)
+		add(x, h.Ny-1)
+	}
+	for y := 0; y < h.Ny; y++ {
+		add(0, y)
+		add(h.Nx-1, y)
+	}
+
+	if _, _, inside := g.gridCoordinates(90, 0); inside {
+		maxLat, minLon, maxLon = 90, -180, 180
+	}
+	if _, _, inside := g.gridCoordinates(-90, 0); inside {
+		minLat, minLon, maxLon = -90, -180, 180
+	}
+
+	return minLat, minLon, maxLat, maxLon
+}
@@ -0,0 +1,435 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+)
+
+// Interpolator estimates the value of a field between the points of a grid.
+type Interpolator interface {
+	// Interpolate returns the value at the fractional column x and row y
+	// of the grid, or the missing value of the grid.
+	Interpolate(grid GridValues, x, y float64) float64
+}
+
+// GridValues are the values of a grid in scanning mode 0, as seen by an
+// Interpolator.
+type GridValues struct {
+	Width, Height int
+	Values        []float64
+	MissingValue  float64
+	Wrap          bool // columns wrap around, as in global grids
+}
+
+// At returns the value at a column and row of the grid, and whether it is a
+// valid value inside the grid.
+func (v GridValues) At(col, row int) (float64, bool) {
+	col = wrapColumn(col, v.Width, v.Wrap)
+	if row < 0 || row >= v.Height || col < 0 || col >= v.Width {
+		return v.MissingValue, false
+	}
+	value := v.Values[row*v.Width+col]
+	return value, value != v.MissingValue
+}
+
+// values returns the grid values of a message on a regular grid.
+func (g GRIBFile) values() GridValues {
+	return GridValues{
+		Width:        g.Header.Nx,
+		Height:       g.Header.Ny,
+		Values:       g.DataValues,
+		MissingValue: g.Header.MissingValue,
+		Wrap:         g.Header.wrapsLongitude(),
+	}
+}
+
+type nearestInterpolator struct{}
+type bilinearInterpolator struct{}
+type bicubicInterpolator struct{}
+type lanczosInterpolator struct{ a int }
+
+// The available interpolators. Nearest never mixes values, which suits
+// categorical fields such as precipitation or cloud type.
+var (
+	Nearest  Interpolator = nearestInterpolator{}
+	Bilinear Interpolator = bilinearInterpolator{}
+	Bicubic  Interpolator = bicubicInterpolator{}
+	Lanczos  Interpolator = lanczosInterpolator{a: 3}
+)
+
+var interpolators = []struct {
+	name   string
+	interp Interpolator
+}{
+	{"nearest", Nearest},
+	{"bilinear", Bilinear},
+	{"bicubic", Bicubic},
+	{"lanczos", Lanczos},
+}
+
+// InterpolatorNames returns the names accepted by InterpolatorByName.
+func InterpolatorNames() []string {
+	names := make([]string, len(interpolators))
+	for i, entry := range interpolators {
+		names[i] = entry.name
+	}
+	return names
+}
+
+// InterpolatorByName returns the interpolator with the given name. An empty
+// name selects Bicubic.
+func InterpolatorByName(name string) (Interpolator, error) {
+	if name == "" {
+		return Bicubic, nil
+	}
+	for _, entry := range interpolators {
+		if entry.name == name {
+			return entry.interp, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown interpolation %q", name)
+}
+
+// Interpolate returns the value of the nearest grid point.
+func (nearestInterpolator) Interpolate(grid GridValues, x, y float64) float64 {
+	value, ok := grid.At(int(math.Round(x)), int(math.Round(y)))
+	if !ok {
+		return grid.MissingValue
+	}
+	return value
+}
+
+// Interpolate weights the four surrounding grid points, ignoring the ones
+// that are missing.
+func (bilinearInterpolator) Interpolate(grid GridValues, x, y float64) float64 {
+	x0 := int(math.Floor(x))
+	y0 := int(math.Floor(y))
+	u := x - float64(x0)
+	v := y - float64(y0)
+
+	result, totalWeight := 0.0, 0.0
+	for _, p := range [4]struct {
+		col, row int
+		weight   float64
+	}{
+		{x0, y0, (1 - u) * (1 - v)},
+		{x0 + 1, y0, u * (1 - v)},
+		{x0, y0 + 1, (1 - u) * v},
+		{x0 + 1, y0 + 1, u * v},
+	} {
+		if value, ok := grid.At(p.col, p.row); ok && p.weight > 0 {
+			result += value * p.weight
+			totalWeight += p.weight
+		}
+	}
+
+	if totalWeight == 0 {
+		return Nearest.Interpolate(grid, x, y)
+	}
+	return result / totalWeight
+}
+
+// Interpolate uses bicubic convolution, falling back to bilinear and
+// inverse distance weighting near the edges of the grid and missing values.
+func (bicubicInterpolator) Interpolate(grid GridValues, x, y float64) float64 {
+	width := grid.Width
+	height := grid.Height
+	missingValue := grid.MissingValue
+	data := grid.Values
+
+	// Columns of global grids wrap around, the others are clamped.
+	wrap := grid.Wrap
+	if !wrap {
+		if x < 0 {
+			x = 0
+		}
+		if x >= float64(width-1) {
+			x = float64(width) - 1.001 // Slightly less than width to ensure valid index
+		}
+	}
+	if y < 0 {
+		y = 0
+	}
+	if y >= float64(height-1) {
+		y = float64(height) - 1.001 // Slightly less than height to ensure valid index
+	}
+
+	x0 := int(math.Floor(x))
+	y0 := int(math.Floor(y))
+
+	u := x - float64(x0)
+	v := y - float64(y0)
+
+	edgeMargin := 3
+
+	nearEdge := !wrap && (x0 < edgeMargin || x0 >= width-(edgeMargin+1))
+	if nearEdge || y0 < edgeMargin || y0 >= height-(edgeMargin+1) {
+		return adaptiveInterpolation(data, x, y, x0, y0, width, height, missingValue, edgeMargin, wrap)
+	}
+
+	// Calculate bicubic weights
+	u2 := u * u
+	u3 := u2 * u
+	v2 := v * v
+	v3 := v2 * v
+
+	wx0 := (-0.5 * u3) + (u2) - (0.5 * u)
+	wx1 := (1.5 * u3) - (2.5 * u2) + 1
+	wx2 := (-1.5 * u3) + (2.0 * u2) + (0.5 * u)
+	wx3 := (0.5 * u3) - (0.5 * u2)
+	wy0 := (-0.5 * v3) + (v2) - (0.5 * v)
+	wy1 := (1.5 * v3) - (2.5 * v2) + 1
+	wy2 := (-1.5 * v3) + (2.0 * v2) + (0.5 * v)
+	wy3 := (0.5 * v3) - (0.5 * v2)
+
+	values := make([]float64, 16)
+	missingMask := make([]bool, 16)
+
+	indices := [16][2]int{
+		{y0 - 1, x0 - 1}, {y0 - 1, x0}, {y0 - 1, x0 + 1}, {y0 - 1, x0 + 2},
+		{y0, x0 - 1}, {y0, x0}, {y0, x0 + 1}, {y0, x0 + 2},
+		{y0 + 1, x0 - 1}, {y0 + 1, x0}, {y0 + 1, x0 + 1}, {y0 + 1, x0 + 2},
+		{y0 + 2, x0 - 1}, {y0 + 2, x0}, {y0 + 2, x0 + 1}, {y0 + 2, x0 + 2},
+	}
+
+	hasMissing := false
+	for i, idx := range indices {
+		row, col := idx[0], wrapColumn(idx[1], width, wrap)
+		values[i] = data[row*width+col]
+		missingMask[i] = (values[i] == missingValue)
+		if missingMask[i] {
+			hasMissing = true
+		}
+	}
+
+	if hasMissing {
+		return adaptiveInterpolation(data, x, y, x0, y0, width, height, missingValue, edgeMargin, wrap)
+	}
+
+	weights := []float64{
+		wx0 * wy0, wx1 * wy0, wx2 * wy0, wx3 * wy0,
+		wx0 * wy1, wx1 * wy1, wx2 * wy1, wx3 * wy1,
+		wx0 * wy2, wx1 * wy2, wx2 * wy2, wx3 * wy2,
+		wx0 * wy3, wx1 * wy3, wx2 * wy3, wx3 * wy3,
+	}
+
+	result := 0.0
+	for i := 0; i < 16; i++ {
+		result += values[i] * weights[i]
+	}
+
+	return result
+}
+
+func adaptiveInterpolation(data []float64, x, y float64, x0, y0, width, height int, missingValue float64, edgeMargin int, wrap bool) float64 {
+	maxLeft := min(x0, edgeMargin)
+	maxRight := min(width-1-x0, edgeMargin)
+	if wrap {
+		maxLeft, maxRight = edgeMargin, edgeMargin
+	}
+	maxTop := min(y0, edgeMargin)
+	maxBottom := min(height-1-y0, edgeMargin)
+
+	if maxLeft >= 1 && maxRight >= 2 && maxTop >= 1 && maxBottom >= 2 {
+		if maxTop >= 1 && maxBottom >= 1 && maxLeft >= 1 && maxRight >= 1 {
+			result, valid := tryBilinearInterpolation(data, x, y, x0, y0, width, height, missingValue, wrap)
+			if valid {
+				return result
+			}
+		}
+	}
+
+	return gradientAdaptiveInterpolation(data, x, y, x0, y0, width, height, missingValue, wrap)
+}
+
+func tryBilinearInterpolation(data []float64, x, y float64, x0, y0, width, height int, missingValue float64, wrap bool) (float64, bool) {
+	getPixel := func(row, col int) (float64, bool) {
+		col = wrapColumn(col, width, wrap)
+		if row < 0 || row >= height || col < 0 || col >= width {
+			return missingValue, false
+		}
+		val := data[row*width+col]
+		return val, val != missingValue
+	}
+
+	u := x - float64(x0)
+	v := y - float64(y0)
+
+	v00, valid00 := getPixel(y0, x0)
+	v01, valid01 := getPixel(y0, x0+1)
+	v10, valid10 := getPixel(y0+1, x0)
+	v11, valid11 := getPixel(y0+1, x0+1)
+
+	validCount := 0
+	if valid00 {
+		validCount++
+	}
+	if valid01 {
+		validCount++
+	}
+	if valid10 {
+		validCount++
+	}
+	if valid11 {
+		validCount++
+	}
+
+	if validCount < 2 {
+		return 0, false
+	}
+
+	if validCount == 4 {
+		return v00*(1-u)*(1-v) + v01*u*(1-v) + v10*(1-u)*v + v11*u*v, true
+	}
+
+	totalWeight := 0.0
+	result := 0.0
+
+	if valid00 {
+		weight := (1 - u) * (1 - v)
+		result += v00 * weight
+		totalWeight += weight
+	}
+	if valid01 {
+		weight := u * (1 - v)
+		result += v01 * weight
+		totalWeight += weight
+	}
+	if valid10 {
+		weight := (1 - u) * v
+		result += v10 * weight
+		totalWeight += weight
+	}
+	if valid11 {
+		weight := u * v
+		result += v11 * weight
+		totalWeight += weight
+	}
+
+	if totalWeight > 0 {
+		return result / totalWeight, true
+	}
+
+	return 0, false
+}
+
+func gradientAdaptiveInterpolation(data []float64, x, y float64, x0, y0, width, height int, missingValue float64, wrap bool) float64 {
+	radius := 3.0
+
+	type DataPoint struct {
+		value    float64
+		distance float64
+	}
+
+	var validPoints []DataPoint
+
+	for dy := -int(radius); dy <= int(radius); dy++ {
+		for dx := -int(radius); dx <= int(radius); dx++ {
+			nx := x0 + dx
+			ny := y0 + dy
+			col := wrapColumn(nx, width, wrap)
+
+			if col < 0 || col >= width || ny < 0 || ny >= height {
+				continue
+			}
+
+			val := data[ny*width+col]
+			if val != missingValue {
+				px := float64(nx) + 0.5
+				py := float64(ny) + 0.5
+				dist := math.Sqrt((px-x)*(px-x) + (py-y)*(py-y))
+
+				if dist <= radius {
+					validPoints = append(validPoints, DataPoint{
+						value:    val,
+						distance: dist,
+					})
+				}
+			}
+		}
+	}
+
+	if len(validPoints) == 0 {
+		return missingValue
+	}
+
+	if len(validPoints) == 1 {
+		return validPoints[0].value
+	}
+
+	var totalWeight float64
+	var weightedSum float64
+
+	for _, point := range validPoints {
+		weight := 1.0 / (point.distance*point.distance + 0.001)
+
+		weightedSum += point.value * weight
+		totalWeight += weight
+	}
+
+	if totalWeight > 0 {
+		return weightedSum / totalWeight
+	}
+
+	return missingValue
+}
+
+// wrapColumn returns the column col of a grid of the given width, modulo the
+// width if the columns wrap around.
+func wrapColumn(col, width int, wrap bool) int {
+	if !wrap {
+		return col
+	}
+	return ((col % width) + width) % width
+}
+
+// Interpolate applies a Lanczos filter over the 2a x 2a surrounding grid
+// points, repeating the edge points of the grid and ignoring missing values.
+// It is sharper than bicubic convolution but may ring at steep gradients.
+func (l lanczosInterpolator) Interpolate(grid GridValues, x, y float64) float64 {
+	if _, ok := grid.At(int(math.Round(x)), int(math.Round(y))); !ok {
+		return grid.MissingValue
+	}
+
+	x0 := int(math.Floor(x))
+	y0 := int(math.Floor(y))
+
+	result, totalWeight := 0.0, 0.0
+	for row := y0 - l.a + 1; row <= y0+l.a; row++ {
+		wy := l.kernel(y - float64(row))
+		r := max(0, min(row, grid.Height-1))
+
+		for col := x0 - l.a + 1; col <= x0+l.a; col++ {
+			c := col
+			if !grid.Wrap {
+				c = max(0, min(col, grid.Width-1))
+			}
+
+			value, ok := grid.At(c, r)
+			if !ok {
+				continue
+			}
+			weight := wy * l.kernel(x-float64(col))
+			result += value * weight
+			totalWeight += weight
+		}
+	}
+
+	if totalWeight <= 0 {
+		return Bilinear.Interpolate(grid, x, y)
+	}
+	return result / totalWeight
+}
+
+// kernel is the Lanczos window sinc(t) sinc(t/a).
+func (l lanczosInterpolator) kernel(t float64) float64 {
+	if t == 0 {
+		return 1
+	}
+	a := float64(l.a)
+	if math.Abs(t) >= a {
+		return 0
+	}
+	pt := math.Pi * t
+	return a * math.Sin(pt) * math.Sin(pt/a) / (pt * pt)
+}
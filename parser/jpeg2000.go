@@ -0,0 +1,1190 @@
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// A JPEG 2000 codestream decoder (ITU-T T.800) covering what GRIB2
+// encoders produce for data representation template 5.40: a single
+// component, any tiling, precincts and progression order, reversible 5/3
+// or irreversible 9/7 wavelets and all code-block coding styles.
+
+const (
+	markerSOC = 0xFF4F
+	markerSIZ = 0xFF51
+	markerCOD = 0xFF52
+	markerCOC = 0xFF53
+	markerQCD = 0xFF5C
+	markerQCC = 0xFF5D
+	markerPOC = 0xFF5F
+	markerPPM = 0xFF60
+	markerPPT = 0xFF61
+	markerSOT = 0xFF90
+	markerSOP = 0xFF91
+	markerEPH = 0xFF92
+	markerSOD = 0xFF93
+	markerEOC = 0xFFD9
+)
+
+// Progression orders (COD SGcod).
+const (
+	progressionLRCP = iota
+	progressionRLCP
+	progressionRPCL
+	progressionPCRL
+	progressionCPRL
+)
+
+type j2kCodingStyle struct {
+	precincts   bool
+	sop         bool
+	eph         bool
+	progression int
+	layers      int
+	levels      int
+	cbw, cbh    int // code-block size exponents
+	cbStyle     int
+	reversible  bool
+	ppx, ppy    []int // precinct size exponents per resolution
+}
+
+type j2kStep struct {
+	exponent int
+	mantissa int
+}
+
+type j2kQuantization struct {
+	style     int // 0 none, 1 scalar derived, 2 scalar expounded
+	guardBits int
+	steps     []j2kStep
+}
+
+type j2kSegment struct {
+	data      []byte
+	passes    int
+	maxPasses int
+}
+
+type j2kCodeBlock struct {
+	x0, y0, x1, y1 int
+
+	included      bool
+	zeroBitplanes int
+	lblock        int
+	numPasses     int
+	segments      []*j2kSegment
+}
+
+type j2kPrecinctBand struct {
+	band          *j2kBand
+	ncbx, ncby    int
+	blocks        []*j2kCodeBlock
+	inclusion     *tagTree
+	zeroBitplanes *tagTree
+}
+
+type j2kBand struct {
+	orientation    int
+	x0, y0, x1, y1 int
+	numBitplanes   int
+	delta          float64
+	blocks         []*j2kCodeBlock
+}
+
+type j2kResolution struct {
+	x0, y0, x1, y1 int
+	ppx, ppy       int
+	pw, ph         int
+	bands          []*j2kBand
+	precincts      [][]*j2kPrecinctBand
+}
+
+type j2kTile struct {
+	index          int
+	x0, y0, x1, y1 int
+	cod            *j2kCodingStyle
+	qcd            *j2kQuantization
+	data           []byte
+	resolutions    []*j2kResolution
+}
+
+type j2kDecoder struct {
+	x0, y0, x1, y1 int
+	tileX0, tileY0 int
+	tileW, tileH   int
+	tilesX, tilesY int
+	precision      int
+	signed         bool
+	cod            *j2kCodingStyle
+	qcd            *j2kQuantization
+	tiles          map[int]*j2kTile
+}
+
+// decodeJPEG2000 decodes a single component JPEG 2000 codestream (or JP2
+// file) and returns its samples in raster order along with the image width.
+func decodeJPEG2000(data []byte) ([]int64, int, error) {
+	data = jp2Codestream(data)
+	if len(data) < 2 || binary.BigEndian.Uint16(data) != markerSOC {
+		return nil, 0, fmt.Errorf("jpeg2000: missing SOC marker")
+	}
+
+	d := &j2kDecoder{tiles: map[int]*j2kTile{}}
+	if err := d.readCodestream(data); err != nil {
+		return nil, 0, err
+	}
+
+	width := d.x1 - d.x0
+	height := d.y1 - d.y0
+	samples := make([]int64, width*height)
+
+	for _, tile := range d.tiles {
+		if err := d.decodeTile(tile, samples, width); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return samples, width, nil
+}
+
+// jp2Codestream returns the contiguous codestream box of a JP2 file, or
+// data unchanged if it is a raw codestream.
+func jp2Codestream(data []byte) []byte {
+	if len(data) < 12 || string(data[4:8]) != "jP  " {
+		return data
+	}
+
+	offset := 0
+	for offset+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[offset:]))
+		boxType := string(data[offset+4 : offset+8])
+		header := 8
+		if length == 1 && offset+16 <= len(data) {
+			length = int(binary.BigEndian.Uint64(data[offset+8:]))
+			header = 16
+		} else if length == 0 {
+			length = len(data) - offset
+		}
+		if length < header || offset+length > len(data) {
+			break
+		}
+		if boxType == "jp2c" {
+			return data[offset+header : offset+length]
+		}
+		offset += length
+	}
+	return data
+}
+
+func (d *j2kDecoder) readCodestream(data []byte) error {
+	offset := 2
+	var tile *j2kTile
+	tilePartEnd := 0
+
+	for offset+2 <= len(data) {
+		marker := int(binary.BigEndian.Uint16(data[offset:]))
+		offset += 2
+
+		if marker == markerEOC {
+			break
+		}
+		if offset+2 > len(data) {
+			return fmt.Errorf("jpeg2000: truncated marker segment %04X", marker)
+		}
+		length := int(binary.BigEndian.Uint16(data[offset:]))
+		if length < 2 || offset+length > len(data) {
+			return fmt.Errorf("jpeg2000: invalid length of marker segment %04X", marker)
+		}
+		segment := data[offset+2 : offset+length]
+		segmentStart := offset - 2
+		offset += length
+
+		var err error
+		switch marker {
+		case markerSIZ:
+			err = d.readSIZ(segment)
+		case markerCOD:
+			cod, e := readCOD(segment)
+			err = e
+			if tile != nil {
+				tile.cod = cod
+			} else {
+				d.cod = cod
+			}
+		case markerQCD:
+			qcd, e := readQCD(segment)
+			err = e
+			if tile != nil {
+				tile.qcd = qcd
+			} else {
+				d.qcd = qcd
+			}
+		case markerCOC, markerQCC:
+			// Component specific overrides; there is only one component.
+			err = d.readComponentOverride(marker, segment, tile)
+		case markerPOC:
+			err = fmt.Errorf("jpeg2000: progression order changes are not supported")
+		case markerPPM, markerPPT:
+			err = fmt.Errorf("jpeg2000: packed packet headers are not supported")
+		case markerSOT:
+			if len(segment) < 8 {
+				return fmt.Errorf("jpeg2000: SOT segment too short")
+			}
+			if d.cod == nil || d.qcd == nil {
+				return fmt.Errorf("jpeg2000: missing COD or QCD in main header")
+			}
+			index := int(binary.BigEndian.Uint16(segment))
+			tilePartLength := int(binary.BigEndian.Uint32(segment[2:]))
+			if tilePartLength == 0 {
+				tilePartEnd = len(data)
+			} else {
+				tilePartEnd = segmentStart + tilePartLength
+			}
+			if tilePartEnd > len(data) {
+				tilePartEnd = len(data)
+			}
+
+			tile = d.tiles[index]
+			if tile == nil {
+				if index >= d.tilesX*d.tilesY {
+					return fmt.Errorf("jpeg2000: tile index %d out of range", index)
+				}
+				tile = &j2kTile{index: index}
+				d.tiles[index] = tile
+			}
+		case markerSOD:
+			return fmt.Errorf("jpeg2000: unexpected SOD marker")
+		}
+		if err != nil {
+			return err
+		}
+
+		// The tile-part header ends with SOD, followed by the packet data.
+		if tile != nil && offset+2 <= len(data) && int(binary.BigEndian.Uint16(data[offset:])) == markerSOD {
+			offset += 2
+			if tilePartEnd < offset {
+				return fmt.Errorf("jpeg2000: invalid tile-part length")
+			}
+			tile.data = append(tile.data, data[offset:tilePartEnd]...)
+			offset = tilePartEnd
+			tile = nil
+		}
+	}
+
+	if d.tilesX == 0 {
+		return fmt.Errorf("jpeg2000: missing SIZ marker")
+	}
+	return nil
+}
+
+func (d *j2kDecoder) readSIZ(s []byte) error {
+	if len(s) < 36+3 {
+		return fmt.Errorf("jpeg2000: SIZ segment too short")
+	}
+
+	d.x1 = int(binary.BigEndian.Uint32(s[2:]))
+	d.y1 = int(binary.BigEndian.Uint32(s[6:]))
+	d.x0 = int(binary.BigEndian.Uint32(s[10:]))
+	d.y0 = int(binary.BigEndian.Uint32(s[14:]))
+	d.tileW = int(binary.BigEndian.Uint32(s[18:]))
+	d.tileH = int(binary.BigEndian.Uint32(s[22:]))
+	d.tileX0 = int(binary.BigEndian.Uint32(s[26:]))
+	d.tileY0 = int(binary.BigEndian.Uint32(s[30:]))
+	components := int(binary.BigEndian.Uint16(s[34:]))
+
+	if components != 1 {
+		return fmt.Errorf("jpeg2000: %d components, expected 1", components)
+	}
+	if d.x1 <= d.x0 || d.y1 <= d.y0 || d.tileW == 0 || d.tileH == 0 {
+		return fmt.Errorf("jpeg2000: invalid image size")
+	}
+	if s[37] != 1 || s[38] != 1 {
+		return fmt.Errorf("jpeg2000: subsampled components are not supported")
+	}
+
+	d.precision = int(s[36]&0x7F) + 1
+	d.signed = s[36]&0x80 != 0
+	d.tilesX = ceilDiv(d.x1-d.tileX0, d.tileW)
+	d.tilesY = ceilDiv(d.y1-d.tileY0, d.tileH)
+	return nil
+}
+
+func readCOD(s []byte) (*j2kCodingStyle, error) {
+	if len(s) < 10 {
+		return nil, fmt.Errorf("jpeg2000: COD segment too short")
+	}
+
+	scod := s[0]
+	cod := &j2kCodingStyle{
+		precincts:   scod&0x01 != 0,
+		sop:         scod&0x02 != 0,
+		eph:         scod&0x04 != 0,
+		progression: int(s[1]),
+		layers:      int(binary.BigEndian.Uint16(s[2:])),
+	}
+	if err := readCodingParameters(cod, s[5:]); err != nil {
+		return nil, err
+	}
+	return cod, nil
+}
+
+// readCodingParameters reads the SPcod/SPcoc fields shared by COD and COC.
+func readCodingParameters(cod *j2kCodingStyle, s []byte) error {
+	if len(s) < 5 {
+		return fmt.Errorf("jpeg2000: coding style segment too short")
+	}
+
+	cod.levels = int(s[0])
+	cod.cbw = int(s[1]&0x0F) + 2
+	cod.cbh = int(s[2]&0x0F) + 2
+	cod.cbStyle = int(s[3])
+	cod.reversible = s[4] == 1
+
+	if cod.levels > 32 || cod.cbw+cod.cbh > 12 {
+		return fmt.Errorf("jpeg2000: invalid coding style parameters")
+	}
+
+	cod.ppx = make([]int, cod.levels+1)
+	cod.ppy = make([]int, cod.levels+1)
+	for r := 0; r <= cod.levels; r++ {
+		cod.ppx[r], cod.ppy[r] = 15, 15
+		if cod.precincts {
+			if len(s) < 6+r {
+				return fmt.Errorf("jpeg2000: missing precinct sizes")
+			}
+			cod.ppx[r] = int(s[5+r] & 0x0F)
+			cod.ppy[r] = int(s[5+r] >> 4)
+			if r > 0 && (cod.ppx[r] == 0 || cod.ppy[r] == 0) {
+				return fmt.Errorf("jpeg2000: invalid precinct size")
+			}
+		}
+	}
+	return nil
+}
+
+func readQCD(s []byte) (*j2kQuantization, error) {
+	if len(s) < 2 {
+		return nil, fmt.Errorf("jpeg2000: QCD segment too short")
+	}
+
+	q := &j2kQuantization{
+		style:     int(s[0] & 0x1F),
+		guardBits: int(s[0] >> 5),
+	}
+
+	switch q.style {
+	case 0:
+		for _, b := range s[1:] {
+			q.steps = append(q.steps, j2kStep{exponent: int(b >> 3)})
+		}
+	case 1, 2:
+		for i := 1; i+1 < len(s); i += 2 {
+			v := int(binary.BigEndian.Uint16(s[i:]))
+			q.steps = append(q.steps, j2kStep{exponent: v >> 11, mantissa: v & 0x7FF})
+		}
+	default:
+		return nil, fmt.Errorf("jpeg2000: unknown quantization style %d", q.style)
+	}
+
+	if len(q.steps) == 0 {
+		return nil, fmt.Errorf("jpeg2000: no quantization step sizes")
+	}
+	return q, nil
+}
+
+func (d *j2kDecoder) readComponentOverride(marker int, s []byte, tile *j2kTile) error {
+	if len(s) < 1 {
+		return fmt.Errorf("jpeg2000: component segment too short")
+	}
+	// Csiz < 257, so the component index is a single byte.
+	s = s[1:]
+
+	if marker == markerQCC {
+		qcc, err := readQCD(s)
+		if err != nil {
+			return err
+		}
+		if tile != nil {
+			tile.qcd = qcc
+		} else {
+			d.qcd = qcc
+		}
+		return nil
+	}
+
+	base := d.cod
+	if tile != nil && tile.cod != nil {
+		base = tile.cod
+	}
+	if base == nil || len(s) < 1 {
+		return fmt.Errorf("jpeg2000: COC before COD")
+	}
+
+	coc := *base
+	coc.precincts = s[0]&0x01 != 0
+	if err := readCodingParameters(&coc, s[1:]); err != nil {
+		return err
+	}
+	if tile != nil {
+		tile.cod = &coc
+	} else {
+		d.cod = &coc
+	}
+	return nil
+}
+
+func ceilDiv(a, b int) int {
+	return -floorDiv(-a, b)
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+
+// setupTile computes the resolution, sub-band, precinct and code-block geometry of a tile.
+func (d *j2kDecoder) setupTile(tile *j2kTile) error {
+	if tile.cod == nil {
+		tile.cod = d.cod
+	}
+	if tile.qcd == nil {
+		tile.qcd = d.qcd
+	}
+	cod := tile.cod
+	qcd := tile.qcd
+
+	p := tile.index % d.tilesX
+	q := tile.index / d.tilesX
+	tile.x0 = max(d.tileX0+p*d.tileW, d.x0)
+	tile.y0 = max(d.tileY0+q*d.tileH, d.y0)
+	tile.x1 = min(d.tileX0+(p+1)*d.tileW, d.x1)
+	tile.y1 = min(d.tileY0+(q+1)*d.tileH, d.y1)
+
+	levels := cod.levels
+	bandIndex := 0
+	for r := 0; r <= levels; r++ {
+		scale := 1 << uint(levels-r)
+		res := &j2kResolution{
+			x0:  ceilDiv(tile.x0, scale),
+			y0:  ceilDiv(tile.y0, scale),
+			x1:  ceilDiv(tile.x1, scale),
+			y1:  ceilDiv(tile.y1, scale),
+			ppx: cod.ppx[r],
+			ppy: cod.ppy[r],
+		}
+		if res.x1 > res.x0 && res.y1 > res.y0 {
+			res.pw = ceilDiv(res.x1, 1<<uint(res.ppx)) - floorDiv(res.x0, 1<<uint(res.ppx))
+			res.ph = ceilDiv(res.y1, 1<<uint(res.ppy)) - floorDiv(res.y0, 1<<uint(res.ppy))
+		}
+
+		orientations := []int{bandLL}
+		if r > 0 {
+			orientations = []int{bandHL, bandLH, bandHH}
+		}
+
+		for _, orientation := range orientations {
+			band := &j2kBand{orientation: orientation}
+			level := levels - r + 1
+			if r == 0 {
+				level = levels
+			}
+			xob, yob := 0, 0
+			if orientation == bandHL || orientation == bandHH {
+				xob = 1
+			}
+			if orientation == bandLH || orientation == bandHH {
+				yob = 1
+			}
+			if level == 0 {
+				band.x0, band.y0, band.x1, band.y1 = tile.x0, tile.y0, tile.x1, tile.y1
+			} else {
+				half := 1 << uint(level-1)
+				band.x0 = ceilDiv(tile.x0-half*xob, 1<<uint(level))
+				band.y0 = ceilDiv(tile.y0-half*yob, 1<<uint(level))
+				band.x1 = ceilDiv(tile.x1-half*xob, 1<<uint(level))
+				band.y1 = ceilDiv(tile.y1-half*yob, 1<<uint(level))
+			}
+
+			step, err := quantizationStep(qcd, bandIndex, levels, level)
+			if err != nil {
+				return err
+			}
+			gain := 0
+			switch orientation {
+			case bandHL, bandLH:
+				gain = 1
+			case bandHH:
+				gain = 2
+			}
+			band.numBitplanes = qcd.guardBits + step.exponent - 1
+			if !cod.reversible {
+				band.delta = math.Ldexp(1+float64(step.mantissa)/2048, d.precision+gain-step.exponent)
+			}
+
+			res.bands = append(res.bands, band)
+			bandIndex++
+		}
+
+		d.setupPrecincts(res, cod, r)
+		tile.resolutions = append(tile.resolutions, res)
+	}
+
+	return nil
+}
+
+func quantizationStep(qcd *j2kQuantization, bandIndex, levels, level int) (j2kStep, error) {
+	if qcd.style == 1 {
+		step := qcd.steps[0]
+		step.exponent = step.exponent - levels + level
+		return step, nil
+	}
+	if bandIndex >= len(qcd.steps) {
+		return j2kStep{}, fmt.Errorf("jpeg2000: missing quantization step for sub-band %d", bandIndex)
+	}
+	return qcd.steps[bandIndex], nil
+}
+
+func (d *j2kDecoder) setupPrecincts(res *j2kResolution, cod *j2kCodingStyle, r int) {
+	ppx, ppy := res.ppx, res.ppy
+	cbw, cbh := cod.cbw, cod.cbh
+	if r > 0 {
+		ppx--
+		ppy--
+	}
+	cbw = min(cbw, ppx)
+	cbh = min(cbh, ppy)
+
+	res.precincts = make([][]*j2kPrecinctBand, res.pw*res.ph)
+	for pj := 0; pj < res.ph; pj++ {
+		for pi := 0; pi < res.pw; pi++ {
+			// Precinct bounds in sub-band coordinates.
+			px0 := (floorDiv(res.x0, 1<<uint(res.ppx)) + pi) << uint(ppx)
+			py0 := (floorDiv(res.y0, 1<<uint(res.ppy)) + pj) << uint(ppy)
+			px1 := px0 + 1<<uint(ppx)
+			py1 := py0 + 1<<uint(ppy)
+
+			var bands []*j2kPrecinctBand
+			for _, band := range res.bands {
+				pb := &j2kPrecinctBand{band: band}
+				x0, y0 := max(px0, band.x0), max(py0, band.y0)
+				x1, y1 := min(px1, band.x1), min(py1, band.y1)
+
+				if x1 > x0 && y1 > y0 {
+					cbx0 := floorDiv(x0, 1<<uint(cbw))
+					cby0 := floorDiv(y0, 1<<uint(cbh))
+					pb.ncbx = ceilDiv(x1, 1<<uint(cbw)) - cbx0
+					pb.ncby = ceilDiv(y1, 1<<uint(cbh)) - cby0
+
+					for j := 0; j < pb.ncby; j++ {
+						for i := 0; i < pb.ncbx; i++ {
+							bx := (cbx0 + i) << uint(cbw)
+							by := (cby0 + j) << uint(cbh)
+							cb := &j2kCodeBlock{
+								x0:     max(bx, x0),
+								y0:     max(by, y0),
+								x1:     min(bx+1<<uint(cbw), x1),
+								y1:     min(by+1<<uint(cbh), y1),
+								lblock: 3,
+							}
+							pb.blocks = append(pb.blocks, cb)
+							band.blocks = append(band.blocks, cb)
+						}
+					}
+					pb.inclusion = newTagTree(pb.ncbx, pb.ncby)
+					pb.zeroBitplanes = newTagTree(pb.ncbx, pb.ncby)
+				}
+				bands = append(bands, pb)
+			}
+			res.precincts[pj*res.pw+pi] = bands
+		}
+	}
+}
+
+type packetID struct {
+	layer, resolution, precinct int
+}
+
+// packetOrder lists the packets of a tile in the order of its progression.
+func (d *j2kDecoder) packetOrder(tile *j2kTile) ([]packetID, error) {
+	cod := tile.cod
+	var packets []packetID
+
+	switch cod.progression {
+	case progressionLRCP:
+		for l := 0; l < cod.layers; l++ {
+			for r, res := range tile.resolutions {
+				for p := range res.precincts {
+					packets = append(packets, packetID{l, r, p})
+				}
+			}
+		}
+	case progressionRLCP:
+		for r, res := range tile.resolutions {
+			for l := 0; l < cod.layers; l++ {
+				for p := range res.precincts {
+					packets = append(packets, packetID{l, r, p})
+				}
+			}
+		}
+	case progressionRPCL:
+		for r := range tile.resolutions {
+			d.positionOrder(tile, []int{r}, &packets)
+		}
+	case progressionPCRL, progressionCPRL:
+		resolutions := make([]int, len(tile.resolutions))
+		for r := range resolutions {
+			resolutions[r] = r
+		}
+		d.positionOrder(tile, resolutions, &packets)
+	default:
+		return nil, fmt.Errorf("jpeg2000: unknown progression order %d", cod.progression)
+	}
+
+	return packets, nil
+}
+
+// positionOrder appends the packets of the given resolutions in
+// position-driven order, visiting precincts by their location on the
+// reference grid (ITU-T T.800 B.12.1.3).
+func (d *j2kDecoder) positionOrder(tile *j2kTile, resolutions []int, packets *[]packetID) {
+	levels := len(tile.resolutions) - 1
+
+	stepX, stepY := 0, 0
+	for _, r := range resolutions {
+		res := tile.resolutions[r]
+		sx := 1 << uint(res.ppx+levels-r)
+		sy := 1 << uint(res.ppy+levels-r)
+		if stepX == 0 || sx < stepX {
+			stepX = sx
+		}
+		if stepY == 0 || sy < stepY {
+			stepY = sy
+		}
+	}
+
+	for y := tile.y0; y < tile.y1; y += stepY - y%stepY {
+		for x := tile.x0; x < tile.x1; x += stepX - x%stepX {
+			for _, r := range resolutions {
+				res := tile.resolutions[r]
+				if res.pw == 0 || res.ph == 0 {
+					continue
+				}
+				level := uint(levels - r)
+				rpx := uint(res.ppx) + level
+				rpy := uint(res.ppy) + level
+
+				if !(y%(1<<rpy) == 0 || (y == tile.y0 && (res.y0<<level)%(1<<rpy) != 0)) {
+					continue
+				}
+				if !(x%(1<<rpx) == 0 || (x == tile.x0 && (res.x0<<level)%(1<<rpx) != 0)) {
+					continue
+				}
+
+				pi := floorDiv(ceilDiv(x, 1<<level), 1<<uint(res.ppx)) - floorDiv(res.x0, 1<<uint(res.ppx))
+				pj := floorDiv(ceilDiv(y, 1<<level), 1<<uint(res.ppy)) - floorDiv(res.y0, 1<<uint(res.ppy))
+				for l := 0; l < tile.cod.layers; l++ {
+					*packets = append(*packets, packetID{l, r, pj*res.pw + pi})
+				}
+			}
+		}
+	}
+}
+
+func (d *j2kDecoder) decodeTile(tile *j2kTile, samples []int64, width int) error {
+	if err := d.setupTile(tile); err != nil {
+		return err
+	}
+
+	packets, err := d.packetOrder(tile)
+	if err != nil {
+		return err
+	}
+
+	data := tile.data
+	offset := 0
+	for _, packet := range packets {
+		if offset >= len(data) {
+			break
+		}
+		precinct := tile.resolutions[packet.resolution].precincts[packet.precinct]
+		offset, err = readPacket(data, offset, precinct, packet.layer, tile.cod)
+		if err != nil {
+			return err
+		}
+	}
+
+	coefficients := d.decodeCoefficients(tile)
+
+	shift := 0.0
+	if !d.signed {
+		shift = math.Ldexp(1, d.precision-1)
+	}
+	tileW := tile.x1 - tile.x0
+	for y := tile.y0; y < tile.y1; y++ {
+		for x := tile.x0; x < tile.x1; x++ {
+			v := coefficients[(y-tile.y0)*tileW+x-tile.x0] + shift
+			samples[(y-d.y0)*width+x-d.x0] = int64(math.Round(v))
+		}
+	}
+	return nil
+}
+
+// readPacket decodes one packet header and attaches the code-block
+// contributions to their code-blocks. It returns the offset after the packet.
+func readPacket(data []byte, offset int, precinct []*j2kPrecinctBand, layer int, cod *j2kCodingStyle) (int, error) {
+	if cod.sop && offset+6 <= len(data) && int(binary.BigEndian.Uint16(data[offset:])) == markerSOP {
+		offset += 6
+	}
+
+	r := &packetHeaderReader{data: data, pos: offset}
+	type contribution struct {
+		block    *j2kCodeBlock
+		segments []*j2kSegment
+		lengths  []int
+	}
+	var contributions []contribution
+
+	if r.readBit() == 1 {
+		for _, pb := range precinct {
+			for i, cb := range pb.blocks {
+				included := false
+				if !cb.included {
+					included = pb.inclusion.decode(r, i, layer+1)
+				} else {
+					included = r.readBit() == 1
+				}
+				if !included {
+					continue
+				}
+
+				if !cb.included {
+					threshold := 1
+					for !pb.zeroBitplanes.decode(r, i, threshold) {
+						threshold++
+					}
+					cb.zeroBitplanes = pb.zeroBitplanes.value(i)
+					cb.included = true
+				}
+
+				passes := readPassCount(r)
+				for r.readBit() == 1 {
+					cb.lblock++
+				}
+
+				c := contribution{block: cb}
+				for passes > 0 {
+					segment := cb.currentSegment(cod.cbStyle)
+					n := min(segment.maxPasses-segment.passes, passes)
+					length := r.readBits(cb.lblock + floorLog2(n))
+
+					segment.passes += n
+					cb.numPasses += n
+					passes -= n
+					c.segments = append(c.segments, segment)
+					c.lengths = append(c.lengths, length)
+				}
+				contributions = append(contributions, c)
+			}
+		}
+	}
+
+	if r.err != nil {
+		return 0, r.err
+	}
+	offset = r.align()
+
+	if cod.eph && offset+2 <= len(data) && int(binary.BigEndian.Uint16(data[offset:])) == markerEPH {
+		offset += 2
+	}
+
+	for _, c := range contributions {
+		for i, segment := range c.segments {
+			end := offset + c.lengths[i]
+			if end > len(data) {
+				return 0, fmt.Errorf("jpeg2000: code-block data exceeds tile data")
+			}
+			segment.data = append(segment.data, data[offset:end]...)
+			offset = end
+		}
+	}
+
+	return offset, nil
+}
+
+// currentSegment returns the segment receiving the next coding passes,
+// starting a new one when the current segment is complete.
+func (cb *j2kCodeBlock) currentSegment(style int) *j2kSegment {
+	if n := len(cb.segments); n > 0 && cb.segments[n-1].passes < cb.segments[n-1].maxPasses {
+		return cb.segments[n-1]
+	}
+
+	maxPasses := 109
+	if style&cblkTermAll != 0 {
+		maxPasses = 1
+	} else if style&cblkBypass != 0 {
+		if len(cb.segments) == 0 {
+			maxPasses = 10
+		} else if last := cb.segments[len(cb.segments)-1].maxPasses; last == 1 || last == 10 {
+			maxPasses = 2
+		} else {
+			maxPasses = 1
+		}
+	}
+
+	segment := &j2kSegment{maxPasses: maxPasses}
+	cb.segments = append(cb.segments, segment)
+	return segment
+}
+
+func readPassCount(r *packetHeaderReader) int {
+	if r.readBit() == 0 {
+		return 1
+	}
+	if r.readBit() == 0 {
+		return 2
+	}
+	if n := r.readBits(2); n != 3 {
+		return 3 + n
+	}
+	if n := r.readBits(5); n != 31 {
+		return 6 + n
+	}
+	return 37 + r.readBits(7)
+}
+
+func floorLog2(n int) int {
+	l := 0
+	for n > 1 {
+		n >>= 1
+		l++
+	}
+	return l
+}
+
+// packetHeaderReader reads packet header bits, skipping the bit stuffed after 0xFF bytes.
+type packetHeaderReader struct {
+	data   []byte
+	pos    int
+	buf    byte
+	bits   int
+	prevFF bool
+	err    error
+}
+
+func (r *packetHeaderReader) readBit() int {
+	if r.bits == 0 {
+		if r.pos >= len(r.data) {
+			r.err = fmt.Errorf("jpeg2000: truncated packet header")
+			return 0
+		}
+		r.bits = 8
+		if r.prevFF {
+			r.bits = 7
+		}
+		r.buf = r.data[r.pos]
+		r.prevFF = r.buf == 0xFF
+		r.pos++
+	}
+	r.bits--
+	return int(r.buf>>uint(r.bits)) & 1
+}
+
+func (r *packetHeaderReader) readBits(n int) int {
+	v := 0
+	for i := 0; i < n; i++ {
+		v = v<<1 | r.readBit()
+	}
+	return v
+}
+
+// align ends the packet header and returns the offset of the packet body.
+func (r *packetHeaderReader) align() int {
+	if r.prevFF {
+		r.pos++
+	}
+	r.bits = 0
+	r.prevFF = false
+	return r.pos
+}
+
+// tagTree decodes the inclusion and zero bit-plane tag trees of a precinct.
+type tagTree struct {
+	nodes   []tagNode
+	parents []int
+}
+
+type tagNode struct {
+	value int
+	low   int
+}
+
+func newTagTree(w, h int) *tagTree {
+	t := &tagTree{}
+
+	type level struct{ offset, w, h int }
+	var levels []level
+	total := 0
+	for {
+		levels = append(levels, level{total, w, h})
+		total += w * h
+		if w == 1 && h == 1 {
+			break
+		}
+		w = (w + 1) / 2
+		h = (h + 1) / 2
+	}
+
+	t.nodes = make([]tagNode, total)
+	t.parents = make([]int, total)
+	for i := range t.nodes {
+		t.nodes[i].value = math.MaxInt32
+	}
+
+	for k, lv := range levels {
+		for y := 0; y < lv.h; y++ {
+			for x := 0; x < lv.w; x++ {
+				i := lv.offset + y*lv.w + x
+				if k == len(levels)-1 {
+					t.parents[i] = -1
+					continue
+				}
+				next := levels[k+1]
+				t.parents[i] = next.offset + (y/2)*next.w + x/2
+			}
+		}
+	}
+	return t
+}
+
+// decode reads bits until it is known whether the value of the leaf is
+// below threshold, and reports the result.
+func (t *tagTree) decode(r *packetHeaderReader, leaf, threshold int) bool {
+	var stack []int
+	for n := leaf; n >= 0; n = t.parents[n] {
+		stack = append(stack, n)
+	}
+
+	low := 0
+	for i := len(stack) - 1; i >= 0; i-- {
+		node := &t.nodes[stack[i]]
+		if low > node.low {
+			node.low = low
+		} else {
+			low = node.low
+		}
+		for low < threshold && low < node.value {
+			if r.readBit() == 1 {
+				node.value = low
+			} else {
+				low++
+			}
+			if r.err != nil {
+				return false
+			}
+		}
+		node.low = low
+	}
+
+	return t.nodes[leaf].value < threshold
+}
+
+func (t *tagTree) value(leaf int) int {
+	return t.nodes[leaf].value
+}
+
+// decodeCoefficients runs tier-1 decoding and the inverse wavelet transform
+// and returns the tile samples before the DC level shift.
+func (d *j2kDecoder) decodeCoefficients(tile *j2kTile) []float64 {
+	cod := tile.cod
+
+	var image []float64
+	for r, res := range tile.resolutions {
+		bands := make([][]float64, len(res.bands))
+		for b, band := range res.bands {
+			bands[b] = decodeBand(band, cod)
+		}
+
+		if r == 0 {
+			image = bands[0]
+			continue
+		}
+
+		prev := tile.resolutions[r-1]
+		image = inverseWavelet(image, bands, prev, res, cod.reversible)
+	}
+
+	return image
+}
+
+func decodeBand(band *j2kBand, cod *j2kCodingStyle) []float64 {
+	w := band.x1 - band.x0
+	h := band.y1 - band.y0
+	values := make([]float64, w*h)
+
+	for _, cb := range band.blocks {
+		numBitplanes := band.numBitplanes - cb.zeroBitplanes
+		if cb.numPasses == 0 || numBitplanes <= 0 {
+			continue
+		}
+
+		coefficients, lowest := decodeCodeBlock(cb, band.orientation, cod.cbStyle, numBitplanes)
+		cbw := cb.x1 - cb.x0
+
+		for y := cb.y0; y < cb.y1; y++ {
+			for x := cb.x0; x < cb.x1; x++ {
+				c := coefficients[(y-cb.y0)*cbw+x-cb.x0]
+				v := float64(c)
+				if c != 0 {
+					half := 0.0
+					if !cod.reversible {
+						half = math.Ldexp(0.5, lowest)
+					} else if lowest > 0 {
+						half = math.Ldexp(1, lowest-1)
+					}
+					if c < 0 {
+						v -= half
+					} else {
+						v += half
+					}
+				}
+				if !cod.reversible {
+					v *= band.delta
+				}
+				values[(y-band.y0)*w+x-band.x0] = v
+			}
+		}
+	}
+
+	return values
+}
+
+// inverseWavelet combines the lower resolution image with the HL, LH and
+// HH bands of res into the image of res (ITU-T T.800 F.3.2).
+func inverseWavelet(low []float64, bands [][]float64, prev, res *j2kResolution, reversible bool) []float64 {
+	u0, u1, v0, v1 := res.x0, res.x1, res.y0, res.y1
+	w := u1 - u0
+	h := v1 - v0
+	out := make([]float64, w*h)
+
+	// Interleave the four sub-bands.
+	lowW := prev.x1 - prev.x0
+	for v := v0; v < v1; v++ {
+		for u := u0; u < u1; u++ {
+			var value float64
+			switch {
+			case u%2 == 0 && v%2 == 0:
+				value = low[(v/2-prev.y0)*lowW+u/2-prev.x0]
+			case v%2 == 0:
+				value = bandValue(bands[0], res.bands[0], (u-1)/2, v/2)
+			case u%2 == 0:
+				value = bandValue(bands[1], res.bands[1], u/2, (v-1)/2)
+			default:
+				value = bandValue(bands[2], res.bands[2], (u-1)/2, (v-1)/2)
+			}
+			out[(v-v0)*w+u-u0] = value
+		}
+	}
+
+	line := make([]float64, max(w, h))
+	for y := 0; y < h; y++ {
+		copy(line, out[y*w:(y+1)*w])
+		synthesize(line[:w], u0, reversible)
+		copy(out[y*w:(y+1)*w], line[:w])
+	}
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			line[y] = out[y*w+x]
+		}
+		synthesize(line[:h], v0, reversible)
+		for y := 0; y < h; y++ {
+			out[y*w+x] = line[y]
+		}
+	}
+
+	return out
+}
+
+func bandValue(values []float64, band *j2kBand, x, y int) float64 {
+	return values[(y-band.y0)*(band.x1-band.x0)+x-band.x0]
+}
+
+// 9/7 irreversible lifting coefficients.
+const (
+	liftAlpha = -1.586134342059924
+	liftBeta  = -0.052980118572961
+	liftGamma = 0.882911075530934
+	liftDelta = 0.443506852043971
+	liftK     = 1.230174104914001
+)
+
+// synthesize performs the one-dimensional inverse transform of a line whose
+// first sample has absolute coordinate i0 (ITU-T T.800 F.3.6 to F.3.8).
+func synthesize(x []float64, i0 int, reversible bool) {
+	n := len(x)
+	if n == 1 {
+		if i0%2 != 0 {
+			if reversible {
+				x[0] = math.Trunc(x[0] / 2)
+			} else {
+				x[0] /= 2
+			}
+		}
+		return
+	}
+
+	// Symmetric extension: map absolute index i into the line.
+	at := func(i int) float64 {
+		period := 2 * (n - 1)
+		k := (i - i0) % period
+		if k < 0 {
+			k += period
+		}
+		if k >= n {
+			k = period - k
+		}
+		return x[k]
+	}
+	even := func(i int) bool { return i%2 == 0 }
+
+	if reversible {
+		for i := i0; i < i0+n; i++ {
+			if even(i) {
+				x[i-i0] -= math.Floor((at(i-1) + at(i+1) + 2) / 4)
+			}
+		}
+		for i := i0; i < i0+n; i++ {
+			if !even(i) {
+				x[i-i0] += math.Floor((at(i-1) + at(i+1)) / 2)
+			}
+		}
+		return
+	}
+
+	for i := i0; i < i0+n; i++ {
+		if even(i) {
+			x[i-i0] *= liftK
+		} else {
+			x[i-i0] /= liftK
+		}
+	}
+	steps := []struct {
+		even bool
+		c    float64
+	}{
+		{true, liftDelta},
+		{false, liftGamma},
+		{true, liftBeta},
+		{false, liftAlpha},
+	}
+	for _, step := range steps {
+		for i := i0; i < i0+n; i++ {
+			if even(i) == step.even {
+				x[i-i0] -= step.c * (at(i-1) + at(i+1))
+			}
+		}
+	}
+}
@@ -0,0 +1,557 @@
+package parser
+
+// MQ arithmetic decoder and EBCOT tier-1 code-block decoding (ITU-T T.800
+// Annex C and D), as needed for GRIB2 data representation template 5.40.
+
+type mqState struct {
+	qe         uint32
+	nmps, nlps uint8
+	switchMPS  bool
+}
+
+var mqStates = [47]mqState{
+	{0x5601, 1, 1, true}, {0x3401, 2, 6, false}, {0x1801, 3, 9, false}, {0x0AC1, 4, 12, false},
+	{0x0521, 5, 29, false}, {0x0221, 38, 33, false}, {0x5601, 7, 6, true}, {0x5401, 8, 14, false},
+	{0x4801, 9, 14, false}, {0x3801, 10, 14, false}, {0x3001, 11, 17, false}, {0x2401, 12, 18, false},
+	{0x1C01, 13, 20, false}, {0x1601, 29, 21, false}, {0x5601, 15, 14, true}, {0x5401, 16, 14, false},
+	{0x5101, 17, 15, false}, {0x4801, 18, 16, false}, {0x3801, 19, 17, false}, {0x3401, 20, 18, false},
+	{0x3001, 21, 19, false}, {0x2801, 22, 19, false}, {0x2401, 23, 20, false}, {0x2201, 24, 21, false},
+	{0x1C01, 25, 22, false}, {0x1801, 26, 23, false}, {0x1601, 27, 24, false}, {0x1401, 28, 25, false},
+	{0x1201, 29, 26, false}, {0x1101, 30, 27, false}, {0x0AC1, 31, 28, false}, {0x09C1, 32, 29, false},
+	{0x08A1, 33, 30, false}, {0x0521, 34, 31, false}, {0x0441, 35, 32, false}, {0x02A1, 36, 33, false},
+	{0x0221, 37, 34, false}, {0x0141, 38, 35, false}, {0x0111, 39, 36, false}, {0x0085, 40, 37, false},
+	{0x0049, 41, 38, false}, {0x0025, 42, 39, false}, {0x0015, 43, 40, false}, {0x0009, 44, 41, false},
+	{0x0005, 45, 42, false}, {0x0001, 45, 43, false}, {0x5601, 46, 46, false},
+}
+
+const (
+	ctxZeroCoding  = 0 // 0-8
+	ctxSign        = 9 // 9-13
+	ctxMagnitude   = 14
+	ctxRunLength   = 17
+	ctxUniform     = 18
+	numMQContexts  = 19
+	mqUniformState = 46
+	mqRunState     = 3
+	mqZeroState    = 4
+)
+
+type mqContext struct {
+	state uint8
+	mps   uint8
+}
+
+type mqDecoder struct {
+	data []byte
+	bp   int
+	a    uint32
+	c    uint32
+	ct   int
+
+	contexts [numMQContexts]mqContext
+}
+
+func (m *mqDecoder) resetContexts() {
+	for i := range m.contexts {
+		m.contexts[i] = mqContext{}
+	}
+	m.contexts[ctxZeroCoding].state = mqZeroState
+	m.contexts[ctxRunLength].state = mqRunState
+	m.contexts[ctxUniform].state = mqUniformState
+}
+
+// byteAt returns 0xFF past the end of the segment, which the decoder treats as a marker.
+func (m *mqDecoder) byteAt(i int) uint32 {
+	if i < len(m.data) {
+		return uint32(m.data[i])
+	}
+	return 0xFF
+}
+
+func (m *mqDecoder) init(data []byte) {
+	m.data = data
+	m.bp = 0
+	m.c = m.byteAt(0) << 16
+	m.byteIn()
+	m.c <<= 7
+	m.ct -= 7
+	m.a = 0x8000
+}
+
+func (m *mqDecoder) byteIn() {
+	if m.byteAt(m.bp) == 0xFF {
+		if m.byteAt(m.bp+1) > 0x8F {
+			m.c += 0xFF00
+			m.ct = 8
+		} else {
+			m.bp++
+			m.c += m.byteAt(m.bp) << 9
+			m.ct = 7
+		}
+	} else {
+		m.bp++
+		m.c += m.byteAt(m.bp) << 8
+		m.ct = 8
+	}
+}
+
+func (m *mqDecoder) renormalize() {
+	for {
+		if m.ct == 0 {
+			m.byteIn()
+		}
+		m.a <<= 1
+		m.c <<= 1
+		m.ct--
+		if m.a&0x8000 != 0 {
+			return
+		}
+	}
+}
+
+func (m *mqDecoder) decode(cx int) int {
+	ctx := &m.contexts[cx]
+	state := &mqStates[ctx.state]
+	qe := state.qe
+	var d uint8
+
+	m.a -= qe
+	if m.c>>16 < qe {
+		// LPS exchange
+		if m.a < qe {
+			d = ctx.mps
+			ctx.state = state.nmps
+		} else {
+			d = 1 - ctx.mps
+			if state.switchMPS {
+				ctx.mps = 1 - ctx.mps
+			}
+			ctx.state = state.nlps
+		}
+		m.a = qe
+		m.renormalize()
+	} else {
+		m.c -= qe << 16
+		if m.a&0x8000 == 0 {
+			// MPS exchange
+			if m.a < qe {
+				d = 1 - ctx.mps
+				if state.switchMPS {
+					ctx.mps = 1 - ctx.mps
+				}
+				ctx.state = state.nlps
+			} else {
+				d = ctx.mps
+				ctx.state = state.nmps
+			}
+			m.renormalize()
+		} else {
+			d = ctx.mps
+		}
+	}
+
+	return int(d)
+}
+
+// rawDecoder reads the bypass (lazy) coded passes.
+type rawDecoder struct {
+	data []byte
+	bp   int
+	c    uint32
+	ct   int
+}
+
+func (r *rawDecoder) init(data []byte) {
+	*r = rawDecoder{data: data}
+}
+
+func (r *rawDecoder) decode() int {
+	if r.ct == 0 {
+		next := uint32(0xFF)
+		if r.bp < len(r.data) {
+			next = uint32(r.data[r.bp])
+		}
+		if r.c == 0xFF {
+			if next > 0x8F {
+				r.c = 0xFF
+				r.ct = 8
+			} else {
+				r.c = next
+				r.bp++
+				r.ct = 7
+			}
+		} else {
+			r.c = next
+			r.bp++
+			r.ct = 8
+		}
+	}
+	r.ct--
+	return int(r.c>>uint(r.ct)) & 1
+}
+
+// Code-block style flags (SPcod/SPcoc).
+const (
+	cblkBypass         = 0x01
+	cblkReset          = 0x02
+	cblkTermAll        = 0x04
+	cblkVerticalCausal = 0x08
+	cblkSegmentation   = 0x20
+)
+
+// Per-sample state flags.
+const (
+	t1Significant = 1 << iota
+	t1Negative
+	t1Visited
+	t1Refined
+)
+
+// Sub-band orientations.
+const (
+	bandLL = iota
+	bandHL
+	bandLH
+	bandHH
+)
+
+type t1Decoder struct {
+	width, height int
+	stride        int
+	flags         []uint8 // padded by one sample on every side
+	magnitude     []int64
+	orientation   int
+	style         int
+
+	mq  mqDecoder
+	raw rawDecoder
+}
+
+// decodeCodeBlock runs the coding passes of one code-block and returns the
+// signed coefficients (in units of the least significant bit-plane) along
+// with the lowest bit-plane that was decoded.
+func decodeCodeBlock(cb *j2kCodeBlock, orientation, style, numBitplanes int) ([]int64, int) {
+	w := cb.x1 - cb.x0
+	h := cb.y1 - cb.y0
+	t := &t1Decoder{
+		width:       w,
+		height:      h,
+		stride:      w + 2,
+		flags:       make([]uint8, (w+2)*(h+2)),
+		magnitude:   make([]int64, w*h),
+		orientation: orientation,
+		style:       style,
+	}
+	t.mq.resetContexts()
+
+	bitplane := numBitplanes - 1
+	passType := 2 // the first pass is a cleanup pass
+	lowest := numBitplanes
+	passIndex := 0
+
+	for _, segment := range cb.segments {
+		if segment.passes == 0 {
+			continue
+		}
+		raw := style&cblkBypass != 0 && passIndex >= 10 && passType != 2
+		if raw {
+			t.raw.init(segment.data)
+		} else {
+			t.mq.init(segment.data)
+		}
+
+		for p := 0; p < segment.passes && bitplane >= 0; p++ {
+			if style&cblkReset != 0 {
+				t.mq.resetContexts()
+			}
+
+			switch passType {
+			case 0:
+				t.significancePass(bitplane, raw)
+			case 1:
+				t.refinementPass(bitplane, raw)
+			case 2:
+				t.cleanupPass(bitplane)
+				if style&cblkSegmentation != 0 {
+					for i := 0; i < 4; i++ {
+						t.mq.decode(ctxUniform)
+					}
+				}
+			}
+			lowest = bitplane
+
+			passIndex++
+			passType++
+			if passType == 3 {
+				passType = 0
+				bitplane--
+			}
+		}
+	}
+
+	coefficients := make([]int64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := t.magnitude[y*w+x]
+			if t.flags[(y+1)*t.stride+x+1]&t1Negative != 0 {
+				v = -v
+			}
+			coefficients[y*w+x] = v
+		}
+	}
+
+	return coefficients, lowest
+}
+
+func (t *t1Decoder) index(x, y int) int {
+	return (y+1)*t.stride + x + 1
+}
+
+// neighbours counts the significant horizontal, vertical and diagonal
+// neighbours of a sample.
+func (t *t1Decoder) neighbours(x, y int) (h, v, d int) {
+	i := t.index(x, y)
+	s := t.stride
+	f := t.flags
+	causal := t.style&cblkVerticalCausal != 0 && y%4 == 3
+
+	h = int(f[i-1]&t1Significant) + int(f[i+1]&t1Significant)
+	v = int(f[i-s] & t1Significant)
+	d = int(f[i-s-1]&t1Significant) + int(f[i-s+1]&t1Significant)
+	if !causal {
+		v += int(f[i+s] & t1Significant)
+		d += int(f[i+s-1]&t1Significant) + int(f[i+s+1]&t1Significant)
+	}
+	return h, v, d
+}
+
+func (t *t1Decoder) zeroContext(x, y int) int {
+	h, v, d := t.neighbours(x, y)
+
+	switch t.orientation {
+	case bandHL:
+		h, v = v, h
+	case bandHH:
+		hv := h + v
+		switch {
+		case d >= 3:
+			return 8
+		case d == 2:
+			if hv >= 1 {
+				return 7
+			}
+			return 6
+		case d == 1:
+			if hv >= 2 {
+				return 5
+			}
+			if hv == 1 {
+				return 4
+			}
+			return 3
+		default:
+			if hv >= 2 {
+				return 2
+			}
+			return hv
+		}
+	}
+
+	switch h {
+	case 2:
+		return 8
+	case 1:
+		if v >= 1 {
+			return 7
+		}
+		if d >= 1 {
+			return 6
+		}
+		return 5
+	default:
+		if v >= 1 {
+			return 2 + v
+		}
+		if d >= 2 {
+			return 2
+		}
+		return d
+	}
+}
+
+func (t *t1Decoder) contribution(i int) int {
+	f := t.flags[i]
+	if f&t1Significant == 0 {
+		return 0
+	}
+	if f&t1Negative != 0 {
+		return -1
+	}
+	return 1
+}
+
+func clampContribution(v int) int {
+	if v > 1 {
+		return 1
+	}
+	if v < -1 {
+		return -1
+	}
+	return v
+}
+
+func (t *t1Decoder) signContext(x, y int) (int, int) {
+	i := t.index(x, y)
+	s := t.stride
+
+	h := clampContribution(t.contribution(i-1) + t.contribution(i+1))
+	vs := t.contribution(i - s)
+	if !(t.style&cblkVerticalCausal != 0 && y%4 == 3) {
+		vs += t.contribution(i + s)
+	}
+	v := clampContribution(vs)
+
+	xor := 0
+	if h < 0 || (h == 0 && v < 0) {
+		xor = 1
+		h, v = -h, -v
+	}
+
+	switch {
+	case h == 1 && v == 1:
+		return 13, xor
+	case h == 1 && v == 0:
+		return 12, xor
+	case h == 1 && v == -1:
+		return 11, xor
+	case v == 1:
+		return 10, xor
+	}
+	return 9, xor
+}
+
+func (t *t1Decoder) decodeSign(x, y int, raw bool) {
+	var negative int
+	if raw {
+		negative = t.raw.decode()
+	} else {
+		ctx, xor := t.signContext(x, y)
+		negative = t.mq.decode(ctx) ^ xor
+	}
+
+	i := t.index(x, y)
+	t.flags[i] |= t1Significant
+	if negative == 1 {
+		t.flags[i] |= t1Negative
+	}
+}
+
+func (t *t1Decoder) significancePass(bitplane int, raw bool) {
+	for y0 := 0; y0 < t.height; y0 += 4 {
+		for x := 0; x < t.width; x++ {
+			for y := y0; y < y0+4 && y < t.height; y++ {
+				i := t.index(x, y)
+				if t.flags[i]&t1Significant != 0 {
+					continue
+				}
+				ctx := t.zeroContext(x, y)
+				if ctx == 0 {
+					continue
+				}
+
+				var bit int
+				if raw {
+					bit = t.raw.decode()
+				} else {
+					bit = t.mq.decode(ctxZeroCoding + ctx)
+				}
+				if bit == 1 {
+					t.magnitude[y*t.width+x] |= 1 << uint(bitplane)
+					t.decodeSign(x, y, raw)
+				}
+				t.flags[i] |= t1Visited
+			}
+		}
+	}
+}
+
+func (t *t1Decoder) refinementPass(bitplane int, raw bool) {
+	for y0 := 0; y0 < t.height; y0 += 4 {
+		for x := 0; x < t.width; x++ {
+			for y := y0; y < y0+4 && y < t.height; y++ {
+				i := t.index(x, y)
+				f := t.flags[i]
+				if f&t1Significant == 0 || f&t1Visited != 0 {
+					continue
+				}
+
+				var bit int
+				if raw {
+					bit = t.raw.decode()
+				} else {
+					ctx := ctxMagnitude + 2
+					if f&t1Refined == 0 {
+						h, v, d := t.neighbours(x, y)
+						if h+v+d > 0 {
+							ctx = ctxMagnitude + 1
+						} else {
+							ctx = ctxMagnitude
+						}
+					}
+					bit = t.mq.decode(ctx)
+				}
+				if bit == 1 {
+					t.magnitude[y*t.width+x] |= 1 << uint(bitplane)
+				}
+				t.flags[i] |= t1Refined
+			}
+		}
+	}
+}
+
+func (t *t1Decoder) cleanupPass(bitplane int) {
+	for y0 := 0; y0 < t.height; y0 += 4 {
+		for x := 0; x < t.width; x++ {
+			y := y0
+
+			if y0+4 <= t.height && t.runModeApplies(x, y0) {
+				if t.mq.decode(ctxRunLength) == 0 {
+					continue
+				}
+				r := t.mq.decode(ctxUniform)<<1 | t.mq.decode(ctxUniform)
+				y = y0 + r
+				t.magnitude[y*t.width+x] |= 1 << uint(bitplane)
+				t.decodeSign(x, y, false)
+				y++
+			}
+
+			for ; y < y0+4 && y < t.height; y++ {
+				i := t.index(x, y)
+				if t.flags[i]&(t1Significant|t1Visited) != 0 {
+					continue
+				}
+				if t.mq.decode(ctxZeroCoding+t.zeroContext(x, y)) == 1 {
+					t.magnitude[y*t.width+x] |= 1 << uint(bitplane)
+					t.decodeSign(x, y, false)
+				}
+			}
+		}
+	}
+
+	for i := range t.flags {
+		t.flags[i] &^= t1Visited
+	}
+}
+
+// runModeApplies reports whether a full stripe column can be coded in run-length mode.
+func (t *t1Decoder) runModeApplies(x, y0 int) bool {
+	for y := y0; y < y0+4; y++ {
+		if t.flags[t.index(x, y)]&(t1Significant|t1Visited) != 0 {
+			return false
+		}
+		if h, v, d := t.neighbours(x, y); h+v+d != 0 {
+			return false
+		}
+	}
+	return true
+}
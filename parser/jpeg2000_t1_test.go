@@ -0,0 +1,429 @@
+package parser
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// mqEncoder is the MQ arithmetic encoder of ITU-T T.800 C.2, using the
+// state table of the decoder.
+type mqEncoder struct {
+	out      []byte // out[0] is the byte before the codeword, which is dropped
+	a, c     uint32
+	ct       int
+	contexts [numMQContexts]mqContext
+}
+
+func newMQEncoder() *mqEncoder {
+	return &mqEncoder{out: []byte{0}, a: 0x8000, ct: 12}
+}
+
+func (e *mqEncoder) encode(cx, d int) {
+	ctx := &e.contexts[cx]
+	state := mqStates[ctx.state]
+	qe := state.qe
+
+	e.a -= qe
+	if uint8(d) == ctx.mps {
+		if e.a&0x8000 != 0 {
+			e.c += qe
+			return
+		}
+		if e.a < qe {
+			e.a = qe
+		} else {
+			e.c += qe
+		}
+		ctx.state = state.nmps
+	} else {
+		if e.a < qe {
+			e.c += qe
+		} else {
+			e.a = qe
+		}
+		if state.switchMPS {
+			ctx.mps = 1 - ctx.mps
+		}
+		ctx.state = state.nlps
+	}
+	e.renormalize()
+}
+
+func (e *mqEncoder) renormalize() {
+	for {
+		e.a <<= 1
+		e.c <<= 1
+		e.ct--
+		if e.ct == 0 {
+			e.byteOut()
+		}
+		if e.a&0x8000 != 0 {
+			return
+		}
+	}
+}
+
+func (e *mqEncoder) byteOut() {
+	b := &e.out[len(e.out)-1]
+	if *b != 0xFF && e.c >= 0x8000000 {
+		*b++
+		e.c &= 0x7FFFFFF
+	}
+	if *b == 0xFF {
+		e.out = append(e.out, byte(e.c>>20))
+		e.c &= 0xFFFFF
+		e.ct = 7
+	} else {
+		e.out = append(e.out, byte(e.c>>19))
+		e.c &= 0x7FFFF
+		e.ct = 8
+	}
+}
+
+// flush terminates the codeword and returns it.
+func (e *mqEncoder) flush() []byte {
+	temp := e.c + e.a
+	e.c |= 0xFFFF
+	if e.c >= temp {
+		e.c -= 0x8000
+	}
+	e.c <<= uint(e.ct)
+	e.byteOut()
+	e.c <<= uint(e.ct)
+	e.byteOut()
+
+	out := e.out[1:]
+	if out[len(out)-1] == 0xFF {
+		out = out[:len(out)-1]
+	}
+	return out
+}
+
+// Context labels of ITU-T T.800 tables D.1 to D.4, written out
+// independently of the decoder.
+
+func testZeroContext(h, v, d, orientation int) int {
+	if orientation == bandHH {
+		hv := h + v
+		switch {
+		case d >= 3:
+			return 8
+		case d == 2 && hv >= 1:
+			return 7
+		case d == 2:
+			return 6
+		case d == 1 && hv >= 2:
+			return 5
+		case d == 1 && hv == 1:
+			return 4
+		case d == 1:
+			return 3
+		case hv >= 2:
+			return 2
+		}
+		return hv
+	}
+
+	if orientation == bandHL {
+		h, v = v, h
+	}
+	switch {
+	case h == 2:
+		return 8
+	case h == 1 && v >= 1:
+		return 7
+	case h == 1 && d >= 1:
+		return 6
+	case h == 1:
+		return 5
+	case v == 2:
+		return 4
+	case v == 1:
+		return 3
+	case d >= 2:
+		return 2
+	}
+	return d
+}
+
+// testSignContexts maps the horizontal and vertical contributions, each
+// plus one, to the context label and the XOR bit.
+var testSignContexts = [3][3][2]int{
+	{{13, 1}, {12, 1}, {11, 1}},
+	{{10, 1}, {9, 0}, {10, 0}},
+	{{11, 0}, {12, 0}, {13, 0}},
+}
+
+// t1Encoder codes the coefficients of a code-block in the coding passes
+// read by decodeCodeBlock, with the default code-block style.
+type t1Encoder struct {
+	w, h        int
+	coeff       []int64
+	significant []bool
+	visited     []bool
+	refined     []bool
+	orientation int
+	mq          *mqEncoder
+}
+
+func (t *t1Encoder) sig(x, y int) int {
+	if x < 0 || y < 0 || x >= t.w || y >= t.h || !t.significant[y*t.w+x] {
+		return 0
+	}
+	return 1
+}
+
+// signOf returns the contribution of a neighbour to the sign context.
+func (t *t1Encoder) signOf(x, y int) int {
+	if t.sig(x, y) == 0 {
+		return 0
+	}
+	if t.coeff[y*t.w+x] < 0 {
+		return -1
+	}
+	return 1
+}
+
+func (t *t1Encoder) neighbourhood(x, y int) (h, v, d int) {
+	h = t.sig(x-1, y) + t.sig(x+1, y)
+	v = t.sig(x, y-1) + t.sig(x, y+1)
+	d = t.sig(x-1, y-1) + t.sig(x+1, y-1) + t.sig(x-1, y+1) + t.sig(x+1, y+1)
+	return h, v, d
+}
+
+func (t *t1Encoder) zeroContext(x, y int) int {
+	h, v, d := t.neighbourhood(x, y)
+	return testZeroContext(h, v, d, t.orientation)
+}
+
+func (t *t1Encoder) bit(x, y, bitplane int) int {
+	c := t.coeff[y*t.w+x]
+	if c < 0 {
+		c = -c
+	}
+	return int(c>>uint(bitplane)) & 1
+}
+
+// becomeSignificant codes the sign of a sample that has just become significant.
+func (t *t1Encoder) becomeSignificant(x, y int) {
+	h := max(-1, min(1, t.signOf(x-1, y)+t.signOf(x+1, y)))
+	v := max(-1, min(1, t.signOf(x, y-1)+t.signOf(x, y+1)))
+	ctx := testSignContexts[h+1][v+1]
+
+	negative := 0
+	if t.coeff[y*t.w+x] < 0 {
+		negative = 1
+	}
+	t.mq.encode(ctx[0], negative^ctx[1])
+	t.significant[y*t.w+x] = true
+}
+
+func (t *t1Encoder) significancePass(bitplane int) {
+	for y0 := 0; y0 < t.h; y0 += 4 {
+		for x := 0; x < t.w; x++ {
+			for y := y0; y < min(y0+4, t.h); y++ {
+				if t.significant[y*t.w+x] {
+					continue
+				}
+				ctx := t.zeroContext(x, y)
+				if ctx == 0 {
+					continue
+				}
+				bit := t.bit(x, y, bitplane)
+				t.mq.encode(ctx, bit)
+				if bit == 1 {
+					t.becomeSignificant(x, y)
+				}
+				t.visited[y*t.w+x] = true
+			}
+		}
+	}
+}
+
+func (t *t1Encoder) refinementPass(bitplane int) {
+	for y0 := 0; y0 < t.h; y0 += 4 {
+		for x := 0; x < t.w; x++ {
+			for y := y0; y < min(y0+4, t.h); y++ {
+				i := y*t.w + x
+				if !t.significant[i] || t.visited[i] {
+					continue
+				}
+				ctx := 16
+				if !t.refined[i] {
+					ctx = 14
+					if h, v, d := t.neighbourhood(x, y); h+v+d > 0 {
+						ctx = 15
+					}
+				}
+				t.mq.encode(ctx, t.bit(x, y, bitplane))
+				t.refined[i] = true
+			}
+		}
+	}
+}
+
+func (t *t1Encoder) cleanupPass(bitplane int) {
+	for y0 := 0; y0 < t.h; y0 += 4 {
+		for x := 0; x < t.w; x++ {
+			y := y0
+
+			run := y0+4 <= t.h
+			for k := y0; run && k < y0+4; k++ {
+				run = !t.significant[k*t.w+x] && !t.visited[k*t.w+x] && t.zeroContext(x, k) == 0
+			}
+			if run {
+				r := 0
+				for r < 4 && t.bit(x, y0+r, bitplane) == 0 {
+					r++
+				}
+				if r == 4 {
+					t.mq.encode(ctxRunLength, 0)
+					continue
+				}
+				t.mq.encode(ctxRunLength, 1)
+				t.mq.encode(ctxUniform, r>>1)
+				t.mq.encode(ctxUniform, r&1)
+				y = y0 + r
+				t.becomeSignificant(x, y)
+				y++
+			}
+
+			for ; y < min(y0+4, t.h); y++ {
+				i := y*t.w + x
+				if t.significant[i] || t.visited[i] {
+					continue
+				}
+				bit := t.bit(x, y, bitplane)
+				t.mq.encode(t.zeroContext(x, y), bit)
+				if bit == 1 {
+					t.becomeSignificant(x, y)
+				}
+			}
+		}
+	}
+
+	for i := range t.visited {
+		t.visited[i] = false
+	}
+}
+
+// encodeCodeBlock codes the signed coefficients of a w x h code-block in a
+// single codeword segment. It returns the codeword, the number of
+// magnitude bit-planes and the number of coding passes, which are zero for
+// a code-block of zeros.
+func encodeCodeBlock(coeff []int64, w, h, orientation int) ([]byte, int, int) {
+	var largest int64
+	for _, c := range coeff {
+		largest = max(largest, c, -c)
+	}
+	numBitplanes := bitsFor(largest)
+	if numBitplanes == 0 {
+		return nil, 0, 0
+	}
+
+	t := &t1Encoder{
+		w: w, h: h,
+		coeff:       coeff,
+		significant: make([]bool, w*h),
+		visited:     make([]bool, w*h),
+		refined:     make([]bool, w*h),
+		orientation: orientation,
+		mq:          newMQEncoder(),
+	}
+	t.mq.contexts[ctxZeroCoding].state = mqZeroState
+	t.mq.contexts[ctxRunLength].state = mqRunState
+	t.mq.contexts[ctxUniform].state = mqUniformState
+
+	t.cleanupPass(numBitplanes - 1)
+	for bitplane := numBitplanes - 2; bitplane >= 0; bitplane-- {
+		t.significancePass(bitplane)
+		t.refinementPass(bitplane)
+		t.cleanupPass(bitplane)
+	}
+	return t.mq.flush(), numBitplanes, 3*numBitplanes - 2
+}
+
+func TestMQDecoderReference(t *testing.T) {
+	// The test sequence of ITU-T T.88 H.2, coded with a single context
+	// that starts in state 0 with MPS 0. JBIG2 and JPEG 2000 share the
+	// MQ coder.
+	input := []byte{
+		0x00, 0x02, 0x00, 0x51, 0x00, 0x00, 0x00, 0xC0, 0x03, 0x52, 0x87, 0x2A, 0xAA, 0xAA, 0xAA, 0xAA,
+		0x82, 0xC0, 0x20, 0x00, 0xFC, 0xD7, 0x9E, 0xF6, 0xBF, 0x7F, 0xED, 0x90, 0x4F, 0x46, 0xA3, 0xBF,
+	}
+	coded := []byte{
+		0x84, 0xC7, 0x3B, 0xFC, 0xE1, 0xA1, 0x43, 0x04, 0x02, 0x20, 0x00, 0x00, 0x41, 0x0D, 0xBB, 0x86,
+		0xF4, 0x31, 0x7F, 0xFF, 0x88, 0xFF, 0x37, 0x47, 0x1A, 0xDB, 0x6A, 0xDF, 0xFF, 0xAC,
+	}
+
+	var m mqDecoder
+	m.init(coded)
+	for i, b := range input {
+		var got byte
+		for bit := 0; bit < 8; bit++ {
+			got = got<<1 | byte(m.decode(0))
+		}
+		if got != b {
+			t.Fatalf("byte %d = %#02x, want %#02x", i, got, b)
+		}
+	}
+
+	// The test encoder must produce the same codeword, up to the marker
+	// that terminates it in JBIG2.
+	e := newMQEncoder()
+	for _, b := range input {
+		for bit := 7; bit >= 0; bit-- {
+			e.encode(0, int(b>>uint(bit))&1)
+		}
+	}
+	got := e.flush()
+	if want := coded[:len(coded)-2]; string(got) != string(want) {
+		t.Errorf("encoded % X, want % X", got, want)
+	}
+}
+
+// testCoefficients returns w*h coefficients of up to the given number of
+// bits, with a share of zeros that makes the cleanup pass use run mode.
+func testCoefficients(rng *rand.Rand, w, h, bits int, zeros float64) []int64 {
+	coeff := make([]int64, w*h)
+	for i := range coeff {
+		if rng.Float64() < zeros {
+			continue
+		}
+		coeff[i] = rng.Int63n(1 << uint(bits))
+		if rng.Intn(2) == 0 {
+			coeff[i] = -coeff[i]
+		}
+	}
+	return coeff
+}
+
+func TestDecodeCodeBlock(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	sizes := [][2]int{{1, 1}, {5, 3}, {3, 5}, {13, 9}, {32, 4}, {64, 64}}
+	for _, size := range sizes {
+		w, h := size[0], size[1]
+		for orientation := bandLL; orientation <= bandHH; orientation++ {
+			for _, zeros := range []float64{0, 0.9, 0.995} {
+				coeff := testCoefficients(rng, w, h, 12, zeros)
+				data, numBitplanes, passes := encodeCodeBlock(coeff, w, h, orientation)
+				if passes == 0 {
+					continue
+				}
+
+				cb := &j2kCodeBlock{x1: w, y1: h, numPasses: passes,
+					segments: []*j2kSegment{{data: data, passes: passes, maxPasses: 109}}}
+				got, lowest := decodeCodeBlock(cb, orientation, 0, numBitplanes)
+				if lowest != 0 {
+					t.Errorf("%dx%d orientation %d: lowest bit-plane %d, want 0", w, h, orientation, lowest)
+				}
+				for i := range coeff {
+					if got[i] != coeff[i] {
+						t.Fatalf("%dx%d orientation %d with %g zeros: coefficient %d = %d, want %d",
+							w, h, orientation, zeros, i, got[i], coeff[i])
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,560 @@
+package parser
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// j2kEncoder writes lossless JPEG 2000 codestreams of a single unsigned
+// component: reversible 5/3 wavelet, one quality layer, LRCP progression,
+// default precincts and code-block style. The geometry follows ITU-T T.800
+// and is computed independently of the decoder.
+type j2kEncoder struct {
+	x0, y0, x1, y1 int // image area on the reference grid
+	tileW, tileH   int // tiles start at the origin of the reference grid
+	levels         int
+	cbw, cbh       int // code-block size exponents
+	precision      int
+}
+
+const j2kGuardBits = 2
+
+// bandGain returns log2 of the nominal gain of a sub-band.
+func bandGain(orientation int) int {
+	switch orientation {
+	case bandHL, bandLH:
+		return 1
+	case bandHH:
+		return 2
+	}
+	return 0
+}
+
+func (e *j2kEncoder) encode(t *testing.T, samples []int64) []byte {
+	t.Helper()
+	var cs []byte
+	marker := func(m int, body []byte) {
+		cs = binary.BigEndian.AppendUint16(cs, uint16(m))
+		cs = binary.BigEndian.AppendUint16(cs, uint16(2+len(body)))
+		cs = append(cs, body...)
+	}
+
+	cs = binary.BigEndian.AppendUint16(cs, markerSOC)
+	siz := binary.BigEndian.AppendUint16(nil, 0)
+	for _, v := range []int{e.x1, e.y1, e.x0, e.y0, e.tileW, e.tileH, 0, 0} {
+		siz = binary.BigEndian.AppendUint32(siz, uint32(v))
+	}
+	siz = binary.BigEndian.AppendUint16(siz, 1)
+	siz = append(siz, byte(e.precision-1), 1, 1)
+	marker(markerSIZ, siz)
+	marker(markerCOD, []byte{0, progressionLRCP, 0, 1, 0, byte(e.levels), byte(e.cbw - 2), byte(e.cbh - 2), 0, 1})
+	qcd := []byte{j2kGuardBits << 5}
+	for b := 0; b < 3*e.levels+1; b++ {
+		orientation := bandLL
+		if b > 0 {
+			orientation = (b-1)%3 + 1
+		}
+		qcd = append(qcd, byte(e.precision+bandGain(orientation))<<3)
+	}
+	marker(markerQCD, qcd)
+
+	tilesX, tilesY := ceilDiv(e.x1, e.tileW), ceilDiv(e.y1, e.tileH)
+	index := 0
+	for q := 0; q < tilesY; q++ {
+		for p := 0; p < tilesX; p++ {
+			tx0, ty0 := max(p*e.tileW, e.x0), max(q*e.tileH, e.y0)
+			tx1, ty1 := min((p+1)*e.tileW, e.x1), min((q+1)*e.tileH, e.y1)
+			if tx0 >= tx1 || ty0 >= ty1 {
+				index++
+				continue
+			}
+
+			tile := make([]int64, 0, (tx1-tx0)*(ty1-ty0))
+			for y := ty0; y < ty1; y++ {
+				for x := tx0; x < tx1; x++ {
+					tile = append(tile, samples[(y-e.y0)*(e.x1-e.x0)+x-e.x0]-1<<uint(e.precision-1))
+				}
+			}
+			data := e.encodeTile(t, tile, tx0, ty0, tx1, ty1)
+
+			sot := binary.BigEndian.AppendUint16(nil, uint16(index))
+			sot = binary.BigEndian.AppendUint32(sot, uint32(12+2+len(data)))
+			sot = append(sot, 0, 1)
+			marker(markerSOT, sot)
+			cs = binary.BigEndian.AppendUint16(cs, markerSOD)
+			cs = append(cs, data...)
+			index++
+		}
+	}
+	return binary.BigEndian.AppendUint16(cs, markerEOC)
+}
+
+// testBand is a sub-band of a tile with its coefficients.
+type testBand struct {
+	orientation    int
+	x0, y0, x1, y1 int
+	coeff          []int64
+}
+
+func (b *testBand) empty() bool {
+	return b.x1 <= b.x0 || b.y1 <= b.y0
+}
+
+// newTestBand returns the sub-band of a tile at a decomposition level (T.800 B-15).
+func newTestBand(orientation, level, tx0, ty0, tx1, ty1 int) *testBand {
+	xob, yob := 0, 0
+	if orientation == bandHL || orientation == bandHH {
+		xob = 1
+	}
+	if orientation == bandLH || orientation == bandHH {
+		yob = 1
+	}
+	scale := 1 << uint(level)
+	half := scale / 2
+	b := &testBand{
+		orientation: orientation,
+		x0:          ceilDiv(tx0-half*xob, scale),
+		y0:          ceilDiv(ty0-half*yob, scale),
+		x1:          ceilDiv(tx1-half*xob, scale),
+		y1:          ceilDiv(ty1-half*yob, scale),
+	}
+	if !b.empty() {
+		b.coeff = make([]int64, (b.x1-b.x0)*(b.y1-b.y0))
+	}
+	return b
+}
+
+func (b *testBand) set(x, y int, v int64) {
+	b.coeff[(y-b.y0)*(b.x1-b.x0)+x-b.x0] = v
+}
+
+// analyze performs the one-dimensional forward 5/3 transform of a line
+// whose first sample has absolute coordinate i0 (T.800 F.4.8.2).
+func analyze(x []int64, i0 int) {
+	n := len(x)
+	if n == 1 {
+		if i0%2 != 0 {
+			x[0] *= 2
+		}
+		return
+	}
+
+	period := 2 * (n - 1)
+	at := func(i int) int64 {
+		k := ((i-i0)%period + period) % period
+		if k >= n {
+			k = period - k
+		}
+		return x[k]
+	}
+	for i := i0; i < i0+n; i++ {
+		if i%2 != 0 {
+			x[i-i0] -= (at(i-1) + at(i+1)) >> 1
+		}
+	}
+	for i := i0; i < i0+n; i++ {
+		if i%2 == 0 {
+			x[i-i0] += (at(i-1) + at(i+1) + 2) >> 2
+		}
+	}
+}
+
+// decompose returns the sub-bands of a tile by resolution, the LL band
+// first, after the forward wavelet transform of the samples.
+func (e *j2kEncoder) decompose(samples []int64, tx0, ty0, tx1, ty1 int) [][]*testBand {
+	resolutions := make([][]*testBand, e.levels+1)
+	image := samples
+	for level := 1; level <= e.levels; level++ {
+		scale := 1 << uint(level-1)
+		u0, v0 := ceilDiv(tx0, scale), ceilDiv(ty0, scale)
+		u1, v1 := ceilDiv(tx1, scale), ceilDiv(ty1, scale)
+		w, h := u1-u0, v1-v0
+
+		// Columns first, as the decoder synthesizes rows first.
+		if w > 0 && h > 0 {
+			line := make([]int64, h)
+			for x := 0; x < w; x++ {
+				for y := range line {
+					line[y] = image[y*w+x]
+				}
+				analyze(line, v0)
+				for y := range line {
+					image[y*w+x] = line[y]
+				}
+			}
+			for y := 0; y < h; y++ {
+				analyze(image[y*w:(y+1)*w], u0)
+			}
+		}
+
+		bands := make([]*testBand, 4)
+		for orientation := range bands {
+			bands[orientation] = newTestBand(orientation, level, tx0, ty0, tx1, ty1)
+		}
+		for v := v0; v < v1; v++ {
+			for u := u0; u < u1; u++ {
+				bands[u%2+2*(v%2)].set(floorDiv(u, 2), floorDiv(v, 2), image[(v-v0)*w+u-u0])
+			}
+		}
+		resolutions[e.levels-level+1] = bands[1:]
+		image = bands[0].coeff
+	}
+
+	ll := newTestBand(bandLL, e.levels, tx0, ty0, tx1, ty1)
+	ll.coeff = image
+	resolutions[0] = []*testBand{ll}
+	return resolutions
+}
+
+// testCodeBlock is a coded code-block and its place in the packet header.
+type testCodeBlock struct {
+	data          []byte
+	passes        int
+	zeroBitplanes int
+}
+
+func (e *j2kEncoder) encodeTile(t *testing.T, samples []int64, tx0, ty0, tx1, ty1 int) []byte {
+	t.Helper()
+	var data []byte
+	for r, bands := range e.decompose(samples, tx0, ty0, tx1, ty1) {
+		scale := 1 << uint(e.levels-r)
+		if ceilDiv(tx1, scale) <= ceilDiv(tx0, scale) || ceilDiv(ty1, scale) <= ceilDiv(ty0, scale) {
+			continue // no packets for an empty resolution
+		}
+
+		type bandBlocks struct {
+			band       *testBand
+			blocks     []*testCodeBlock
+			ncbx, ncby int
+		}
+		var coded []bandBlocks
+		included := false
+		for _, band := range bands {
+			if band.empty() {
+				continue
+			}
+			blocks, ncbx, ncby := e.codeBlocks(band)
+			for _, cb := range blocks {
+				included = included || cb.passes > 0
+			}
+			coded = append(coded, bandBlocks{band, blocks, ncbx, ncby})
+		}
+
+		header := &testPacketWriter{}
+		var body []byte
+		if !included {
+			header.bit(0) // empty packet
+			coded = nil
+		} else {
+			header.bit(1)
+		}
+		for _, c := range coded {
+			numBitplanes := j2kGuardBits + e.precision + bandGain(c.band.orientation) - 1
+
+			inclusion := newTestTagTree(c.ncbx, c.ncby)
+			zeroBitplanes := newTestTagTree(c.ncbx, c.ncby)
+			for i, cb := range c.blocks {
+				if cb.passes == 0 {
+					inclusion.setValue(i, 1)
+					continue
+				}
+				if cb.zeroBitplanes = numBitplanes - (cb.passes+2)/3; cb.zeroBitplanes < 0 {
+					t.Fatalf("code-block of %d bit-planes in a sub-band of %d", (cb.passes+2)/3, numBitplanes)
+				}
+				inclusion.setValue(i, 0)
+				zeroBitplanes.setValue(i, cb.zeroBitplanes)
+			}
+
+			for i, cb := range c.blocks {
+				inclusion.encode(header, i, 1)
+				if cb.passes == 0 {
+					continue
+				}
+				zeroBitplanes.encode(header, i, cb.zeroBitplanes+1)
+				header.passCount(cb.passes)
+
+				lblock := 3
+				for len(cb.data) >= 1<<uint(lblock+floorLog2(cb.passes)) {
+					header.bit(1)
+					lblock++
+				}
+				header.bit(0)
+				header.bits(len(cb.data), lblock+floorLog2(cb.passes))
+				body = append(body, cb.data...)
+			}
+		}
+		data = append(data, header.flush()...)
+		data = append(data, body...)
+	}
+	return data
+}
+
+// codeBlocks codes the code-blocks of a sub-band, which has a single
+// precinct, in raster order.
+func (e *j2kEncoder) codeBlocks(band *testBand) ([]*testCodeBlock, int, int) {
+	cbw, cbh := 1<<uint(e.cbw), 1<<uint(e.cbh)
+	i0, j0 := floorDiv(band.x0, cbw), floorDiv(band.y0, cbh)
+	ncbx, ncby := ceilDiv(band.x1, cbw)-i0, ceilDiv(band.y1, cbh)-j0
+
+	var blocks []*testCodeBlock
+	for j := j0; j < j0+ncby; j++ {
+		for i := i0; i < i0+ncbx; i++ {
+			x0, y0 := max(i*cbw, band.x0), max(j*cbh, band.y0)
+			x1, y1 := min((i+1)*cbw, band.x1), min((j+1)*cbh, band.y1)
+			coeff := make([]int64, 0, (x1-x0)*(y1-y0))
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					coeff = append(coeff, band.coeff[(y-band.y0)*(band.x1-band.x0)+x-band.x0])
+				}
+			}
+			data, _, passes := encodeCodeBlock(coeff, x1-x0, y1-y0, band.orientation)
+			blocks = append(blocks, &testCodeBlock{data: data, passes: passes})
+		}
+	}
+	return blocks, ncbx, ncby
+}
+
+// testPacketWriter writes packet header bits, stuffing a zero bit after
+// every 0xFF byte.
+type testPacketWriter struct {
+	buf  []byte
+	cur  byte
+	n    int
+	size int
+}
+
+func (w *testPacketWriter) bit(b int) {
+	if w.n == 0 {
+		w.size = 8
+		if len(w.buf) > 0 && w.buf[len(w.buf)-1] == 0xFF {
+			w.size = 7
+		}
+	}
+	w.cur = w.cur<<1 | byte(b)
+	w.n++
+	if w.n == w.size {
+		w.buf = append(w.buf, w.cur)
+		w.cur, w.n = 0, 0
+	}
+}
+
+func (w *testPacketWriter) bits(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.bit(v >> uint(i) & 1)
+	}
+}
+
+// passCount writes the number of coding passes (T.800 table B.4).
+func (w *testPacketWriter) passCount(n int) {
+	switch {
+	case n == 1:
+		w.bit(0)
+	case n == 2:
+		w.bits(2, 2)
+	case n <= 5:
+		w.bits(3, 2)
+		w.bits(n-3, 2)
+	case n <= 36:
+		w.bits(15, 4)
+		w.bits(n-6, 5)
+	default:
+		w.bits(511, 9)
+		w.bits(n-37, 7)
+	}
+}
+
+func (w *testPacketWriter) flush() []byte {
+	if w.n > 0 {
+		w.buf = append(w.buf, w.cur<<uint(w.size-w.n))
+	}
+	if w.buf[len(w.buf)-1] == 0xFF {
+		w.buf = append(w.buf, 0)
+	}
+	return w.buf
+}
+
+// testTagTree is a tag tree encoder (T.800 B.10.2).
+type testTagTree struct {
+	value, low []int
+	known      []bool
+	parent     []int
+}
+
+func newTestTagTree(w, h int) *testTagTree {
+	t := &testTagTree{}
+	offset := 0
+	for {
+		next := offset + w*h
+		pw, ph := (w+1)/2, (h+1)/2
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				parent := -1
+				if w*h > 1 {
+					parent = next + y/2*pw + x/2
+				}
+				t.parent = append(t.parent, parent)
+			}
+		}
+		if w*h == 1 {
+			break
+		}
+		offset, w, h = next, pw, ph
+	}
+	t.value = make([]int, len(t.parent))
+	t.low = make([]int, len(t.parent))
+	t.known = make([]bool, len(t.parent))
+	for i := range t.value {
+		t.value[i] = math.MaxInt32
+	}
+	return t
+}
+
+// setValue sets the value of a leaf, which bounds the values of its ancestors.
+func (t *testTagTree) setValue(leaf, v int) {
+	for n := leaf; n >= 0 && v < t.value[n]; n = t.parent[n] {
+		t.value[n] = v
+	}
+}
+
+// encode writes the bits that tell whether the value of a leaf is below threshold.
+func (t *testTagTree) encode(w *testPacketWriter, leaf, threshold int) {
+	var path []int
+	for n := leaf; n >= 0; n = t.parent[n] {
+		path = append(path, n)
+	}
+
+	low := 0
+	for i := len(path) - 1; i >= 0; i-- {
+		n := path[i]
+		if low > t.low[n] {
+			t.low[n] = low
+		} else {
+			low = t.low[n]
+		}
+		for low < threshold {
+			if low >= t.value[n] {
+				if !t.known[n] {
+					w.bit(1)
+					t.known[n] = true
+				}
+				break
+			}
+			w.bit(0)
+			low++
+		}
+		t.low[n] = low
+	}
+}
+
+// testImage returns the samples of a w x h image of the given precision: a
+// flat area, a smooth gradient and a noisy area.
+func testImage(w, h, precision int, seed int64) []int64 {
+	rng := rand.New(rand.NewSource(seed))
+	maxValue := int64(1)<<uint(precision) - 1
+	samples := make([]int64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var v float64
+			switch {
+			case x < w/3:
+				v = 0.4
+			case y < h/2:
+				v = 0.5 + 0.4*math.Sin(float64(x)/5)*math.Cos(float64(y)/3)
+			default:
+				v = rng.Float64()
+			}
+			samples[y*w+x] = min64(maxValue, int64(v*float64(maxValue+1)))
+		}
+	}
+	return samples
+}
+
+func TestDecodeJPEG2000(t *testing.T) {
+	tests := []struct {
+		name string
+		j2kEncoder
+	}{
+		{"1x1", j2kEncoder{x1: 1, y1: 1, tileW: 1, tileH: 1, levels: 0, cbw: 6, cbh: 6, precision: 8}},
+		{"1x1 decomposed", j2kEncoder{x1: 1, y1: 1, tileW: 1, tileH: 1, levels: 2, cbw: 6, cbh: 6, precision: 8}},
+		{"1x7", j2kEncoder{x1: 1, y1: 7, tileW: 1, tileH: 7, levels: 3, cbw: 2, cbh: 2, precision: 12}},
+		{"7x1", j2kEncoder{x1: 7, y1: 1, tileW: 7, tileH: 1, levels: 3, cbw: 2, cbh: 2, precision: 12}},
+		{"5x3", j2kEncoder{x1: 5, y1: 3, tileW: 5, tileH: 3, levels: 5, cbw: 2, cbh: 2, precision: 12}},
+		{"37x29 small code-blocks", j2kEncoder{x1: 37, y1: 29, tileW: 37, tileH: 29, levels: 3, cbw: 2, cbh: 2, precision: 12}},
+		{"37x29 rectangular code-blocks", j2kEncoder{x1: 37, y1: 29, tileW: 37, tileH: 29, levels: 2, cbw: 5, cbh: 2, precision: 16}},
+		{"37x29 offset tiles", j2kEncoder{x0: 3, y0: 1, x1: 40, y1: 30, tileW: 16, tileH: 16, levels: 2, cbw: 3, cbh: 3, precision: 12}},
+		{"64x64 16-bit", j2kEncoder{x1: 64, y1: 64, tileW: 64, tileH: 64, levels: 5, cbw: 6, cbh: 6, precision: 16}},
+		{"64x64 code-blocks of 16x16", j2kEncoder{x1: 64, y1: 64, tileW: 64, tileH: 64, levels: 4, cbw: 4, cbh: 4, precision: 12}},
+		{"101x67 no decomposition", j2kEncoder{x1: 101, y1: 67, tileW: 101, tileH: 67, levels: 0, cbw: 5, cbh: 5, precision: 10}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, h := tt.x1-tt.x0, tt.y1-tt.y0
+			samples := testImage(w, h, tt.precision, int64(i))
+			got, width, err := decodeJPEG2000(tt.encode(t, samples))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if width != w {
+				t.Errorf("width %d, want %d", width, w)
+			}
+			checkSamples(t, got, samples)
+		})
+	}
+}
+
+func TestDecodeJPEG2000Empty(t *testing.T) {
+	// A 9x6 image of 12 bits with one decomposition level, whose two
+	// packets are empty, decodes to the DC level shift of 2^11.
+	cs := []byte{
+		0xFF, 0x4F, // SOC
+		0xFF, 0x51, 0x00, 0x29, 0x00, 0x00, // SIZ
+		0x00, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0x06, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0x06, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x01, 0x0B, 0x01, 0x01,
+		0xFF, 0x52, 0x00, 0x0C, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 0x04, 0x04, 0x00, 0x01, // COD
+		0xFF, 0x5C, 0x00, 0x07, 0x40, 0x60, 0x68, 0x68, 0x70, // QCD
+		0xFF, 0x90, 0x00, 0x0A, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x01, // SOT
+		0xFF, 0x93, // SOD
+		0x00, 0x00, // packets
+		0xFF, 0xD9, // EOC
+	}
+	samples := make([]int64, 9*6)
+	for i := range samples {
+		samples[i] = 2048
+	}
+
+	e := j2kEncoder{x1: 9, y1: 6, tileW: 9, tileH: 6, levels: 1, cbw: 6, cbh: 6, precision: 12}
+	if got := e.encode(t, samples); string(got) != string(cs) {
+		t.Errorf("encoded % X", got)
+	}
+
+	got, width, err := decodeJPEG2000(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if width != 9 {
+		t.Errorf("width %d, want 9", width)
+	}
+	checkSamples(t, got, samples)
+}
+
+func TestNativeJPEG2000(t *testing.T) {
+	grid := testGrid{nx: 37, ny: 29, la1: 60, lo1: -10, la2: 32, lo2: 26}
+	samples := testImage(grid.nx, grid.ny, 14, 1)
+	sec5, data := packJPEG2000(t, grid, samples, 14, 2000, -3, 1)
+	g, err := decodeNative(testMessage(grid, sec5, nil, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValues(t, g.DataValues, unpacked(samples, nil, 2000, -3, 1))
+}
+
+// packJPEG2000 packs integers with JPEG 2000 packing (template 5.40) in
+// tiles of 16x16 points.
+func packJPEG2000(t *testing.T, grid testGrid, samples []int64, bits int, reference float32, binaryScale, decimalScale int) (sec5, data []byte) {
+	e := j2kEncoder{x1: grid.nx, y1: grid.ny, tileW: 16, tileH: 16, levels: 3, cbw: 3, cbh: 3, precision: bits}
+	data = e.encode(t, samples)
+	return testDataRepresentation(40, len(samples), reference, binaryScale, decimalScale, bits, 0, 255), data
+}
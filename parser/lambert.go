@@ -0,0 +1,56 @@
+package parser
+
+import "math"
+
+// lambertConformal is the Lambert conformal conic projection of grid
+// definition template 3.30 (Snyder, Map Projections, chapter 15).
+type lambertConformal struct {
+	a, e float64
+	n    float64 // cone constant
+	af   float64 // a * F
+	lov  float64 // central meridian, radians
+}
+
+func newLambertConformal(h GribHeader) lambertConformal {
+	a, e := h.earth()
+	phi1 := radians(h.Latin1)
+	phi2 := radians(h.Latin2)
+
+	m1, t1 := conformalM(phi1, e), conformalT(phi1, e)
+	n := math.Sin(phi1)
+	if math.Abs(phi1-phi2) > 1e-10 {
+		m2, t2 := conformalM(phi2, e), conformalT(phi2, e)
+		n = (math.Log(m1) - math.Log(m2)) / (math.Log(t1) - math.Log(t2))
+	}
+
+	return lambertConformal{
+		a:   a,
+		e:   e,
+		n:   n,
+		af:  a * m1 / (n * math.Pow(t1, n)),
+		lov: radians(h.LoV),
+	}
+}
+
+// forward returns the projected coordinates of a point, with the apex of
+// the cone at the origin.
+func (p lambertConformal) forward(lat, lon float64) (float64, float64) {
+	rho := p.af * math.Pow(conformalT(radians(lat), p.e), p.n)
+	theta := p.n * radians(normalizeLongitude(lon-degrees(p.lov)))
+	return rho * math.Sin(theta), -rho * math.Cos(theta)
+}
+
+func (p lambertConformal) inverse(x, y float64) (float64, float64) {
+	sign := 1.0
+	if p.n < 0 {
+		sign = -1
+	}
+
+	rho := sign * math.Hypot(x, y)
+	theta := math.Atan2(sign*x, -sign*y)
+	t := math.Pow(rho/p.af, 1/p.n)
+
+	lat := degrees(latitudeFromT(t, p.e))
+	lon := normalizeLongitude(degrees(theta/p.n + p.lov))
+	return lat, lon
+}
@@ -0,0 +1,125 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	gribMagic = []byte("GRIB")
+	endMagic  = []byte("7777")
+)
+
+// SplitMessages returns the individual GRIB messages contained in data, in
+// file order. Bytes between messages and truncated trailing messages are skipped.
+func SplitMessages(data []byte) [][]byte {
+	var messages [][]byte
+
+	offset := 0
+	for offset < len(data) {
+		start := bytes.Index(data[offset:], gribMagic)
+		if start < 0 {
+			break
+		}
+		start += offset
+
+		length := messageLength(data[start:])
+		end := start + length
+		if length < 16 || end > len(data) || !bytes.Equal(data[end-4:end], endMagic) {
+			offset = start + len(gribMagic)
+			continue
+		}
+
+		messages = append(messages, data[start:end])
+		offset = end
+	}
+
+	return messages
+}
+
+func messageLength(msg []byte) int {
+	if len(msg) < 8 {
+		return 0
+	}
+
+	switch msg[7] {
+	case 1:
+		return int(msg[4])<<16 | int(msg[5])<<8 | int(msg[6])
+	case 2:
+		if len(msg) < 16 {
+			return 0
+		}
+		length := binary.BigEndian.Uint64(msg[8:16])
+		if length > uint64(len(msg)) {
+			return 0
+		}
+		return int(length)
+	}
+
+	return 0
+}
+
+// splitFields returns a message for each field of a GRIB2 message that
+// repeats sections 2-7, 3-7 or 4-7, with the sections that apply to the
+// field. A bitmap section with indicator 254 is replaced by the bitmap
+// defined before it in the message. Messages of a single field, and
+// messages whose sections cannot be read, are returned unchanged for the
+// decoder to report on.
+func splitFields(msg []byte) ([][]byte, error) {
+	if len(msg) < 16 || msg[7] != 2 {
+		return [][]byte{msg}, nil
+	}
+
+	var sections [8][]byte // the last section of each number
+	var bitmap []byte      // the last section 6 that defines a bitmap
+	var fields [][]byte
+	offset := 16
+	for offset+5 <= len(msg) && !bytes.Equal(msg[offset:offset+4], endMagic) {
+		length := int(binary.BigEndian.Uint32(msg[offset:]))
+		if length < 5 || offset+length > len(msg) || msg[offset+4] < 1 || msg[offset+4] > 7 {
+			return [][]byte{msg}, nil
+		}
+		section := msg[offset : offset+length]
+		offset += length
+
+		number := section[4]
+		if number == 6 && len(section) > 5 {
+			switch section[5] {
+			case 0:
+				bitmap = section
+			case 254:
+				if bitmap == nil {
+					return nil, errors.New("bitmap indicator 254 without a previous bitmap")
+				}
+				section = bitmap
+			}
+		}
+		sections[number] = section
+		if number == 7 {
+			fields = append(fields, joinSections(msg[:16], sections[1:]))
+		}
+	}
+
+	if len(fields) <= 1 {
+		return [][]byte{msg}, nil
+	}
+	return fields, nil
+}
+
+// joinSections assembles a message from the indicator section of another
+// message and the sections 1-7, leaving out the missing ones.
+func joinSections(indicator []byte, sections [][]byte) []byte {
+	length := len(indicator) + len(endMagic)
+	for _, section := range sections {
+		length += len(section)
+	}
+
+	msg := make([]byte, 0, length)
+	msg = append(msg, indicator[:8]...)
+	msg = binary.BigEndian.AppendUint64(msg, uint64(length))
+	for _, section := range sections {
+		msg = append(msg, section...)
+	}
+	return append(msg, endMagic...)
+}
@@ -0,0 +1,440 @@
+package parser
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"time"
+)
+
+// defaultMissingValue matches the missingValue ecCodes reports for GRIB2 fields.
+const defaultMissingValue = 9999
+
+const missingUint32 = 0xFFFFFFFF
+
+type gribSections struct {
+	discipline int
+	sec1       []byte
+	sec3       []byte
+	sec4       []byte
+	sec5       []byte
+	sec6       []byte
+	sec7       []byte
+}
+
+// readSections splits a GRIB2 message into its sections. Only the first
+// field of messages that repeat sections is returned, ProcessGRIBMessages
+// splits such messages into one message per field with splitFields.
+func readSections(msg []byte) (gribSections, error) {
+	var s gribSections
+
+	if len(msg) < 16 || string(msg[:4]) != "GRIB" {
+		return s, fmt.Errorf("not a GRIB message")
+	}
+	if msg[7] != 2 {
+		return s, fmt.Errorf("unsupported GRIB edition %d", msg[7])
+	}
+	s.discipline = int(msg[6])
+
+	offset := 16
+	for offset+5 <= len(msg) && string(msg[offset:offset+4]) != "7777" {
+		length := uint32At(msg, offset)
+		if length < 5 || offset+length > len(msg) {
+			return s, fmt.Errorf("invalid length %d of section at offset %d", length, offset)
+		}
+		section := msg[offset : offset+length]
+		offset += length
+
+		switch section[4] {
+		case 1:
+			s.sec1 = section
+		case 3:
+			s.sec3 = section
+		case 4:
+			s.sec4 = section
+		case 5:
+			s.sec5 = section
+		case 6:
+			s.sec6 = section
+		case 7:
+			s.sec7 = section
+			return s, s.validate()
+		}
+	}
+
+	return s, s.validate()
+}
+
+func (s gribSections) validate() error {
+	required := []struct {
+		number  int
+		section []byte
+		minLen  int
+	}{
+		{1, s.sec1, 21},
+		{3, s.sec3, 14},
+		{4, s.sec4, 11},
+		{5, s.sec5, 11},
+		{6, s.sec6, 6},
+		{7, s.sec7, 5},
+	}
+
+	for _, r := range required {
+		if r.section == nil {
+			return fmt.Errorf("section %d missing", r.number)
+		}
+		if len(r.section) < r.minLen {
+			return fmt.Errorf("section %d too short (%d bytes)", r.number, len(r.section))
+		}
+	}
+	return nil
+}
+
+// decodeNative decodes the first field of a GRIB2 message without ecCodes.
+func decodeNative(msg []byte) (GRIBFile, error) {
+	sections, err := readSections(msg)
+	if err != nil {
+		return GRIBFile{}, err
+	}
+
+	header := GribHeader{
+		Discipline:   sections.discipline,
+		MissingValue: defaultMissingValue,
+	}
+
+	sec1 := sections.sec1
+	header.DataTime = time.Date(uint16At(sec1, 12), time.Month(sec1[14]), int(sec1[15]),
+		int(sec1[16]), int(sec1[17]), int(sec1[18]), 0, time.UTC)
+
+	numPoints, err := parseGrid(sections.sec3, &header)
+	if err != nil {
+		return GRIBFile{}, err
+	}
+
+	centre := uint16At(sec1, 5)
+	if err := parseProduct(sections.sec4, centre, &header); err != nil {
+		return GRIBFile{}, err
+	}
+
+	dataValues, err := decodeData(sections, numPoints, header.MissingValue)
+	if err != nil {
+		return GRIBFile{}, err
+	}
+
+	header.Type = int32((header.Discipline & 0xFF) | ((header.ParameterCategory & 0xFF) << 8) | ((header.ParameterNumber & 0xFF) << 16))
+
+	parsedGrib := GRIBFile{Header: header, DataValues: dataValues}
+	if err := parsedGrib.checkGrid(); err != nil {
+		return GRIBFile{}, err
+	}
+
+	return newGRIBFile(canonicalScan(header, dataValues)), nil
+}
+
+// decodeData unpacks the values of all numPoints grid points, setting the
+// points that are missing to missingValue.
+func decodeData(sections gribSections, numPoints int, missingValue float64) ([]float64, error) {
+	drs, err := parseDataRepresentation(sections.sec5)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := drs.unpack(sections.sec7[5:])
+	if err != nil {
+		return nil, err
+	}
+
+	return applyBitmap(values, sections.sec6, numPoints, missingValue)
+}
+
+// parseGrid reads the grid definition section and returns the number of grid points.
+func parseGrid(sec3 []byte, header *GribHeader) (int, error) {
+	numPoints := uint32At(sec3, 6)
+	template := uint16At(sec3, 12)
+
+	switch template {
+	case 0, 1:
+		minLen := 72
+		header.GridType = GridRegularLatLon
+		if template == 1 {
+			minLen = 84
+			header.GridType = GridRotatedLatLon
+		}
+		if len(sec3) < minLen {
+			return 0, fmt.Errorf("grid definition section too short for template 3.%d", template)
+		}
+
+		parseEarth(sec3, header)
+		header.Nx = uint32At(sec3, 30)
+		header.Ny = uint32At(sec3, 34)
+
+		unit := angleUnit(uint32At(sec3, 38), uint32At(sec3, 42))
+		header.La1 = float64(int32At(sec3, 46)) * unit
+		header.Lo1 = float64(int32At(sec3, 50)) * unit
+		header.La2 = float64(int32At(sec3, 55)) * unit
+		header.Lo2 = float64(int32At(sec3, 59)) * unit
+
+		if dx := uint32At(sec3, 63); dx != missingUint32 {
+			header.DX = float64(dx) * unit
+		} else if header.Nx > 1 {
+			header.DX = math.Abs(header.Lo2-header.Lo1) / float64(header.Nx-1)
+		}
+		if dy := uint32At(sec3, 67); dy != missingUint32 {
+			header.DY = float64(dy) * unit
+		} else if header.Ny > 1 {
+			header.DY = math.Abs(header.La2-header.La1) / float64(header.Ny-1)
+		}
+
+		header.ScanMode = int(sec3[71])
+
+		if template == 1 {
+			header.SouthPoleLat = float64(int32At(sec3, 72)) * unit
+			header.SouthPoleLon = float64(uint32At(sec3, 76)) * unit
+			header.RotationAngle = float64(math.Float32frombits(uint32(uint32At(sec3, 80))))
+		}
+	case 40:
+		if len(sec3) < 72 {
+			return 0, fmt.Errorf("grid definition section too short for template 3.40")
+		}
+
+		parseEarth(sec3, header)
+		header.Nx = uint32At(sec3, 30)
+		header.Ny = uint32At(sec3, 34)
+
+		unit := angleUnit(uint32At(sec3, 38), uint32At(sec3, 42))
+		header.La1 = float64(int32At(sec3, 46)) * unit
+		header.Lo1 = float64(int32At(sec3, 50)) * unit
+		header.La2 = float64(int32At(sec3, 55)) * unit
+		header.Lo2 = float64(int32At(sec3, 59)) * unit
+		header.N = uint32At(sec3, 67)
+		header.ScanMode = int(sec3[71])
+
+		if header.Nx == missingUint32 {
+			pl, err := parsePointsPerRow(sec3, 72, header.Ny)
+			if err != nil {
+				return 0, err
+			}
+			header.GridType = GridReducedGaussian
+			header.PL = pl
+			header.Nx = 0
+			for _, n := range pl {
+				header.Nx = max(header.Nx, n)
+			}
+		} else {
+			header.GridType = GridRegularGaussian
+			if dx := uint32At(sec3, 63); dx != missingUint32 {
+				header.DX = float64(dx) * unit
+			} else if header.Nx > 1 {
+				header.DX = math.Abs(header.Lo2-header.Lo1) / float64(header.Nx-1)
+			}
+		}
+		if header.N > 0 {
+			header.DY = 90 / float64(header.N)
+		}
+	case 101:
+		if len(sec3) < 35 {
+			return 0, fmt.Errorf("grid definition section too short for template 3.101")
+		}
+
+		// The cell coordinates are in a separate grid file, see UnstructuredGrid.
+		header.GridType = GridUnstructured
+		header.EarthMajorAxis, header.EarthMinorAxis = earthShape(int(sec3[14]), 0, 0, 0)
+		header.Nx = numPoints
+		header.Ny = 1
+		header.GridNumber = int(sec3[15])<<16 | uint16At(sec3, 16)
+		header.GridReference = int(sec3[18])
+		header.GridUUID = hex.EncodeToString(sec3[19:35])
+	case 30:
+		if len(sec3) < 73 {
+			return 0, fmt.Errorf("grid definition section too short for template 3.30")
+		}
+
+		header.GridType = GridLambert
+		parseEarth(sec3, header)
+		header.Nx = uint32At(sec3, 30)
+		header.Ny = uint32At(sec3, 34)
+		header.La1 = float64(int32At(sec3, 38)) * 1e-6
+		header.Lo1 = float64(uint32At(sec3, 42)) * 1e-6
+		header.LaD = float64(int32At(sec3, 47)) * 1e-6
+		header.LoV = float64(uint32At(sec3, 51)) * 1e-6
+		header.DX = float64(uint32At(sec3, 55)) * 1e-3
+		header.DY = float64(uint32At(sec3, 59)) * 1e-3
+		header.ProjectionCentre = int(sec3[63])
+		header.ScanMode = int(sec3[64])
+		header.Latin1 = float64(int32At(sec3, 65)) * 1e-6
+		header.Latin2 = float64(int32At(sec3, 69)) * 1e-6
+
+		header.La2, header.Lo2 = GRIBFile{Header: *header}.GetLatLng(header.Nx-1, header.Ny-1)
+	case 20:
+		if len(sec3) < 65 {
+			return 0, fmt.Errorf("grid definition section too short for template 3.20")
+		}
+
+		header.GridType = GridPolarStereographic
+		parseEarth(sec3, header)
+		header.Nx = uint32At(sec3, 30)
+		header.Ny = uint32At(sec3, 34)
+		header.La1 = float64(int32At(sec3, 38)) * 1e-6
+		header.Lo1 = float64(uint32At(sec3, 42)) * 1e-6
+		header.LaD = float64(int32At(sec3, 47)) * 1e-6
+		header.LoV = float64(uint32At(sec3, 51)) * 1e-6
+		header.DX = float64(uint32At(sec3, 55)) * 1e-3
+		header.DY = float64(uint32At(sec3, 59)) * 1e-3
+		header.ProjectionCentre = int(sec3[63])
+		header.ScanMode = int(sec3[64])
+
+		header.La2, header.Lo2 = GRIBFile{Header: *header}.GetLatLng(header.Nx-1, header.Ny-1)
+	default:
+		return 0, &UnsupportedTemplateError{Section: 3, Template: template}
+	}
+
+	return numPoints, nil
+}
+
+// parsePointsPerRow reads the list of numbers of points of each row of a
+// reduced grid, which follows the template at offset.
+func parsePointsPerRow(sec3 []byte, offset, rows int) ([]int, error) {
+	size := int(sec3[10])
+	if interpretation := sec3[11]; size == 0 || interpretation != 1 {
+		return nil, fmt.Errorf("reduced grid without a list of points per row (interpretation %d)", interpretation)
+	}
+	if len(sec3) < offset+rows*size {
+		return nil, fmt.Errorf("grid definition section too short for %d row lengths", rows)
+	}
+
+	pl := make([]int, rows)
+	for j := range pl {
+		n := 0
+		for _, b := range sec3[offset+j*size : offset+(j+1)*size] {
+			n = n<<8 | int(b)
+		}
+		pl[j] = n
+	}
+	return pl, nil
+}
+
+// parseEarth reads the shape of the Earth, which starts every grid definition template.
+func parseEarth(sec3 []byte, header *GribHeader) {
+	radius := scaledEarthValue(int(sec3[15]), uint32At(sec3, 16))
+	major := scaledEarthValue(int(sec3[20]), uint32At(sec3, 21))
+	minor := scaledEarthValue(int(sec3[25]), uint32At(sec3, 26))
+	header.EarthMajorAxis, header.EarthMinorAxis = earthShape(int(sec3[14]), radius, major, minor)
+}
+
+// angleUnit returns the size in degrees of one unit of the angles stored in
+// a grid definition, which is 10^-6 degrees unless a basic angle is given.
+func angleUnit(basicAngle, subdivisions int) float64 {
+	if basicAngle == 0 || basicAngle == missingUint32 || subdivisions == 0 || subdivisions == missingUint32 {
+		return 1e-6
+	}
+	return float64(basicAngle) / float64(subdivisions)
+}
+
+// statisticalTemplates maps product definition templates describing
+// statistically processed fields to the offset of their time range block.
+var statisticalTemplates = map[int]int{
+	8:  34,
+	9:  47,
+	10: 35,
+	11: 37,
+	12: 36,
+}
+
+func parseProduct(sec4 []byte, centre int, header *GribHeader) error {
+	template := uint16At(sec4, 7)
+	header.ParameterCategory = int(sec4[9])
+	header.ParameterNumber = int(sec4[10])
+
+	// Templates 4.0 to 4.15 share the layout up to the second fixed surface.
+	if template > 15 {
+		return &UnsupportedTemplateError{Section: 4, Template: template}
+	}
+	if len(sec4) < 34 {
+		return fmt.Errorf("product definition section too short for template 4.%d", template)
+	}
+
+	timeUnit := int(sec4[17])
+	header.ForecastTime = uint32At(sec4, 18)
+	header.EndStep = header.ForecastTime
+	header.ReferenceTime = header.DataTime
+	if step, ok := forecastDuration(timeUnit, header.ForecastTime); ok {
+		header.ValidTime = header.DataTime.Add(step)
+		header.ReferenceTime = header.ValidTime
+	}
+
+	if offset, ok := statisticalTemplates[template]; ok && len(sec4) >= offset+19 {
+		rangeUnit := int(sec4[offset+14])
+		rangeLength := uint32At(sec4, offset+15)
+		if rangeUnit == timeUnit {
+			header.EndStep += rangeLength
+		} else if unit, ok := forecastDuration(timeUnit, 1); ok && unit > 0 {
+			if length, ok := forecastDuration(rangeUnit, rangeLength); ok {
+				header.EndStep += int(length / unit)
+			}
+		}
+	}
+
+	surfaceType := int(sec4[22])
+	surfaceValue := scaledValue(sec4[23], uint32At(sec4, 24))
+	secondSurfaceType := int(sec4[28])
+
+	header.TypeOfLevel = typeOfLevel(surfaceType, secondSurfaceType, surfaceValue)
+	if header.TypeOfLevel == "isobaricInhPa" || header.TypeOfLevel == "isobaricLayer" {
+		surfaceValue /= 100
+	}
+	header.Level = int(math.Round(surfaceValue))
+	header.ShortName = shortName(centre, header.Discipline, header.ParameterCategory, header.ParameterNumber, surfaceType, header.Level)
+
+	return nil
+}
+
+func scaledValue(scaleFactor byte, value int) float64 {
+	if scaleFactor == 0xFF || value == missingUint32 {
+		return 0
+	}
+	return float64(value) * math.Pow(10, -float64(signMagnitude([]byte{scaleFactor})))
+}
+
+// applyBitmap expands the decoded values to the full grid, filling points
+// masked out by the bitmap section or flagged as missing by the packing.
+func applyBitmap(values []float64, sec6 []byte, numPoints int, missingValue float64) ([]float64, error) {
+	dataValues := make([]float64, numPoints)
+
+	switch indicator := sec6[5]; indicator {
+	case 255:
+		if len(values) < numPoints {
+			return nil, fmt.Errorf("decoded %d values for %d grid points", len(values), numPoints)
+		}
+		for i := range dataValues {
+			dataValues[i] = values[i]
+			if math.IsNaN(values[i]) {
+				dataValues[i] = missingValue
+			}
+		}
+	case 0:
+		bitmap := sec6[6:]
+		if len(bitmap)*8 < numPoints {
+			return nil, fmt.Errorf("bitmap covers %d of %d grid points", len(bitmap)*8, numPoints)
+		}
+
+		next := 0
+		for i := range dataValues {
+			if bitmap[i>>3]&(0x80>>uint(i&7)) == 0 {
+				dataValues[i] = missingValue
+				continue
+			}
+			if next >= len(values) {
+				return nil, fmt.Errorf("bitmap references more than the %d decoded values", len(values))
+			}
+			dataValues[i] = values[next]
+			if math.IsNaN(values[next]) {
+				dataValues[i] = missingValue
+			}
+			next++
+		}
+	default:
+		return nil, fmt.Errorf("unsupported bitmap indicator %d", indicator)
+	}
+
+	return dataValues, nil
+}
@@ -0,0 +1,668 @@
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// Test messages are built section by section, with a 2 m temperature
+// product on a small regular latitude/longitude grid.
+
+var testDataTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+const testForecastHours = 6
+
+// testGrid is the regular latitude/longitude grid (template 3.0) of a test
+// message, with the corners in the order of its scanning mode.
+type testGrid struct {
+	nx, ny             int
+	la1, lo1, la2, lo2 float64
+	scanMode           byte
+}
+
+// signed32 and signed16 encode GRIB2 sign-and-magnitude integers.
+func signed32(v int) uint32 {
+	if v < 0 {
+		return 0x80000000 | uint32(-v)
+	}
+	return uint32(v)
+}
+
+func signed16(v int) uint16 {
+	if v < 0 {
+		return 0x8000 | uint16(-v)
+	}
+	return uint16(v)
+}
+
+func microdegrees(v float64) uint32 {
+	return signed32(int(math.Round(v * 1e6)))
+}
+
+// testSection prefixes the body of a section with its length and number.
+func testSection(number byte, body []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(5+len(body)))
+	b = append(b, number)
+	return append(b, body...)
+}
+
+// testMessage assembles a GRIB2 message of discipline 0 from the grid,
+// the data representation section and the bitmap and data payloads. A nil
+// bitmap means that no bitmap applies.
+func testMessage(grid testGrid, sec5 []byte, bitmap []byte, data []byte) []byte {
+	sec1 := binary.BigEndian.AppendUint16(nil, 98) // ECMWF
+	sec1 = binary.BigEndian.AppendUint16(sec1, 0)
+	sec1 = append(sec1, 28, 0, 1)
+	sec1 = binary.BigEndian.AppendUint16(sec1, uint16(testDataTime.Year()))
+	sec1 = append(sec1, byte(testDataTime.Month()), byte(testDataTime.Day()),
+		byte(testDataTime.Hour()), byte(testDataTime.Minute()), byte(testDataTime.Second()), 0, 1)
+
+	sec3 := []byte{0}
+	sec3 = binary.BigEndian.AppendUint32(sec3, uint32(grid.nx*grid.ny))
+	sec3 = append(sec3, 0, 0)
+	sec3 = binary.BigEndian.AppendUint16(sec3, 0)
+	sec3 = append(sec3, 6, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	sec3 = binary.BigEndian.AppendUint32(sec3, uint32(grid.nx))
+	sec3 = binary.BigEndian.AppendUint32(sec3, uint32(grid.ny))
+	sec3 = binary.BigEndian.AppendUint32(sec3, 0)
+	sec3 = binary.BigEndian.AppendUint32(sec3, missingUint32)
+	sec3 = binary.BigEndian.AppendUint32(sec3, microdegrees(grid.la1))
+	sec3 = binary.BigEndian.AppendUint32(sec3, microdegrees(grid.lo1))
+	sec3 = append(sec3, 48)
+	sec3 = binary.BigEndian.AppendUint32(sec3, microdegrees(grid.la2))
+	sec3 = binary.BigEndian.AppendUint32(sec3, microdegrees(grid.lo2))
+	sec3 = binary.BigEndian.AppendUint32(sec3, microdegrees(math.Abs(grid.lo2-grid.lo1)/float64(max(grid.nx-1, 1))))
+	sec3 = binary.BigEndian.AppendUint32(sec3, microdegrees(math.Abs(grid.la2-grid.la1)/float64(max(grid.ny-1, 1))))
+	sec3 = append(sec3, grid.scanMode)
+
+	sec4 := binary.BigEndian.AppendUint16(nil, 0)
+	sec4 = binary.BigEndian.AppendUint16(sec4, 0) // template 4.0
+	sec4 = append(sec4, 0, 0, 2, 0, 96, 0, 0, 0, 1)
+	sec4 = binary.BigEndian.AppendUint32(sec4, testForecastHours)
+	sec4 = append(sec4, 103, 0)
+	sec4 = binary.BigEndian.AppendUint32(sec4, 2)
+	sec4 = append(sec4, 255, 0)
+	sec4 = binary.BigEndian.AppendUint32(sec4, 0)
+
+	sec6 := []byte{255}
+	if bitmap != nil {
+		sec6 = append([]byte{0}, bitmap...)
+	}
+
+	var body []byte
+	body = append(body, testSection(1, sec1)...)
+	body = append(body, testSection(3, sec3)...)
+	body = append(body, testSection(4, sec4)...)
+	body = append(body, sec5...)
+	body = append(body, testSection(6, sec6)...)
+	body = append(body, testSection(7, data)...)
+	body = append(body, "7777"...)
+
+	msg := []byte{'G', 'R', 'I', 'B', 0, 0, 0, 2}
+	msg = binary.BigEndian.AppendUint64(msg, uint64(16+len(body)))
+	return append(msg, body...)
+}
+
+// testDataRepresentation returns a data representation section of the
+// given template with the fields shared by all templates, followed by the
+// fields specific to the template.
+func testDataRepresentation(template, numValues int, reference float32, binaryScale, decimalScale, bits int, specific ...byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(numValues))
+	b = binary.BigEndian.AppendUint16(b, uint16(template))
+	b = binary.BigEndian.AppendUint32(b, math.Float32bits(reference))
+	b = binary.BigEndian.AppendUint16(b, signed16(binaryScale))
+	b = binary.BigEndian.AppendUint16(b, signed16(decimalScale))
+	b = append(b, byte(bits), 0)
+	return testSection(5, append(b, specific...))
+}
+
+// testBitWriter writes big-endian bit fields without stuffing.
+type testBitWriter struct {
+	buf []byte
+	n   int
+}
+
+func (w *testBitWriter) write(v uint64, bits int) {
+	for i := bits - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		if v>>uint(i)&1 == 1 {
+			w.buf[len(w.buf)-1] |= 0x80 >> uint(w.n%8)
+		}
+		w.n++
+	}
+}
+
+func (w *testBitWriter) align() {
+	w.n = (w.n + 7) &^ 7
+}
+
+// bitsFor returns the number of bits needed to store v.
+func bitsFor(v int64) int {
+	n := 0
+	for v > 0 {
+		n++
+		v >>= 1
+	}
+	return n
+}
+
+// packSimple packs integers with simple packing (template 5.0).
+func packSimple(values []int64, reference float32, binaryScale, decimalScale, bits int) (sec5, data []byte) {
+	var w testBitWriter
+	for _, v := range values {
+		w.write(uint64(v), bits)
+	}
+	return testDataRepresentation(0, len(values), reference, binaryScale, decimalScale, bits), w.buf
+}
+
+// packComplex packs integers with complex packing in groups of the given
+// lengths (template 5.2), or with spatial differencing of order 1 or 2
+// first (template 5.3). Missing values are coded with the primary missing
+// value substitute.
+func packComplex(values []int64, missing []bool, groupLengths []int, order int, reference float32, binaryScale, decimalScale int) (sec5, data []byte) {
+	management := 0
+	for _, m := range missing {
+		if m {
+			management = 1
+		}
+	}
+
+	var present []int64
+	for i, v := range values {
+		if !missing[i] {
+			present = append(present, v)
+		}
+	}
+
+	// Spatial differencing over the values that are present, undone by
+	// the decoder as values[i] += minDifference + predictor.
+	stored := append([]int64(nil), present...)
+	var firstValues []int64
+	var minDifference int64
+	if order > 0 {
+		diffs := make([]int64, len(present))
+		for i := order; i < len(present); i++ {
+			if order == 1 {
+				diffs[i] = present[i] - present[i-1]
+			} else {
+				diffs[i] = present[i] - 2*present[i-1] + present[i-2]
+			}
+			if i == order || diffs[i] < minDifference {
+				minDifference = diffs[i]
+			}
+		}
+		firstValues = present[:order]
+		for i := range stored {
+			stored[i] = 0
+			if i >= order {
+				stored[i] = diffs[i] - minDifference
+			}
+		}
+	}
+
+	type group struct {
+		values  []int64 // -1 for missing
+		ref     int64
+		width   int
+		missing bool
+		empty   bool
+	}
+	var groups []group
+	next, offset := 0, 0
+	for _, length := range groupLengths {
+		g := group{empty: true}
+		lo, hi := int64(0), int64(0)
+		for i := offset; i < offset+length; i++ {
+			if missing[i] {
+				g.values = append(g.values, -1)
+				g.missing = true
+				continue
+			}
+			v := stored[next]
+			next++
+			g.values = append(g.values, v)
+			if g.empty || v < lo {
+				lo = v
+			}
+			if g.empty || v > hi {
+				hi = v
+			}
+			g.empty = false
+		}
+		offset += length
+
+		g.ref = lo
+		if !g.empty {
+			// With missing value management, all ones of a width are missing.
+			g.width = bitsFor(hi - lo + int64(management))
+			if hi == lo && !g.missing {
+				g.width = 0
+			}
+		}
+		groups = append(groups, g)
+	}
+
+	maxRef := int64(0)
+	for _, g := range groups {
+		maxRef = max(maxRef, g.ref)
+	}
+	bits := max(1, bitsFor(maxRef+int64(management)))
+	for i := range groups {
+		if groups[i].empty {
+			groups[i].ref = 1<<uint(bits) - 1
+		}
+	}
+
+	minWidth, maxWidth := groups[0].width, groups[0].width
+	for _, g := range groups {
+		minWidth, maxWidth = min(minWidth, g.width), max(maxWidth, g.width)
+	}
+	minLength, maxLength := groupLengths[0], groupLengths[0]
+	for _, n := range groupLengths[:len(groupLengths)-1] {
+		minLength, maxLength = min(minLength, n), max(maxLength, n)
+	}
+	widthBits := bitsFor(int64(maxWidth - minWidth))
+	lengthBits := bitsFor(int64(maxLength - minLength))
+
+	var w testBitWriter
+	for _, v := range firstValues {
+		w.write(uint64(signed16(int(v))), 16)
+	}
+	if order > 0 {
+		w.write(uint64(signed16(int(minDifference))), 16)
+	}
+	for _, g := range groups {
+		w.write(uint64(g.ref), bits)
+	}
+	w.align()
+	for _, g := range groups {
+		w.write(uint64(g.width-minWidth), widthBits)
+	}
+	w.align()
+	for i := range groups {
+		length := 0
+		if i < len(groups)-1 {
+			length = groupLengths[i] - minLength
+		}
+		w.write(uint64(length), lengthBits)
+	}
+	w.align()
+	for _, g := range groups {
+		if g.width == 0 {
+			continue
+		}
+		for _, v := range g.values {
+			if v < 0 {
+				w.write(1<<uint(g.width)-1, g.width)
+			} else {
+				w.write(uint64(v-g.ref), g.width)
+			}
+		}
+	}
+
+	specific := []byte{1, byte(management)}
+	specific = binary.BigEndian.AppendUint32(specific, 0)
+	specific = binary.BigEndian.AppendUint32(specific, 0)
+	specific = binary.BigEndian.AppendUint32(specific, uint32(len(groups)))
+	specific = append(specific, byte(minWidth), byte(widthBits))
+	specific = binary.BigEndian.AppendUint32(specific, uint32(minLength))
+	specific = append(specific, 1)
+	specific = binary.BigEndian.AppendUint32(specific, uint32(groupLengths[len(groupLengths)-1]))
+	specific = append(specific, byte(lengthBits))
+
+	template := 2
+	if order > 0 {
+		template = 3
+		specific = append(specific, byte(order), 2)
+	}
+	return testDataRepresentation(template, len(values), reference, binaryScale, decimalScale, bits, specific...), w.buf
+}
+
+// testValues returns n packed integers of a smooth field with some noise.
+func testValues(n int, scale int64) []int64 {
+	values := make([]int64, n)
+	for i := range values {
+		values[i] = scale + int64(float64(scale)*math.Sin(float64(i)/7)) + int64(i*i*31%17)
+	}
+	return values
+}
+
+// unpacked returns the values the decoder should produce for packed integers.
+func unpacked(values []int64, missing []bool, reference float32, binaryScale, decimalScale int) []float64 {
+	expected := make([]float64, len(values))
+	for i, v := range values {
+		if missing != nil && missing[i] {
+			expected[i] = defaultMissingValue
+			continue
+		}
+		expected[i] = (float64(reference) + float64(v)*math.Ldexp(1, binaryScale)) / math.Pow(10, float64(decimalScale))
+	}
+	return expected
+}
+
+func checkValues(t *testing.T, got, want []float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("decoded %d values, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > 1e-9*math.Max(1, math.Abs(want[i])) {
+			t.Errorf("value %d = %g, want %g", i, got[i], want[i])
+		}
+	}
+}
+
+var testGrid4x3 = testGrid{nx: 4, ny: 3, la1: 50, lo1: 5, la2: 48, lo2: 8}
+
+// testMessages returns a message of every packing the native decoder
+// supports, for comparison with ecCodes.
+func testMessages(t *testing.T) map[string][]byte {
+	t.Helper()
+	values := testValues(12, 300)
+	missing := []bool{false, true, false, false, true, true, true, false, false, false, false, true}
+	grid := testGrid4x3
+	grid.scanMode = 0x50
+	grid.la1, grid.la2 = grid.la2, grid.la1
+
+	messages := make(map[string][]byte)
+	sec5, data := packSimple(values, 2500, -2, 1, 12)
+	messages["simple"] = testMessage(testGrid4x3, sec5, nil, data)
+	messages["simple scanning mode 0x50"] = testMessage(grid, sec5, nil, data)
+	sec5, data = packComplex(values, missing, []int{4, 3, 5}, 0, -40, 0, 2)
+	messages["complex"] = testMessage(testGrid4x3, sec5, nil, data)
+	sec5, data = packComplex(values, missing, []int{3, 3, 3, 3}, 2, -40, 0, 2)
+	messages["complex spatial differencing"] = testMessage(testGrid4x3, sec5, nil, data)
+
+	var bitmap testBitWriter
+	var present []int64
+	for i, m := range missing {
+		if m {
+			bitmap.write(0, 1)
+		} else {
+			bitmap.write(1, 1)
+			present = append(present, values[i])
+		}
+	}
+	sec5, data = packSimple(present, 0, 0, 1, 10)
+	messages["bitmap"] = testMessage(testGrid4x3, sec5, bitmap.buf, data)
+
+	large := testGrid{nx: 37, ny: 29, la1: 60, lo1: -10, la2: 32, lo2: 26}
+	samples := testImage(large.nx, large.ny, 14, 1)
+	sec5, data = packJPEG2000(t, large, samples, 14, 2000, -3, 1)
+	messages["jpeg2000"] = testMessage(large, sec5, nil, data)
+	sec5, data = packCCSDS(samples, 14, 2000, -3, 1)
+	messages["ccsds"] = testMessage(large, sec5, nil, data)
+	sec5, data = packPNG(t, large, samples, 14, 2000, -3, 1)
+	messages["png"] = testMessage(large, sec5, nil, data)
+	return messages
+}
+
+func TestNativeSimplePacking(t *testing.T) {
+	values := testValues(12, 1000)
+	sec5, data := packSimple(values, 2500, -2, 1, 12)
+	g, err := decodeNative(testMessage(testGrid4x3, sec5, nil, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkValues(t, g.DataValues, unpacked(values, nil, 2500, -2, 1))
+
+	h := g.Header
+	if h.GridType != GridRegularLatLon || h.Nx != 4 || h.Ny != 3 {
+		t.Errorf("grid %s of %dx%d, want regular_ll of 4x3", h.GridType, h.Nx, h.Ny)
+	}
+	if h.La1 != 50 || h.Lo1 != 5 || h.La2 != 48 || h.Lo2 != 8 || h.DX != 1 || h.DY != 1 {
+		t.Errorf("corners %g,%g %g,%g with increments %g,%g", h.La1, h.Lo1, h.La2, h.Lo2, h.DX, h.DY)
+	}
+	if h.ShortName != "2t" || h.TypeOfLevel != "heightAboveGround" || h.Level != 2 {
+		t.Errorf("parameter %s on %s %d, want 2t on heightAboveGround 2", h.ShortName, h.TypeOfLevel, h.Level)
+	}
+	if !h.DataTime.Equal(testDataTime) || !h.ValidTime.Equal(testDataTime.Add(testForecastHours*time.Hour)) || h.ForecastTime != testForecastHours {
+		t.Errorf("data time %s, valid time %s, step %d", h.DataTime, h.ValidTime, h.ForecastTime)
+	}
+}
+
+func TestNativeConstantField(t *testing.T) {
+	sec5 := testDataRepresentation(0, 12, 273.5, 0, 0, 0)
+	g, err := decodeNative(testMessage(testGrid4x3, sec5, nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range g.DataValues {
+		if v != 273.5 {
+			t.Fatalf("value %d = %g, want 273.5", i, v)
+		}
+	}
+}
+
+func TestNativeComplexPacking(t *testing.T) {
+	values := testValues(12, 300)
+	none := make([]bool, 12)
+	missing := []bool{false, true, false, false, true, true, true, false, false, false, false, true}
+
+	tests := []struct {
+		name    string
+		missing []bool
+		groups  []int
+		order   int
+	}{
+		{"5.2", none, []int{3, 5, 1, 3}, 0},
+		{"5.2 one group", none, []int{12}, 0},
+		{"5.2 missing", missing, []int{4, 3, 5}, 0},
+		{"5.3 first order", none, []int{2, 4, 6}, 1},
+		{"5.3 second order", none, []int{5, 7}, 2},
+		{"5.3 second order missing", missing, []int{3, 3, 3, 3}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sec5, data := packComplex(values, tt.missing, tt.groups, tt.order, -40, 0, 2)
+			g, err := decodeNative(testMessage(testGrid4x3, sec5, nil, data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkValues(t, g.DataValues, unpacked(values, tt.missing, -40, 0, 2))
+		})
+	}
+}
+
+func TestNativeBitmap(t *testing.T) {
+	present := []bool{true, true, false, true, false, false, true, true, true, false, true, true}
+	var bitmap testBitWriter
+	var values []int64
+	for i, p := range present {
+		if p {
+			bitmap.write(1, 1)
+			values = append(values, int64(100+i))
+		} else {
+			bitmap.write(0, 1)
+		}
+	}
+
+	sec5, data := packSimple(values, 0, 0, 0, 8)
+	g, err := decodeNative(testMessage(testGrid4x3, sec5, bitmap.buf, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := make([]float64, len(present))
+	for i, p := range present {
+		want[i] = defaultMissingValue
+		if p {
+			want[i] = float64(100 + i)
+		}
+	}
+	checkValues(t, g.DataValues, want)
+}
+
+func TestNativeScanModes(t *testing.T) {
+	// A 3x2 grid whose canonical values are 10*row + column, rows from the
+	// north, stored in the order of each scanning mode.
+	want := []float64{0, 1, 2, 10, 11, 12}
+	tests := []struct {
+		mode   byte
+		stored []int64
+	}{
+		{0x00, []int64{0, 1, 2, 10, 11, 12}},
+		{0x80, []int64{2, 1, 0, 12, 11, 10}},
+		{0x40, []int64{10, 11, 12, 0, 1, 2}},
+		{0xC0, []int64{12, 11, 10, 2, 1, 0}},
+		{0x20, []int64{0, 10, 1, 11, 2, 12}},
+		{0x60, []int64{10, 0, 11, 1, 12, 2}},
+		{0x10, []int64{0, 1, 2, 12, 11, 10}},
+		{0x50, []int64{10, 11, 12, 2, 1, 0}},
+		{0x30, []int64{0, 10, 11, 1, 2, 12}},
+	}
+	for _, tt := range tests {
+		grid := testGrid{nx: 3, ny: 2, la1: 50, lo1: 10, la2: 49, lo2: 12, scanMode: tt.mode}
+		if tt.mode&scanNegativeI != 0 {
+			grid.lo1, grid.lo2 = grid.lo2, grid.lo1
+		}
+		if tt.mode&scanPositiveJ != 0 {
+			grid.la1, grid.la2 = grid.la2, grid.la1
+		}
+
+		sec5, data := packSimple(tt.stored, 0, 0, 0, 8)
+		g, err := decodeNative(testMessage(grid, sec5, nil, data))
+		if err != nil {
+			t.Fatalf("scanning mode %#02x: %v", tt.mode, err)
+		}
+		for i := range want {
+			if g.DataValues[i] != want[i] {
+				t.Errorf("scanning mode %#02x: values %v, want %v", tt.mode, g.DataValues, want)
+				break
+			}
+		}
+		h := g.Header
+		if h.ScanMode != 0 || h.La1 != 50 || h.La2 != 49 || h.Lo1 != 10 || h.Lo2 != 12 {
+			t.Errorf("scanning mode %#02x: header mode %#02x with corners %g,%g %g,%g", tt.mode, h.ScanMode, h.La1, h.Lo1, h.La2, h.Lo2)
+		}
+	}
+}
+
+func TestNativeMessages(t *testing.T) {
+	var file []byte
+	messages := testMessages(t)
+	for name, msg := range messages {
+		if _, err := decodeNative(msg); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		file = append(file, msg...)
+	}
+
+	gribFiles, err := ProcessGRIBMessages(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gribFiles) != len(messages) {
+		t.Fatalf("%d messages decoded, want %d", len(gribFiles), len(messages))
+	}
+	for i, g := range gribFiles {
+		if g.Err != nil {
+			t.Errorf("message %d: %v", i+1, g.Err)
+		}
+	}
+}
+
+// testSections returns the sections of a test message by number.
+func testSections(msg []byte) map[byte][]byte {
+	sections := make(map[byte][]byte)
+	for offset := 16; offset < len(msg)-4; {
+		length := int(binary.BigEndian.Uint32(msg[offset:]))
+		sections[msg[offset+4]] = msg[offset : offset+length]
+		offset += length
+	}
+	return sections
+}
+
+// testJoin assembles a GRIB2 message of discipline 0 from its sections.
+func testJoin(sections ...[]byte) []byte {
+	var body []byte
+	for _, section := range sections {
+		body = append(body, section...)
+	}
+	body = append(body, "7777"...)
+
+	msg := []byte{'G', 'R', 'I', 'B', 0, 0, 0, 2}
+	msg = binary.BigEndian.AppendUint64(msg, uint64(16+len(body)))
+	return append(msg, body...)
+}
+
+func TestProcessGRIBMessagesFields(t *testing.T) {
+	present := []bool{true, false, true, true, false, true, true, true, false, false, true, true}
+	var bitmap testBitWriter
+	for _, p := range present {
+		if p {
+			bitmap.write(1, 1)
+		} else {
+			bitmap.write(0, 1)
+		}
+	}
+	withBitmap := func(values []int64) []float64 {
+		want := make([]float64, len(present))
+		next := 0
+		for i, p := range present {
+			want[i] = defaultMissingValue
+			if p {
+				want[i] = float64(values[next])
+				next++
+			}
+		}
+		return want
+	}
+
+	plain := testValues(12, 300)
+	sec5, data := packSimple(plain, 0, 0, 0, 10)
+	a := testSections(testMessage(testGrid4x3, sec5, nil, data))
+	masked := testValues(8, 50)
+	sec5, data = packSimple(masked, 0, 0, 0, 8)
+	b := testSections(testMessage(testGrid4x3, sec5, bitmap.buf, data))
+	reused := testValues(8, 400)
+	sec5, data = packSimple(reused, 0, 0, 0, 10)
+	c := testSections(testMessage(testGrid4x3, sec5, bitmap.buf, data))
+	small := testGrid{nx: 3, ny: 2, la1: 50, lo1: 10, la2: 49, lo2: 12}
+	other := testValues(6, 20)
+	sec5, data = packSimple(other, 0, 0, 0, 6)
+	d := testSections(testMessage(small, sec5, nil, data))
+	previous := testSection(6, []byte{254})
+
+	// Sections 4-7 repeat twice, the second time with the bitmap of the
+	// first, then sections 3-7 on another grid. The next message refers
+	// to a previous bitmap it does not have.
+	file := testJoin(a[1], a[3], a[4], a[5], a[6], a[7],
+		b[4], b[5], b[6], b[7],
+		c[4], c[5], previous, c[7],
+		d[3], d[4], d[5], d[6], d[7])
+	file = append(file, testJoin(c[1], c[3], c[4], c[5], previous, c[7])...)
+
+	gribFiles, err := ProcessGRIBMessages(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gribFiles) != 5 {
+		t.Fatalf("%d messages decoded, want 5", len(gribFiles))
+	}
+	for i, want := range [][]float64{
+		unpacked(plain, nil, 0, 0, 0),
+		withBitmap(masked),
+		withBitmap(reused),
+		unpacked(other, nil, 0, 0, 0),
+	} {
+		if gribFiles[i].Err != nil {
+			t.Fatalf("field %d: %v", i+1, gribFiles[i].Err)
+		}
+		checkValues(t, gribFiles[i].DataValues, want)
+	}
+	if h := gribFiles[3].Header; h.Nx != 3 || h.Ny != 2 {
+		t.Errorf("field 4 on a grid of %dx%d points, want 3x2", h.Nx, h.Ny)
+	}
+
+	var msgErr *MessageError
+	if !errors.As(gribFiles[4].Err, &msgErr) || msgErr.Message != 5 {
+		t.Errorf("message with a bitmap indicator of 254 and no bitmap: error %v, want message 5 to fail", gribFiles[4].Err)
+	}
+}
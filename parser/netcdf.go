@@ -0,0 +1,243 @@
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// NetCDF external data types.
+const (
+	ncByte   = 1
+	ncChar   = 2
+	ncShort  = 3
+	ncInt    = 4
+	ncFloat  = 5
+	ncDouble = 6
+	ncUbyte  = 7
+	ncUshort = 8
+	ncUint   = 9
+	ncInt64  = 10
+	ncUint64 = 11
+)
+
+// netcdfFile is a file in the NetCDF classic, 64-bit offset or 64-bit data
+// (CDF-5) format. Only variables outside the record dimension can be read.
+type netcdfFile struct {
+	data  []byte
+	dims  []int
+	attrs map[string]netcdfAttr
+	vars  map[string]netcdfVar
+}
+
+type netcdfAttr struct {
+	ncType int
+	values []byte
+}
+
+type netcdfVar struct {
+	dims   []int
+	attrs  map[string]netcdfAttr
+	ncType int
+	begin  int
+}
+
+// netcdfReader reads the header of a NetCDF file, keeping the first error.
+type netcdfReader struct {
+	data    []byte
+	pos     int
+	version byte
+	err     error
+}
+
+func (r *netcdfReader) uint(size int) int {
+	if r.err != nil {
+		return 0
+	}
+	if r.pos+size > len(r.data) {
+		r.err = errors.New("truncated NetCDF header")
+		return 0
+	}
+
+	b := r.data[r.pos : r.pos+size]
+	r.pos += size
+	if size == 8 {
+		return int(binary.BigEndian.Uint64(b))
+	}
+	return int(binary.BigEndian.Uint32(b))
+}
+
+// count reads a non-negative count, which is 64 bits wide in CDF-5.
+func (r *netcdfReader) count() int {
+	if r.version == 5 {
+		return r.uint(8)
+	}
+	return r.uint(4)
+}
+
+// bytes reads n bytes padded to a multiple of four.
+func (r *netcdfReader) bytes(n int) []byte {
+	padded := (n + 3) &^ 3
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.pos+padded > len(r.data) {
+		r.err = errors.New("truncated NetCDF header")
+		return nil
+	}
+
+	b := r.data[r.pos : r.pos+n]
+	r.pos += padded
+	return b
+}
+
+func (r *netcdfReader) name() string {
+	return string(r.bytes(r.count()))
+}
+
+// list reads the tag and length of a dimension, attribute or variable list.
+func (r *netcdfReader) list(tag int) int {
+	t := r.uint(4)
+	n := r.count()
+	if r.err == nil && t != tag && (t != 0 || n != 0) {
+		r.err = fmt.Errorf("unexpected NetCDF header tag %#x", t)
+	}
+	return n
+}
+
+func (r *netcdfReader) attrs() map[string]netcdfAttr {
+	attrs := map[string]netcdfAttr{}
+	n := r.list(0x0C)
+	for i := 0; i < n && r.err == nil; i++ {
+		name := r.name()
+		ncType := r.uint(4)
+		count := r.count()
+		attrs[name] = netcdfAttr{ncType: ncType, values: r.bytes(count * netcdfTypeSize(ncType))}
+	}
+	return attrs
+}
+
+// netcdfTypeSize returns the size in bytes of a value of a NetCDF type.
+func netcdfTypeSize(ncType int) int {
+	switch ncType {
+	case ncByte, ncChar, ncUbyte:
+		return 1
+	case ncShort, ncUshort:
+		return 2
+	case ncInt, ncFloat, ncUint:
+		return 4
+	case ncDouble, ncInt64, ncUint64:
+		return 8
+	}
+	return 0
+}
+
+// parseNetCDF reads the header of a NetCDF file.
+func parseNetCDF(data []byte) (*netcdfFile, error) {
+	if len(data) < 4 || string(data[:3]) != "CDF" {
+		if len(data) >= 4 && string(data[1:4]) == "HDF" {
+			return nil, errors.New("NetCDF-4 files are not supported, convert the file with nccopy -k cdf5")
+		}
+		return nil, errors.New("not a NetCDF file")
+	}
+
+	r := &netcdfReader{data: data, pos: 4, version: data[3]}
+	if r.version != 1 && r.version != 2 && r.version != 5 {
+		return nil, fmt.Errorf("unsupported NetCDF version %d", r.version)
+	}
+
+	f := &netcdfFile{data: data, vars: map[string]netcdfVar{}}
+	r.count() // number of records
+
+	n := r.list(0x0A)
+	for i := 0; i < n && r.err == nil; i++ {
+		r.name()
+		f.dims = append(f.dims, r.count())
+	}
+
+	f.attrs = r.attrs()
+
+	n = r.list(0x0B)
+	for i := 0; i < n && r.err == nil; i++ {
+		name := r.name()
+		v := netcdfVar{}
+		ndims := r.count()
+		for j := 0; j < ndims && r.err == nil; j++ {
+			v.dims = append(v.dims, r.count())
+		}
+		v.attrs = r.attrs()
+		v.ncType = r.uint(4)
+		r.count() // vsize
+		if r.version == 1 {
+			v.begin = r.uint(4)
+		} else {
+			v.begin = r.uint(8)
+		}
+		f.vars[name] = v
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	return f, nil
+}
+
+// text returns the value of a character attribute.
+func (a netcdfAttr) text() string {
+	if a.ncType != ncChar {
+		return ""
+	}
+	return strings.TrimRight(string(a.values), "\x00 ")
+}
+
+// int returns the first value of an integer attribute.
+func (a netcdfAttr) int() (int, bool) {
+	switch {
+	case a.ncType == ncInt && len(a.values) >= 4:
+		return int(int32(binary.BigEndian.Uint32(a.values))), true
+	case a.ncType == ncShort && len(a.values) >= 2:
+		return int(int16(binary.BigEndian.Uint16(a.values))), true
+	}
+	return 0, false
+}
+
+// floats reads a floating point variable that is not a record variable.
+func (f *netcdfFile) floats(name string) ([]float64, error) {
+	v, ok := f.vars[name]
+	if !ok {
+		return nil, fmt.Errorf("variable %s not found", name)
+	}
+	if v.ncType != ncFloat && v.ncType != ncDouble {
+		return nil, fmt.Errorf("variable %s is not a floating point variable", name)
+	}
+
+	n := 1
+	for _, dim := range v.dims {
+		if dim < 0 || dim >= len(f.dims) {
+			return nil, fmt.Errorf("variable %s has an invalid dimension", name)
+		}
+		size := f.dims[dim]
+		if size == 0 {
+			return nil, fmt.Errorf("variable %s is a record variable", name)
+		}
+		n *= size
+	}
+
+	size := netcdfTypeSize(v.ncType)
+	if v.begin < 0 || v.begin+n*size > len(f.data) {
+		return nil, fmt.Errorf("variable %s: truncated file", name)
+	}
+
+	values := make([]float64, n)
+	b := f.data[v.begin:]
+	for i := range values {
+		if v.ncType == ncFloat {
+			values[i] = float64(math.Float32frombits(binary.BigEndian.Uint32(b[i*4:])))
+		} else {
+			values[i] = math.Float64frombits(binary.BigEndian.Uint64(b[i*8:]))
+		}
+	}
+	return values, nil
+}
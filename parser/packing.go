@@ -0,0 +1,360 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+)
+
+// dataRepresentation holds the section 5 parameters needed to unpack section 7.
+type dataRepresentation struct {
+	template     int
+	numValues    int
+	reference    float64
+	binaryScale  int
+	decimalScale int
+	bits         int
+
+	// complex packing (5.2, 5.3)
+	missingManagement int
+	numGroups         int
+	widthReference    int
+	widthBits         int
+	lengthReference   int
+	lengthIncrement   int
+	lastGroupLength   int
+	lengthBits        int
+	spatialOrder      int
+	extraOctets       int
+
+	// CCSDS packing (5.42)
+	ccsdsFlags        int
+	blockSize         int
+	referenceInterval int
+
+	section []byte
+}
+
+func parseDataRepresentation(sec5 []byte) (dataRepresentation, error) {
+	d := dataRepresentation{
+		numValues: uint32At(sec5, 5),
+		template:  uint16At(sec5, 9),
+		section:   sec5,
+	}
+
+	minLen := 0
+	switch d.template {
+	case 0:
+		minLen = 21
+	case 2:
+		minLen = 47
+	case 3:
+		minLen = 49
+	case 40:
+		minLen = 23
+	case 41:
+		minLen = 21
+	case 42:
+		minLen = 25
+	default:
+		return d, &UnsupportedTemplateError{Section: 5, Template: d.template}
+	}
+	if len(sec5) < minLen {
+		return d, fmt.Errorf("data representation section too short for template 5.%d", d.template)
+	}
+
+	d.reference = float64(math.Float32frombits(uint32(uint32At(sec5, 11))))
+	d.binaryScale = int16At(sec5, 15)
+	d.decimalScale = int16At(sec5, 17)
+	d.bits = int(sec5[19])
+
+	if d.template == 2 || d.template == 3 {
+		d.missingManagement = int(sec5[22])
+		d.numGroups = uint32At(sec5, 31)
+		d.widthReference = int(sec5[35])
+		d.widthBits = int(sec5[36])
+		d.lengthReference = uint32At(sec5, 37)
+		d.lengthIncrement = int(sec5[41])
+		d.lastGroupLength = uint32At(sec5, 42)
+		d.lengthBits = int(sec5[46])
+	}
+	if d.template == 3 {
+		d.spatialOrder = int(sec5[47])
+		d.extraOctets = int(sec5[48])
+	}
+	if d.template == 42 {
+		d.ccsdsFlags = int(sec5[21])
+		d.blockSize = int(sec5[22])
+		d.referenceInterval = uint16At(sec5, 23)
+	}
+
+	return d, nil
+}
+
+// scale converts packed integers into physical values: Y = (R + X * 2^E) / 10^D.
+func (d dataRepresentation) scale(x float64) float64 {
+	return (d.reference + x*math.Pow(2, float64(d.binaryScale))) * math.Pow(10, -float64(d.decimalScale))
+}
+
+// unpack decodes the section 7 payload. Values flagged as missing by the
+// packing itself are returned as NaN.
+func (d dataRepresentation) unpack(data []byte) ([]float64, error) {
+	switch d.template {
+	case 0:
+		return d.unpackSimple(data)
+	case 2, 3:
+		return d.unpackComplex(data)
+	case 40, 41, 42:
+		if d.bits == 0 {
+			return d.constant(), nil
+		}
+
+		var samples []int64
+		var err error
+		switch d.template {
+		case 40:
+			samples, _, err = decodeJPEG2000(data)
+		case 41:
+			samples, err = decodePNG(data)
+		case 42:
+			samples, err = decodeCCSDS(data, d.numValues, d.bits, d.blockSize, d.referenceInterval, d.ccsdsFlags)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return d.scaleSamples(samples)
+	}
+	return nil, &UnsupportedTemplateError{Section: 5, Template: d.template}
+}
+
+// constant returns the field of a message packed with zero bits per value.
+func (d dataRepresentation) constant() []float64 {
+	values := make([]float64, d.numValues)
+	constant := d.scale(0)
+	for i := range values {
+		values[i] = constant
+	}
+	return values
+}
+
+func (d dataRepresentation) scaleSamples(samples []int64) ([]float64, error) {
+	if len(samples) < d.numValues {
+		return nil, fmt.Errorf("decoded %d samples, expected %d", len(samples), d.numValues)
+	}
+
+	bscale := math.Pow(2, float64(d.binaryScale))
+	dscale := math.Pow(10, -float64(d.decimalScale))
+
+	values := make([]float64, d.numValues)
+	for i := range values {
+		values[i] = (d.reference + float64(samples[i])*bscale) * dscale
+	}
+	return values, nil
+}
+
+// decodePNG returns the samples of a PNG image (template 5.41). Depths up
+// to 16 bits are stored as grey levels, 24 and 32 bits as RGB(A) pixels.
+// Encoders may store fewer bits per value than the image depth, so grey
+// levels are scaled by the depth in the PNG header, not section 5.
+func decodePNG(data []byte) ([]int64, error) {
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("png: %v", err)
+	}
+
+	bounds := img.Bounds()
+	samples := make([]int64, 0, bounds.Dx()*bounds.Dy())
+
+	switch m := img.(type) {
+	case *image.Gray:
+		// The PNG decoder scales 1, 2 and 4 bit grey levels to 8 bits. The
+		// depth is the byte after the width and height of the IHDR chunk,
+		// which the decoder has checked to be the first.
+		divisor := int64(1)
+		if depth := data[24]; depth < 8 {
+			divisor = 255 / (int64(1)<<depth - 1)
+		}
+		for _, v := range m.Pix {
+			samples = append(samples, int64(v)/divisor)
+		}
+	case *image.Gray16:
+		for i := 0; i+1 < len(m.Pix); i += 2 {
+			samples = append(samples, int64(m.Pix[i])<<8|int64(m.Pix[i+1]))
+		}
+	case *image.RGBA:
+		for i := 0; i+3 < len(m.Pix); i += 4 {
+			samples = append(samples, int64(m.Pix[i])<<16|int64(m.Pix[i+1])<<8|int64(m.Pix[i+2]))
+		}
+	case *image.NRGBA:
+		for i := 0; i+3 < len(m.Pix); i += 4 {
+			samples = append(samples, int64(m.Pix[i])<<24|int64(m.Pix[i+1])<<16|int64(m.Pix[i+2])<<8|int64(m.Pix[i+3]))
+		}
+	default:
+		return nil, fmt.Errorf("png: unsupported image type %T", img)
+	}
+
+	return samples, nil
+}
+
+func (d dataRepresentation) unpackSimple(data []byte) ([]float64, error) {
+	if d.bits == 0 {
+		return d.constant(), nil
+	}
+
+	if len(data)*8 < d.numValues*d.bits {
+		return nil, fmt.Errorf("data section holds %d bits, need %d", len(data)*8, d.numValues*d.bits)
+	}
+
+	bscale := math.Pow(2, float64(d.binaryScale))
+	dscale := math.Pow(10, -float64(d.decimalScale))
+
+	values := make([]float64, d.numValues)
+	r := newBitReader(data)
+	for i := range values {
+		values[i] = (d.reference + float64(r.read(d.bits))*bscale) * dscale
+	}
+	return values, nil
+}
+
+func (d dataRepresentation) unpackComplex(data []byte) ([]float64, error) {
+	r := newBitReader(data)
+
+	var firstValues [2]int64
+	var minDifference int64
+	if d.template == 3 {
+		if d.spatialOrder != 1 && d.spatialOrder != 2 {
+			return nil, fmt.Errorf("unsupported order %d of spatial differencing", d.spatialOrder)
+		}
+
+		n := d.extraOctets
+		if len(data) < (d.spatialOrder+1)*n {
+			return nil, fmt.Errorf("data section too short for spatial differencing descriptors")
+		}
+		for i := 0; i < d.spatialOrder; i++ {
+			firstValues[i] = int64(signMagnitude(data[i*n : (i+1)*n]))
+		}
+		minDifference = int64(signMagnitude(data[d.spatialOrder*n : (d.spatialOrder+1)*n]))
+		r.pos = (d.spatialOrder + 1) * n * 8
+	}
+
+	groups := d.numGroups
+	references := make([]int64, groups)
+	for i := range references {
+		references[i] = int64(r.read(d.bits))
+	}
+	r.align()
+
+	widths := make([]int, groups)
+	for i := range widths {
+		widths[i] = d.widthReference + int(r.read(d.widthBits))
+	}
+	r.align()
+
+	lengths := make([]int, groups)
+	total := 0
+	for i := range lengths {
+		lengths[i] = d.lengthReference + int(r.read(d.lengthBits))*d.lengthIncrement
+		if i == groups-1 {
+			lengths[i] = d.lastGroupLength
+		}
+		total += lengths[i]
+	}
+	r.align()
+
+	if total != d.numValues {
+		return nil, fmt.Errorf("group lengths add up to %d values, expected %d", total, d.numValues)
+	}
+
+	packedBits := 0
+	for i := range widths {
+		packedBits += widths[i] * lengths[i]
+	}
+	if r.remaining() < packedBits {
+		return nil, fmt.Errorf("data section holds %d bits, need %d", r.remaining(), packedBits)
+	}
+
+	// Decode the integers, keeping the non-missing ones contiguous so the
+	// spatial differencing can be undone over them.
+	missing := make([]bool, d.numValues)
+	present := make([]int64, 0, d.numValues)
+	index := 0
+	for g := 0; g < groups; g++ {
+		width := widths[g]
+		reference := references[g]
+		for j := 0; j < lengths[g]; j++ {
+			if width == 0 {
+				if d.isMissing(reference, d.bits) {
+					missing[index] = true
+				} else {
+					present = append(present, reference)
+				}
+			} else {
+				x := int64(r.read(width))
+				if d.isMissing(x, width) {
+					missing[index] = true
+				} else {
+					present = append(present, reference+x)
+				}
+			}
+			index++
+		}
+	}
+
+	if d.template == 3 {
+		undoSpatialDifferencing(present, d.spatialOrder, firstValues, minDifference)
+	}
+
+	values := make([]float64, d.numValues)
+	bscale := math.Pow(2, float64(d.binaryScale))
+	dscale := math.Pow(10, -float64(d.decimalScale))
+
+	next := 0
+	for i := range values {
+		if missing[i] {
+			values[i] = math.NaN()
+			continue
+		}
+		values[i] = (d.reference + float64(present[next])*bscale) * dscale
+		next++
+	}
+
+	return values, nil
+}
+
+// isMissing reports whether x, stored with the given width, is one of the
+// missing value substitutes of complex packing (all ones, or all ones minus one).
+func (d dataRepresentation) isMissing(x int64, width int) bool {
+	if d.missingManagement == 0 || width == 0 {
+		return false
+	}
+	allOnes := int64(1)<<uint(width) - 1
+	if x == allOnes {
+		return true
+	}
+	return d.missingManagement == 2 && x == allOnes-1
+}
+
+func undoSpatialDifferencing(values []int64, order int, firstValues [2]int64, minDifference int64) {
+	if len(values) == 0 {
+		return
+	}
+
+	switch order {
+	case 1:
+		values[0] = firstValues[0]
+		for i := 1; i < len(values); i++ {
+			values[i] += minDifference + values[i-1]
+		}
+	case 2:
+		values[0] = firstValues[0]
+		if len(values) < 2 {
+			return
+		}
+		values[1] = firstValues[1]
+		for i := 2; i < len(values); i++ {
+			values[i] += minDifference + 2*values[i-1] - values[i-2]
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package parser
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// encodePNG encodes samples with image/png the way ecCodes lays them out:
+// grey levels up to 16 bits per value, RGB pixels for 24 bits and RGBA
+// pixels for 32 bits.
+func encodePNG(t *testing.T, w, h int, samples []int64, bits int) []byte {
+	t.Helper()
+	r := image.Rect(0, 0, w, h)
+	var img image.Image
+	switch {
+	case bits <= 8:
+		m := image.NewGray(r)
+		for i, v := range samples {
+			m.Pix[i] = byte(v)
+		}
+		img = m
+	case bits <= 16:
+		m := image.NewGray16(r)
+		for i, v := range samples {
+			binary.BigEndian.PutUint16(m.Pix[2*i:], uint16(v))
+		}
+		img = m
+	case bits <= 24:
+		m := image.NewRGBA(r)
+		for i, v := range samples {
+			copy(m.Pix[4*i:], []byte{byte(v >> 16), byte(v >> 8), byte(v), 255})
+		}
+		img = m
+	default:
+		m := image.NewNRGBA(r)
+		for i, v := range samples {
+			binary.BigEndian.PutUint32(m.Pix[4*i:], uint32(v))
+		}
+		img = m
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// encodeGrayPNG writes a greyscale PNG of depth 1, 2 or 4, which image/png
+// cannot encode.
+func encodeGrayPNG(t *testing.T, w, h int, samples []int64, depth int) []byte {
+	t.Helper()
+	var raw bytes.Buffer
+	for y := 0; y < h; y++ {
+		var row testBitWriter
+		for _, v := range samples[y*w : (y+1)*w] {
+			row.write(uint64(v), depth)
+		}
+		row.align()
+		raw.WriteByte(0) // no filter
+		raw.Write(row.buf)
+	}
+
+	var idat bytes.Buffer
+	z := zlib.NewWriter(&idat)
+	if _, err := z.Write(raw.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ihdr := binary.BigEndian.AppendUint32(nil, uint32(w))
+	ihdr = binary.BigEndian.AppendUint32(ihdr, uint32(h))
+	ihdr = append(ihdr, byte(depth), 0, 0, 0, 0)
+
+	b := []byte("\x89PNG\r\n\x1a\n")
+	for _, c := range []struct {
+		typ  string
+		data []byte
+	}{{"IHDR", ihdr}, {"IDAT", idat.Bytes()}, {"IEND", nil}} {
+		chunk := append([]byte(c.typ), c.data...)
+		b = binary.BigEndian.AppendUint32(b, uint32(len(c.data)))
+		b = append(b, chunk...)
+		b = binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(chunk))
+	}
+	return b
+}
+
+func TestDecodePNG(t *testing.T) {
+	const w, h = 13, 7
+	tests := []struct {
+		name  string
+		bits  int
+		depth int // encoded with encodeGrayPNG if set
+	}{
+		{"1 bit", 1, 1},
+		{"2 bits", 2, 2},
+		{"4 bits", 4, 4},
+		{"6 bits in 8 bit grey", 6, 0},
+		{"8 bits", 8, 0},
+		{"12 bits in 16 bit grey", 12, 0},
+		{"16 bits", 16, 0},
+		{"24 bits", 24, 0},
+		{"32 bits", 32, 0},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples := testImage(w, h, tt.bits, int64(i))
+			var data []byte
+			if tt.depth != 0 {
+				data = encodeGrayPNG(t, w, h, samples, tt.depth)
+			} else {
+				data = encodePNG(t, w, h, samples, tt.bits)
+			}
+			got, err := decodePNG(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSamples(t, got, samples)
+		})
+	}
+}
+
+func TestDecodePNGMalformed(t *testing.T) {
+	data := encodePNG(t, 4, 3, testValues(12, 200), 8)
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)-20] ^= 0xff
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not a PNG", []byte("GRIB data section")},
+		{"truncated", data[:len(data)/2]},
+		{"bad checksum", corrupt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodePNG(tt.data); err == nil {
+				t.Error("malformed stream decoded")
+			}
+		})
+	}
+}
+
+func TestNativePNG(t *testing.T) {
+	grid := testGrid{nx: 37, ny: 29, la1: 60, lo1: -10, la2: 32, lo2: 26}
+	for _, bits := range []int{4, 12, 24} {
+		samples := testImage(grid.nx, grid.ny, bits, 1)
+		sec5, data := packPNG(t, grid, samples, bits, 2000, -3, 1)
+		g, err := decodeNative(testMessage(grid, sec5, nil, data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkValues(t, g.DataValues, unpacked(samples, nil, 2000, -3, 1))
+	}
+
+	sec5, data := packPNG(t, testGrid4x3, testValues(12, 200), 8, 0, 0, 0)
+	if _, err := decodeNative(testMessage(testGrid4x3, sec5, nil, data[:len(data)-12])); err == nil {
+		t.Error("truncated PNG stream decoded")
+	}
+}
+
+// packPNG packs integers with PNG packing (template 5.41).
+func packPNG(t *testing.T, grid testGrid, samples []int64, bits int, reference float32, binaryScale, decimalScale int) (sec5, data []byte) {
+	data = encodePNG(t, grid.nx, grid.ny, samples, bits)
+	return testDataRepresentation(41, len(samples), reference, binaryScale, decimalScale, bits), data
+}
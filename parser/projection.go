@@ -0,0 +1,108 @@
+package parser
+
+import "math"
+
+// gridProjection maps geographic coordinates to the plane of a projected
+// grid, in metres, and back.
+type gridProjection interface {
+	forward(lat, lon float64) (x, y float64)
+	inverse(x, y float64) (lat, lon float64)
+}
+
+// earthShape returns the semi-major and semi-minor axes in metres of the
+// shape of the Earth given by code table 3.2. radius, major and minor are the
+// decoded values of the optional radius and axis fields of the template.
+func earthShape(shape int, radius, major, minor float64) (float64, float64) {
+	switch shape {
+	case 0:
+		return 6367470, 6367470
+	case 1:
+		if radius > 0 {
+			return radius, radius
+		}
+	case 2:
+		return 6378160, 6356775
+	case 3:
+		if major > 0 && minor > 0 {
+			return major * 1000, minor * 1000
+		}
+	case 4:
+		return 6378137, 6356752.314
+	case 5:
+		return 6378137, 6356752.3142
+	case 7:
+		if major > 0 && minor > 0 {
+			return major, minor
+		}
+	case 8:
+		return 6371200, 6371200
+	case 9:
+		return 6377563.396, 6356256.909
+	}
+	return 6371229, 6371229
+}
+
+// scaledEarthValue decodes a scale factor and scaled value pair of the shape
+// of the Earth fields, returning 0 if either is missing.
+func scaledEarthValue(scaleFactor, value int) float64 {
+	if scaleFactor == 0xFF || value == missingUint32 {
+		return 0
+	}
+	return float64(value) * math.Pow(10, -float64(scaleFactor))
+}
+
+// earth returns the semi-major axis and eccentricity of the header's Earth.
+func (h GribHeader) earth() (float64, float64) {
+	a, b := h.EarthMajorAxis, h.EarthMinorAxis
+	if a <= 0 {
+		a, b = earthShape(6, 0, 0, 0)
+	}
+	if b <= 0 || b >= a {
+		return a, 0
+	}
+	return a, math.Sqrt(1 - (b*b)/(a*a))
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func degrees(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// normalizeLongitude returns lon in the range [-180, 180).
+func normalizeLongitude(lon float64) float64 {
+	lon = math.Mod(lon+180, 360)
+	if lon < 0 {
+		lon += 360
+	}
+	return lon - 180
+}
+
+// conformalT is the function t of the conformal projections (Snyder 15-9),
+// which reduces to tan(pi/4 - phi/2) on a sphere.
+func conformalT(phi, e float64) float64 {
+	es := e * math.Sin(phi)
+	return math.Tan(math.Pi/4-phi/2) / math.Pow((1-es)/(1+es), e/2)
+}
+
+// conformalM is the function m of the conformal projections (Snyder 14-15).
+func conformalM(phi, e float64) float64 {
+	s := math.Sin(phi)
+	return math.Cos(phi) / math.Sqrt(1-e*e*s*s)
+}
+
+// latitudeFromT inverts conformalT (Snyder 7-9).
+func latitudeFromT(t, e float64) float64 {
+	phi := math.Pi/2 - 2*math.Atan(t)
+	for i := 0; i < 15 && e > 0; i++ {
+		es := e * math.Sin(phi)
+		next := math.Pi/2 - 2*math.Atan(t*math.Pow((1-es)/(1+es), e/2))
+		if math.Abs(next-phi) < 1e-12 {
+			return next
+		}
+		phi = next
+	}
+	return phi
+}
@@ -0,0 +1,15 @@
+//go:build !cgo || purego
+
+package parser
+
+func ProcessGRIB(gribData []byte) (GRIBFile, error) {
+	if len(gribData) == 0 {
+		return GRIBFile{}, ErrEmptyMessage
+	}
+
+	parsedGrib, err := decodeNative(gribData)
+	if err != nil {
+		return GRIBFile{}, decodeError(err)
+	}
+	return parsedGrib, nil
+}
@@ -0,0 +1,57 @@
+package parser
+
+import "math"
+
+// rotatedPole converts between geographic coordinates and the coordinates of
+// a rotated latitude/longitude grid (template 3.1), whose south pole lies at
+// SouthPoleLat/SouthPoleLon and which is then rotated by RotationAngle about
+// its polar axis.
+type rotatedPole struct {
+	sinTheta, cosTheta float64
+	southPoleLon       float64
+	angle              float64
+}
+
+func newRotatedPole(h GribHeader) rotatedPole {
+	theta := radians(90 + h.SouthPoleLat)
+	return rotatedPole{
+		sinTheta:     math.Sin(theta),
+		cosTheta:     math.Cos(theta),
+		southPoleLon: h.SouthPoleLon,
+		angle:        h.RotationAngle,
+	}
+}
+
+// rotate returns the rotated coordinates of a geographic point.
+func (r rotatedPole) rotate(lat, lon float64) (float64, float64) {
+	phi := radians(lat)
+	lambda := radians(lon - r.southPoleLon)
+
+	x := math.Cos(phi) * math.Cos(lambda)
+	y := math.Cos(phi) * math.Sin(lambda)
+	z := math.Sin(phi)
+
+	xr := r.cosTheta*x + r.sinTheta*z
+	zr := -r.sinTheta*x + r.cosTheta*z
+
+	latR := degrees(math.Asin(math.Max(-1, math.Min(1, zr))))
+	lonR := degrees(math.Atan2(y, xr)) - r.angle
+	return latR, normalizeLongitude(lonR)
+}
+
+// unrotate returns the geographic coordinates of a rotated point.
+func (r rotatedPole) unrotate(latR, lonR float64) (float64, float64) {
+	phi := radians(latR)
+	lambda := radians(lonR + r.angle)
+
+	xr := math.Cos(phi) * math.Cos(lambda)
+	y := math.Cos(phi) * math.Sin(lambda)
+	zr := math.Sin(phi)
+
+	x := r.cosTheta*xr - r.sinTheta*zr
+	z := r.sinTheta*xr + r.cosTheta*zr
+
+	lat := degrees(math.Asin(math.Max(-1, math.Min(1, z))))
+	lon := degrees(math.Atan2(y, x)) + r.southPoleLon
+	return lat, normalizeLongitude(lon)
+}
@@ -0,0 +1,120 @@
+package parser
+
+// Scanning mode flags (flag table 3.4).
+const (
+	scanNegativeI   = 0x80 // points of a row scan from east to west
+	scanPositiveJ   = 0x40 // rows scan from south to north
+	scanConsecutive = 0x20 // consecutive points run along columns
+	scanAlternate   = 0x10 // adjacent rows scan in opposite directions
+)
+
+// canonicalScan reorders the values of a grid into scanning mode 0, with the
+// first point in the north west, points running from west to east and rows
+// from north to south, and returns the header of the reordered grid.
+func canonicalScan(h GribHeader, values []float64) (GribHeader, []float64) {
+	mode := h.ScanMode & (scanNegativeI | scanPositiveJ | scanConsecutive | scanAlternate)
+	if mode == 0 || h.GridType == GridUnstructured {
+		return h, values
+	}
+
+	if len(h.PL) > 0 {
+		return canonicalReducedScan(h, values, mode)
+	}
+
+	nx, ny := h.Nx, h.Ny
+	reordered := make([]float64, len(values))
+	for j := 0; j < ny; j++ {
+		sj := j
+		if mode&scanPositiveJ != 0 {
+			sj = ny - 1 - j
+		}
+		for i := 0; i < nx; i++ {
+			si := i
+			if mode&scanNegativeI != 0 {
+				si = nx - 1 - i
+			}
+
+			var k int
+			switch {
+			case mode&scanConsecutive != 0 && mode&scanAlternate != 0 && si%2 == 1:
+				k = si*ny + ny - 1 - sj
+			case mode&scanConsecutive != 0:
+				k = si*ny + sj
+			case mode&scanAlternate != 0 && sj%2 == 1:
+				k = sj*nx + nx - 1 - si
+			default:
+				k = sj*nx + si
+			}
+			reordered[j*nx+i] = values[k]
+		}
+	}
+
+	return canonicalCorners(h, mode), reordered
+}
+
+// canonicalReducedScan reorders the rows of a reduced grid, which always
+// hold their points consecutively.
+func canonicalReducedScan(h GribHeader, values []float64, mode int) (GribHeader, []float64) {
+	rows := make([][]float64, len(h.PL))
+	offset := 0
+	for j, n := range h.PL {
+		row := append([]float64(nil), values[offset:offset+n]...)
+		offset += n
+
+		reverse := mode&scanNegativeI != 0
+		if mode&scanAlternate != 0 && j%2 == 1 {
+			reverse = !reverse
+		}
+		if reverse {
+			for a, b := 0, len(row)-1; a < b; a, b = a+1, b-1 {
+				row[a], row[b] = row[b], row[a]
+			}
+		}
+		rows[j] = row
+	}
+
+	pl := append([]int(nil), h.PL...)
+	if mode&scanPositiveJ != 0 {
+		for a, b := 0, len(rows)-1; a < b; a, b = a+1, b-1 {
+			rows[a], rows[b] = rows[b], rows[a]
+			pl[a], pl[b] = pl[b], pl[a]
+		}
+	}
+
+	reordered := make([]float64, 0, len(values))
+	for _, row := range rows {
+		reordered = append(reordered, row...)
+	}
+
+	h = canonicalCorners(h, mode)
+	h.PL = pl
+	return h, reordered
+}
+
+// canonicalCorners moves the first and last grid points of a header to the
+// corners they occupy in scanning mode 0.
+func canonicalCorners(h GribHeader, mode int) GribHeader {
+	if h.projection() != nil {
+		// Locate the corners with the scanning mode of the message.
+		g := GRIBFile{Header: h}
+		x0, y0 := 0, 0
+		if mode&scanNegativeI != 0 {
+			x0 = h.Nx - 1
+		}
+		if mode&scanPositiveJ != 0 {
+			y0 = h.Ny - 1
+		}
+		h.La1, h.Lo1 = g.GetLatLng(x0, y0)
+		h.La2, h.Lo2 = g.GetLatLng(h.Nx-1-x0, h.Ny-1-y0)
+	} else {
+		if mode&scanNegativeI != 0 {
+			h.Lo1, h.Lo2 = h.Lo2, h.Lo1
+		}
+		if mode&scanPositiveJ != 0 {
+			h.La1, h.La2 = h.La2, h.La1
+		}
+	}
+
+	h.ScanMode &^= mode
+	return h
+}
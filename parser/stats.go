@@ -0,0 +1,36 @@
+package parser
+
+type Stats struct {
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+	Mean    float64 `json:"mean"`
+	Valid   int     `json:"valid"`
+	Missing int     `json:"missing"`
+}
+
+// Stats summarises DataValues, ignoring points equal to the missing value.
+func (g GRIBFile) Stats() Stats {
+	var stats Stats
+	var sum float64
+
+	for _, v := range g.DataValues {
+		if v == g.Header.MissingValue {
+			stats.Missing++
+			continue
+		}
+
+		if stats.Valid == 0 || v < stats.Min {
+			stats.Min = v
+		}
+		if stats.Valid == 0 || v > stats.Max {
+			stats.Max = v
+		}
+		sum += v
+		stats.Valid++
+	}
+
+	if stats.Valid > 0 {
+		stats.Mean = sum / float64(stats.Valid)
+	}
+	return stats
+}
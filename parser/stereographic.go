@@ -0,0 +1,61 @@
+package parser
+
+import "math"
+
+// polarStereographic is the polar stereographic projection of grid
+// definition template 3.20 (Snyder, Map Projections, chapter 21).
+// Projections centred on the south pole are computed as their mirror image
+// about the equator.
+type polarStereographic struct {
+	e     float64
+	south bool
+	lov   float64 // central meridian, radians
+	scale float64 // rho = scale * t
+}
+
+func newPolarStereographic(h GribHeader) polarStereographic {
+	a, e := h.earth()
+	p := polarStereographic{
+		e:     e,
+		south: h.ProjectionCentre&0x80 != 0,
+		lov:   radians(h.LoV),
+	}
+
+	// Dx and Dy are true at latitude LaD, which has the sign of the pole.
+	phiC := radians(math.Abs(h.LaD))
+	if math.Abs(phiC-math.Pi/2) < 1e-10 {
+		p.scale = 2 * a / math.Sqrt(math.Pow(1+e, 1+e)*math.Pow(1-e, 1-e))
+	} else {
+		p.scale = a * conformalM(phiC, e) / conformalT(phiC, e)
+	}
+	return p
+}
+
+func (p polarStereographic) forward(lat, lon float64) (float64, float64) {
+	lambda := radians(normalizeLongitude(lon - degrees(p.lov)))
+	phi := radians(lat)
+	if p.south {
+		phi, lambda = -phi, -lambda
+	}
+
+	rho := p.scale * conformalT(phi, p.e)
+	x, y := rho*math.Sin(lambda), -rho*math.Cos(lambda)
+	if p.south {
+		return -x, -y
+	}
+	return x, y
+}
+
+func (p polarStereographic) inverse(x, y float64) (float64, float64) {
+	if p.south {
+		x, y = -x, -y
+	}
+
+	phi := latitudeFromT(math.Hypot(x, y)/p.scale, p.e)
+	lambda := math.Atan2(x, -y)
+	if p.south {
+		phi, lambda = -phi, -lambda
+	}
+
+	return degrees(phi), normalizeLongitude(degrees(lambda + p.lov))
+}
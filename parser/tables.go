@@ -0,0 +1,167 @@
+package parser
+
+type parameterKey struct {
+	discipline, category, number int
+}
+
+// shortNames follows the ecCodes shortName concept for the WMO parameters
+// most commonly found in operational model output.
+var shortNames = map[parameterKey]string{
+	{0, 0, 0}:  "t",
+	{0, 0, 1}:  "vtmp",
+	{0, 0, 2}:  "pt",
+	{0, 0, 4}:  "tmax",
+	{0, 0, 5}:  "tmin",
+	{0, 0, 6}:  "dpt",
+	{0, 0, 10}: "lhtfl",
+	{0, 0, 11}: "shtfl",
+	{0, 0, 17}: "skt",
+	{0, 1, 0}:  "q",
+	{0, 1, 1}:  "r",
+	{0, 1, 3}:  "pwat",
+	{0, 1, 7}:  "prate",
+	{0, 1, 8}:  "tp",
+	{0, 1, 11}: "sde",
+	{0, 1, 13}: "sdwe",
+	{0, 1, 19}: "ptype",
+	{0, 1, 22}: "clwmr",
+	{0, 1, 52}: "tprate",
+	{0, 1, 64}: "tciwv",
+	{0, 1, 65}: "rprate",
+	{0, 1, 66}: "sprate",
+	{0, 2, 0}:  "wdir",
+	{0, 2, 1}:  "ws",
+	{0, 2, 2}:  "u",
+	{0, 2, 3}:  "v",
+	{0, 2, 8}:  "w",
+	{0, 2, 10}: "absv",
+	{0, 2, 12}: "vo",
+	{0, 2, 13}: "d",
+	{0, 2, 22}: "gust",
+	{0, 3, 0}:  "pres",
+	{0, 3, 1}:  "prmsl",
+	{0, 3, 4}:  "z",
+	{0, 3, 5}:  "gh",
+	{0, 3, 18}: "blh",
+	{0, 4, 7}:  "dswrf",
+	{0, 4, 8}:  "uswrf",
+	{0, 5, 3}:  "dlwrf",
+	{0, 5, 4}:  "ulwrf",
+	{0, 6, 1}:  "tcc",
+	{0, 6, 3}:  "lcc",
+	{0, 6, 4}:  "mcc",
+	{0, 6, 5}:  "hcc",
+	{0, 6, 22}: "ccl",
+	{0, 7, 0}:  "pli",
+	{0, 7, 6}:  "cape",
+	{0, 7, 7}:  "cin",
+	{0, 7, 8}:  "hlcy",
+	{0, 14, 0}: "tozne",
+	{0, 19, 0}: "vis",
+	{2, 0, 0}:  "lsm",
+	{2, 0, 7}:  "mterh",
+	{10, 2, 0}: "ci",
+	{10, 3, 0}: "sst",
+}
+
+// centreShortNames holds the names of parameters in the local part of the
+// tables (numbers 192 to 254), by originating centre.
+var centreShortNames = map[int]map[parameterKey]string{
+	7: { // NCEP
+		{0, 16, 195}: "refd",
+		{0, 16, 196}: "refc",
+	},
+}
+
+type levelParameterKey struct {
+	parameterKey
+	typeOfLevel int
+	level       int
+}
+
+// levelShortNames holds the names ecCodes gives to parameters on specific levels.
+var levelShortNames = map[levelParameterKey]string{
+	{parameterKey{0, 0, 0}, 103, 2}:   "2t",
+	{parameterKey{0, 0, 4}, 103, 2}:   "mx2t",
+	{parameterKey{0, 0, 5}, 103, 2}:   "mn2t",
+	{parameterKey{0, 0, 6}, 103, 2}:   "2d",
+	{parameterKey{0, 1, 0}, 103, 2}:   "2sh",
+	{parameterKey{0, 1, 1}, 103, 2}:   "2r",
+	{parameterKey{0, 2, 0}, 103, 10}:  "10wdir",
+	{parameterKey{0, 2, 1}, 103, 10}:  "10si",
+	{parameterKey{0, 2, 2}, 103, 10}:  "10u",
+	{parameterKey{0, 2, 3}, 103, 10}:  "10v",
+	{parameterKey{0, 2, 2}, 103, 100}: "100u",
+	{parameterKey{0, 2, 3}, 103, 100}: "100v",
+	{parameterKey{0, 3, 0}, 1, 0}:     "sp",
+	{parameterKey{0, 3, 0}, 101, 0}:   "msl",
+	{parameterKey{0, 3, 5}, 1, 0}:     "orog",
+}
+
+// shortName returns the ecCodes shortName of a parameter of a message from
+// the given originating centre, or "unknown".
+func shortName(centre, discipline, category, number, surfaceType, level int) string {
+	key := parameterKey{discipline, category, number}
+	if name, ok := levelShortNames[levelParameterKey{key, surfaceType, level}]; ok {
+		return name
+	}
+	if name, ok := shortNames[key]; ok {
+		return name
+	}
+	if name, ok := centreShortNames[centre][key]; ok {
+		return name
+	}
+	return unknownShortName
+}
+
+// surfaceNames maps fixed surface types (code table 4.5) to ecCodes typeOfLevel names.
+var surfaceNames = map[int]string{
+	1:   "surface",
+	2:   "cloudBase",
+	3:   "cloudTop",
+	4:   "isothermZero",
+	5:   "adiabaticCondensation",
+	6:   "maxWind",
+	7:   "tropopause",
+	8:   "nominalTop",
+	10:  "entireAtmosphere",
+	20:  "isothermal",
+	100: "isobaricInhPa",
+	101: "meanSea",
+	102: "heightAboveSea",
+	103: "heightAboveGround",
+	104: "sigma",
+	105: "hybrid",
+	106: "depthBelowLand",
+	107: "theta",
+	108: "pressureFromGround",
+	109: "potentialVorticity",
+	150: "generalVertical",
+	160: "depthBelowSea",
+	200: "entireAtmosphere",
+	220: "planetaryBoundaryLayer",
+}
+
+// layerNames is used when a second fixed surface of the same type is present.
+var layerNames = map[int]string{
+	100: "isobaricLayer",
+	103: "heightAboveGroundLayer",
+	105: "hybridLayer",
+	106: "depthBelowLandLayer",
+	108: "pressureFromGroundLayer",
+}
+
+func typeOfLevel(firstType, secondType int, firstValue float64) string {
+	if secondType == firstType {
+		if name, ok := layerNames[firstType]; ok {
+			return name
+		}
+	}
+	if firstType == 100 && firstValue < 100 {
+		return "isobaricInPa"
+	}
+	if name, ok := surfaceNames[firstType]; ok {
+		return name
+	}
+	return "unknown"
+}
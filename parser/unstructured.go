@@ -0,0 +1,341 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// UnstructuredGrid holds the cell centres of an unstructured grid (template
+// 3.101) such as the ICON icosahedral grid, whose coordinates are not part of
+// the GRIB messages but of a separate grid file. A k-d tree over the cell
+// centres finds the cells around a point.
+type UnstructuredGrid struct {
+	UUID   string // uuidOfHGrid, empty if the grid file does not name it
+	Number int    // numberOfGridUsed, 0 if unknown
+
+	lats, lons []float64
+	points     [][3]float64 // cell centres on the unit sphere
+	tree       []int32      // cell indices in k-d tree order
+	spacing    float64      // largest chord between neighbouring cells
+
+	minLat, minLon, maxLat, maxLon float64
+}
+
+// LoadUnstructuredGrid reads the cell centres of an unstructured grid from a
+// grid file. This is either an ICON grid file in NetCDF classic format with
+// clat and clon variables, or a GRIB file holding the CLAT and CLON fields
+// that DWD publishes with its ICON output.
+func LoadUnstructuredGrid(filename string) (*UnstructuredGrid, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var grid *UnstructuredGrid
+	if bytes.HasPrefix(data, []byte("GRIB")) {
+		grid, err = gridFromGRIB(data)
+	} else {
+		grid, err = gridFromNetCDF(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return grid, nil
+}
+
+// gridFromNetCDF reads an ICON grid file, whose cell coordinates are in radians.
+func gridFromNetCDF(data []byte) (*UnstructuredGrid, error) {
+	f, err := parseNetCDF(data)
+	if err != nil {
+		return nil, err
+	}
+
+	coords := [2][]float64{}
+	for i, name := range []string{"clat", "clon"} {
+		values, err := f.floats(name)
+		if err != nil {
+			return nil, err
+		}
+		if units := f.vars[name].attrs["units"].text(); !strings.HasPrefix(units, "deg") {
+			for j := range values {
+				values[j] = degrees(values[j])
+			}
+		}
+		coords[i] = values
+	}
+
+	number, _ := f.attrs["number_of_grid_used"].int()
+	return newUnstructuredGrid(coords[0], coords[1], strings.ReplaceAll(f.attrs["uuidOfHGrid"].text(), "-", ""), number)
+}
+
+// gridFromGRIB reads the CLAT and CLON fields (parameters 0.191.1 and
+// 0.191.2 of the DWD local tables), whose values are in degrees.
+func gridFromGRIB(data []byte) (*UnstructuredGrid, error) {
+	gribFiles, err := ProcessGRIBMessages(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var lat, lon *GRIBFile
+	for i := range gribFiles {
+		h := gribFiles[i].Header
+		if gribFiles[i].Err != nil || h.GridType != GridUnstructured || h.Discipline != 0 || h.ParameterCategory != 191 {
+			continue
+		}
+		switch h.ParameterNumber {
+		case 1:
+			lat = &gribFiles[i]
+		case 2:
+			lon = &gribFiles[i]
+		}
+	}
+	if lat == nil || lon == nil {
+		return nil, errors.New("no CLAT and CLON fields on an unstructured grid found")
+	}
+
+	return newUnstructuredGrid(lat.DataValues, lon.DataValues, lat.Header.GridUUID, lat.Header.GridNumber)
+}
+
+func newUnstructuredGrid(lats, lons []float64, uuid string, number int) (*UnstructuredGrid, error) {
+	if len(lats) != len(lons) {
+		return nil, fmt.Errorf("%d latitudes for %d longitudes", len(lats), len(lons))
+	}
+	if len(lats) == 0 {
+		return nil, errors.New("grid without cells")
+	}
+
+	// Normalize a copy, the longitudes may be the values of a message.
+	lons = append([]float64(nil), lons...)
+
+	g := &UnstructuredGrid{
+		UUID:   strings.ToLower(uuid),
+		Number: number,
+		lats:   lats,
+		lons:   lons,
+		points: make([][3]float64, len(lats)),
+		tree:   make([]int32, len(lats)),
+		minLat: math.Inf(1),
+		minLon: math.Inf(1),
+		maxLat: math.Inf(-1),
+		maxLon: math.Inf(-1),
+	}
+
+	for i := range lats {
+		lons[i] = normalizeLongitude(lons[i])
+		g.points[i] = unitVector(lats[i], lons[i])
+		g.tree[i] = int32(i)
+
+		g.minLat, g.maxLat = math.Min(g.minLat, lats[i]), math.Max(g.maxLat, lats[i])
+		g.minLon, g.maxLon = math.Min(g.minLon, lons[i]), math.Max(g.maxLon, lons[i])
+	}
+	g.build(0, len(g.tree), 0)
+
+	// Estimate the cell spacing from the nearest neighbours of a sample of
+	// cells, to tell points just outside the cells at the edge of a limited
+	// area grid from points beyond it.
+	step := max(1, len(lats)/1000)
+	for i := 0; i < len(lats); i += step {
+		if near := g.nearest(g.points[i], 2); len(near) == 2 {
+			g.spacing = math.Max(g.spacing, math.Sqrt(near[1].dist))
+		}
+	}
+	if g.spacing == 0 {
+		g.spacing = 2
+	}
+
+	return g, nil
+}
+
+// Len returns the number of cells of the grid.
+func (g *UnstructuredGrid) Len() int {
+	return len(g.lats)
+}
+
+func unitVector(lat, lon float64) [3]float64 {
+	phi, lambda := radians(lat), radians(lon)
+	return [3]float64{math.Cos(phi) * math.Cos(lambda), math.Cos(phi) * math.Sin(lambda), math.Sin(phi)}
+}
+
+// build orders tree[lo:hi] as a balanced k-d tree, whose root is the median
+// along the axis of the given depth.
+func (g *UnstructuredGrid) build(lo, hi, depth int) {
+	if hi-lo < 2 {
+		return
+	}
+
+	axis := depth % 3
+	mid := (lo + hi) / 2
+	g.selectMedian(lo, hi, mid, axis)
+	g.build(lo, mid, depth+1)
+	g.build(mid+1, hi, depth+1)
+}
+
+// selectMedian partially sorts tree[lo:hi] along axis so that tree[k] holds
+// the cell that would be there if it were fully sorted.
+func (g *UnstructuredGrid) selectMedian(lo, hi, k, axis int) {
+	tree := g.tree
+	coord := func(i int) float64 { return g.points[tree[i]][axis] }
+
+	hi--
+	for lo < hi {
+		pivot := coord((lo + hi) / 2)
+		i, j := lo, hi
+		for i <= j {
+			for coord(i) < pivot {
+				i++
+			}
+			for coord(j) > pivot {
+				j--
+			}
+			if i <= j {
+				tree[i], tree[j] = tree[j], tree[i]
+				i++
+				j--
+			}
+		}
+		switch {
+		case k <= j:
+			hi = j
+		case k >= i:
+			lo = i
+		default:
+			return
+		}
+	}
+}
+
+// neighbour is a cell and its squared chord distance to a point.
+type neighbour struct {
+	cell int
+	dist float64
+}
+
+// nearest returns up to k cells nearest to p, closest first.
+func (g *UnstructuredGrid) nearest(p [3]float64, k int) []neighbour {
+	found := make([]neighbour, 0, k+1)
+
+	var search func(lo, hi, depth int)
+	search = func(lo, hi, depth int) {
+		if lo >= hi {
+			return
+		}
+
+		mid := (lo + hi) / 2
+		cell := int(g.tree[mid])
+		q := g.points[cell]
+		dx, dy, dz := p[0]-q[0], p[1]-q[1], p[2]-q[2]
+		dist := dx*dx + dy*dy + dz*dz
+
+		if len(found) < k || dist < found[len(found)-1].dist {
+			i := sort.Search(len(found), func(i int) bool { return found[i].dist > dist })
+			found = append(found, neighbour{})
+			copy(found[i+1:], found[i:])
+			found[i] = neighbour{cell: cell, dist: dist}
+			if len(found) > k {
+				found = found[:k]
+			}
+		}
+
+		axis := depth % 3
+		diff := p[axis] - q[axis]
+		near, far := [2]int{lo, mid}, [2]int{mid + 1, hi}
+		if diff > 0 {
+			near, far = far, near
+		}
+		search(near[0], near[1], depth+1)
+		if len(found) < k || diff*diff < found[len(found)-1].dist {
+			search(far[0], far[1], depth+1)
+		}
+	}
+	search(0, len(g.tree), 0)
+
+	return found
+}
+
+// triple returns the scalar triple product a . (b x c).
+func triple(a, b, c [3]float64) float64 {
+	return a[0]*(b[1]*c[2]-b[2]*c[1]) + a[1]*(b[2]*c[0]-b[0]*c[2]) + a[2]*(b[0]*c[1]-b[1]*c[0])
+}
+
+// SetUnstructuredGrid attaches the grid file of a message on an unstructured
+// grid, which is needed to look up its values.
+func (g *GRIBFile) SetUnstructuredGrid(grid *UnstructuredGrid) error {
+	h := g.Header
+	if h.GridType != GridUnstructured {
+		return fmt.Errorf("grid type %s is not an unstructured grid", h.GridType)
+	}
+	if grid.Len() != len(g.DataValues) {
+		return fmt.Errorf("grid file has %d cells, message has %d values", grid.Len(), len(g.DataValues))
+	}
+	if grid.UUID != "" && h.GridUUID != "" && grid.UUID != h.GridUUID {
+		return fmt.Errorf("grid file is grid %s, message is on grid %s", grid.UUID, h.GridUUID)
+	}
+
+	g.cells = grid
+	return nil
+}
+
+// unstructuredNearest returns the value of the cell nearest to a point.
+func (g GRIBFile) unstructuredNearest(lat, lng float64) float64 {
+	if g.cells == nil {
+		return g.Header.MissingValue
+	}
+
+	near := g.cells.nearest(unitVector(lat, lng), 1)
+	if len(near) == 0 || math.Sqrt(near[0].dist) > g.cells.spacing {
+		return g.Header.MissingValue
+	}
+	return g.DataValues[near[0].cell]
+}
+
+// interpolateUnstructured interpolates linearly within the triangle of cell
+// centres around a point, which is formed by the nearest cell and two of
+// its neighbours. Points outside all such triangles take the value of the
+// nearest cell.
+func (g GRIBFile) interpolateUnstructured(lat, lng float64) float64 {
+	missing := g.Header.MissingValue
+	if g.cells == nil {
+		return missing
+	}
+
+	p := unitVector(lat, lng)
+	near := g.cells.nearest(p, 8)
+	if len(near) == 0 || math.Sqrt(near[0].dist) > g.cells.spacing {
+		return missing
+	}
+
+	c0 := near[0].cell
+	v0 := g.DataValues[c0]
+	if v0 == missing {
+		return missing
+	}
+
+	a := g.cells.points[c0]
+	for i := 1; i < len(near); i++ {
+		for j := i + 1; j < len(near); j++ {
+			b := g.cells.points[near[i].cell]
+			c := g.cells.points[near[j].cell]
+
+			// Barycentric weights of the projection of p onto the plane of
+			// the triangle; all have the same sign if p lies inside it.
+			wa, wb, wc := triple(p, b, c), triple(a, p, c), triple(a, b, p)
+			total := wa + wb + wc
+			if total == 0 || wa/total < 0 || wb/total < 0 || wc/total < 0 {
+				continue
+			}
+
+			vb, vc := g.DataValues[near[i].cell], g.DataValues[near[j].cell]
+			if vb == missing || vc == missing {
+				return v0
+			}
+			return (wa*v0 + wb*vb + wc*vc) / total
+		}
+	}
+
+	return v0
+}
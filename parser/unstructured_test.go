@@ -0,0 +1,22 @@
+package parser
+
+import "testing"
+
+func TestNewUnstructuredGridKeepsLongitudes(t *testing.T) {
+	lats := []float64{0, 10, -10, 20}
+	lons := []float64{350, 10, 190, 359}
+	want := append([]float64(nil), lons...)
+
+	g, err := newUnstructuredGrid(lats, lons, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range lons {
+		if lons[i] != want[i] {
+			t.Errorf("lons[%d] = %g after building the grid, want %g", i, lons[i], want[i])
+		}
+	}
+	if g.lons[0] != -10 || g.lons[2] != -170 {
+		t.Errorf("grid longitudes %v not normalized", g.lons)
+	}
+}
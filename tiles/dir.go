@@ -0,0 +1,296 @@
+package tiles
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultTemplate is the path of the tiles in a directory tree.
+const DefaultTemplate = "{z}/{x}/{y}.webp"
+
+// DirWriter writes tiles as files of a directory tree, at the paths given
+// by a template with {z}, {x} and {y} placeholders. The tiles are written
+// to a temporary directory next to the tree, which Finish puts in its place.
+type DirWriter struct {
+	dir      string
+	staging  string
+	template string
+	tms      bool
+	update   bool
+}
+
+// CreateDir starts a directory tree of tiles. Templates without an
+// extension get the .webp extension of the tiles. With tms set, rows are
+// numbered from the south as in the TMS scheme instead of from the north.
+// With update set, the tiles are added to the existing tree at dir, which
+// must exist, instead of replacing it.
+func CreateDir(dir, template string, tms, update bool) (*DirWriter, error) {
+	if template == "" {
+		template = DefaultTemplate
+	}
+	if err := CheckTemplate(template); err != nil {
+		return nil, err
+	}
+	if path.Ext(template) == "" {
+		template += ".webp"
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	// Stage next to the directory a symbolic link points to, which is the
+	// one Finish replaces.
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	if update {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return nil, fmt.Errorf("cannot update %s: %w", dir, err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("cannot update %s: not a directory", dir)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return nil, err
+	}
+	staging, err := os.MkdirTemp(filepath.Dir(dir), "."+filepath.Base(dir)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(staging, 0755); err != nil {
+		os.RemoveAll(staging)
+		return nil, err
+	}
+	return &DirWriter{dir: dir, staging: staging, template: template, tms: tms, update: update}, nil
+}
+
+// CheckTemplate reports whether a template is a relative path with all of
+// the {z}, {x} and {y} placeholders.
+func CheckTemplate(template string) error {
+	for _, placeholder := range []string{"{z}", "{x}", "{y}"} {
+		if !strings.Contains(template, placeholder) {
+			return fmt.Errorf("tile template %q lacks %s", template, placeholder)
+		}
+	}
+	if path.IsAbs(template) || strings.HasPrefix(path.Clean(template), "..") {
+		return fmt.Errorf("tile template %q is not a path inside the output directory", template)
+	}
+	return nil
+}
+
+// WriteTile writes the XYZ tile z/x/y to its file.
+func (w *DirWriter) WriteTile(z, x, y int, data []byte) error {
+	if w.tms {
+		y = (1 << z) - 1 - y
+	}
+
+	name := filepath.Join(w.staging, filepath.FromSlash(strings.NewReplacer(
+		"{z}", strconv.Itoa(z),
+		"{x}", strconv.Itoa(x),
+		"{y}", strconv.Itoa(y),
+	).Replace(w.template)))
+
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, 0644)
+}
+
+// Finish writes a tilejson.json describing the tiles next to them, with
+// the template as a relative tile URL, and puts the tree in place of the
+// directory. An existing tree is renamed aside first and removed once the
+// new one is in place, so readers see the old or the new tree, never a mix,
+// though the directory is missing for the moment between the two renames.
+// In update mode, the files of the existing tree that are not rendered
+// again are linked, or copied, into the new tree, and the zoom levels and
+// bounds of its tilejson.json are extended to cover both.
+func (w *DirWriter) Finish(meta Metadata) error {
+	if w.update {
+		meta = w.mergeTileJSON(meta)
+	}
+
+	scheme := "xyz"
+	if w.tms {
+		scheme = "tms"
+	}
+	lon, lat, zoom := meta.Center()
+	bounds := meta.TileBounds()
+
+	data, err := json.MarshalIndent(map[string]any{
+		"tilejson":    "3.0.0",
+		"name":        meta.Name,
+		"description": meta.Description,
+		"version":     meta.Version,
+		"scheme":      scheme,
+		"tiles":       []string{w.template},
+		"minzoom":     meta.MinZoom,
+		"maxzoom":     meta.MaxZoom,
+		"bounds":      []float64{bounds[1], bounds[0], bounds[3], bounds[2]},
+		"center":      []float64{lon, lat, float64(zoom)},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(w.staging, "tilejson.json"), append(data, '\n'), 0644); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(w.dir)
+	if os.IsNotExist(err) {
+		if err := os.Rename(w.staging, w.dir); err != nil {
+			return err
+		}
+		w.staging = ""
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", w.dir)
+	}
+
+	if w.update {
+		if err := w.keepExisting(); err != nil {
+			return err
+		}
+	}
+	if err := os.Chmod(w.staging, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	old := w.staging + ".old"
+	if err := os.Rename(w.dir, old); err != nil {
+		return err
+	}
+	if err := os.Rename(w.staging, w.dir); err != nil {
+		os.Rename(old, w.dir)
+		return err
+	}
+	w.staging = ""
+	return os.RemoveAll(old)
+}
+
+// mergeTileJSON extends the zoom levels and bounds of meta by those in the
+// tilejson.json of the existing tree, if it has a readable one.
+func (w *DirWriter) mergeTileJSON(meta Metadata) Metadata {
+	data, err := os.ReadFile(filepath.Join(w.dir, "tilejson.json"))
+	if err != nil {
+		return meta
+	}
+	var existing struct {
+		MinZoom *int      `json:"minzoom"`
+		MaxZoom *int      `json:"maxzoom"`
+		Bounds  []float64 `json:"bounds"` // minLon, minLat, maxLon, maxLat
+	}
+	if json.Unmarshal(data, &existing) != nil {
+		return meta
+	}
+
+	if existing.MinZoom != nil {
+		meta.MinZoom = min(meta.MinZoom, *existing.MinZoom)
+	}
+	if existing.MaxZoom != nil {
+		meta.MaxZoom = max(meta.MaxZoom, *existing.MaxZoom)
+	}
+	if b := existing.Bounds; len(b) == 4 {
+		meta.Bounds = [4]float64{
+			math.Min(meta.Bounds[0], b[1]),
+			math.Min(meta.Bounds[1], b[0]),
+			math.Max(meta.Bounds[2], b[3]),
+			math.Max(meta.Bounds[3], b[2]),
+		}
+	}
+	return meta
+}
+
+// keepExisting adds the files of the existing tree that have not been
+// rendered again to the staging directory, as hard links where possible.
+func (w *DirWriter) keepExisting() error {
+	return filepath.WalkDir(w.dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(w.dir, name)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(w.staging, rel)
+
+		if d.IsDir() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			if rel == "." {
+				return nil
+			}
+			return os.Chmod(target, info.Mode().Perm())
+		}
+		if _, err := os.Lstat(target); err == nil {
+			return nil
+		}
+
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(name)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			if os.Link(name, target) == nil {
+				return nil
+			}
+			return copyFile(name, target)
+		}
+		return nil
+	})
+}
+
+// copyFile copies a regular file with its permissions.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Close removes the temporary directory unless Finish has put it in place.
+func (w *DirWriter) Close() error {
+	if w.staging == "" {
+		return nil
+	}
+	err := os.RemoveAll(w.staging)
+	w.staging = ""
+	return err
+}
@@ -0,0 +1,141 @@
+package tiles
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTree creates the files of a tile tree.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+type testTileJSON struct {
+	MinZoom int       `json:"minzoom"`
+	MaxZoom int       `json:"maxzoom"`
+	Bounds  []float64 `json:"bounds"`
+}
+
+func readTileJSON(t *testing.T, dir string) testTileJSON {
+	t.Helper()
+	var tileJSON testTileJSON
+	data, err := os.ReadFile(filepath.Join(dir, "tilejson.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &tileJSON); err != nil {
+		t.Fatal(err)
+	}
+	return tileJSON
+}
+
+func checkBounds(t *testing.T, got []float64, want [4]float64) {
+	t.Helper()
+	if len(got) != 4 || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] || got[3] != want[3] {
+		t.Errorf("tilejson bounds %v, want %v", got, want)
+	}
+}
+
+// renderTree writes the tile 0/0/0 to a tree at dir.
+func renderTree(t *testing.T, dir string, update bool, meta Metadata) error {
+	t.Helper()
+	w, err := CreateDir(dir, "", false, update)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if err := w.WriteTile(0, 0, 0, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	return w.Finish(meta)
+}
+
+func TestDirWriterReplacesTree(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "tiles")
+	writeTree(t, dir, map[string]string{
+		"0/0/0.webp":    "old",
+		"1/0/0.webp":    "stale",
+		"tilejson.json": `{"minzoom": 1, "maxzoom": 1}`,
+	})
+
+	if err := renderTree(t, dir, false, DefaultMetadata(0, 0, [4]float64{-90, -180, 90, 180})); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "0", "0", "0.webp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new" {
+		t.Errorf("0/0/0.webp = %q, want %q", data, "new")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "1")); !os.IsNotExist(err) {
+		t.Errorf("tiles of the previous tree were kept: %v", err)
+	}
+
+	tileJSON := readTileJSON(t, dir)
+	if tileJSON.MinZoom != 0 || tileJSON.MaxZoom != 0 {
+		t.Errorf("tilejson zoom levels %d-%d, want 0-0", tileJSON.MinZoom, tileJSON.MaxZoom)
+	}
+	checkBounds(t, tileJSON.Bounds, [4]float64{-180, -MaxLatitude, 180, MaxLatitude})
+
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("%d entries next to the tree, want only the tree", len(entries))
+	}
+}
+
+func TestDirWriterUpdate(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "tiles")
+	writeTree(t, dir, map[string]string{
+		"0/0/0.webp":    "old",
+		"3/4/2.webp":    "kept",
+		"tilejson.json": `{"minzoom": 3, "maxzoom": 5, "bounds": [0, 40, 20, 60]}`,
+	})
+
+	if err := renderTree(t, dir, true, DefaultMetadata(0, 2, [4]float64{-10, -30, 50, 10})); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{"0/0/0.webp": "new", "3/4/2.webp": "kept"} {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("%s = %q, want %q", name, data, want)
+		}
+	}
+
+	tileJSON := readTileJSON(t, dir)
+	if tileJSON.MinZoom != 0 || tileJSON.MaxZoom != 5 {
+		t.Errorf("tilejson zoom levels %d-%d, want 0-5", tileJSON.MinZoom, tileJSON.MaxZoom)
+	}
+	checkBounds(t, tileJSON.Bounds, [4]float64{-30, -10, 20, 60})
+}
+
+func TestDirWriterUpdateMissing(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "tiles")
+	if err := renderTree(t, dir, true, DefaultMetadata(0, 0, [4]float64{-90, -180, 90, 180})); err == nil {
+		t.Error("updated a missing tree")
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("update of a missing tree created it: %v", err)
+	}
+}
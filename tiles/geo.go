@@ -0,0 +1,72 @@
+package tiles
+
+import "math"
+
+const (
+	// TileSize is the width and height of a tile in pixels.
+	TileSize = 256
+
+	WorldSizeWM = 40075016.685578488
+	OffsetWM    = 20037508.342789244
+	EarthRadius = 6378137.0
+
+	// MaxLatitude is the latitude of the top edge of the Web Mercator tiles.
+	MaxLatitude = 85.05112878
+)
+
+// MercatorToLatLon converts Web Mercator coordinates in metres to latitude
+// and longitude in degrees.
+func MercatorToLatLon(mercX, mercY float64) (float64, float64) {
+	lon := (mercX / EarthRadius) * 180.0 / math.Pi
+	lat := (math.Asin(math.Tanh(mercY / EarthRadius))) * 180.0 / math.Pi
+	return lat, lon
+}
+
+// LatLonToTile returns the column and row of the XYZ tile containing a
+// point at a zoom level.
+func LatLonToTile(lat, lon float64, zoom int) (int, int) {
+	if lat < -MaxLatitude {
+		lat = -MaxLatitude
+	} else if lat > MaxLatitude {
+		lat = MaxLatitude
+	}
+
+	if lon < -180 {
+		lon = -180
+	} else if lon > 180 {
+		lon = 180
+	}
+
+	n := math.Pow(2.0, float64(zoom))
+	x := int(math.Floor((lon + 180.0) / 360.0 * n))
+
+	if x >= int(n) {
+		x = int(n) - 1
+	}
+
+	latRad := lat * math.Pi / 180.0
+	y := int(math.Floor((1.0 - math.Log(math.Tan(latRad)+1.0/math.Cos(latRad))/math.Pi) / 2.0 * n))
+
+	if y < 0 {
+		y = 0
+	} else if y >= int(n) {
+		y = int(n) - 1
+	}
+
+	return x, y
+}
+
+// TileRange returns the columns and rows of the tiles covering bounds
+// [minLat, minLon, maxLat, maxLon] at a zoom level.
+func TileRange(bounds [4]float64, zoom int) (minX, minY, maxX, maxY int) {
+	minX, minY = LatLonToTile(bounds[0], bounds[1], zoom)
+	maxX, maxY = LatLonToTile(bounds[2], bounds[3], zoom)
+
+	if minX > maxX {
+		minX, maxX = maxX, minX
+	}
+	if minY > maxY {
+		minY, maxY = maxY, minY
+	}
+	return minX, minY, maxX, maxY
+}
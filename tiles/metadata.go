@@ -0,0 +1,62 @@
+package tiles
+
+import (
+	"fmt"
+	"math"
+)
+
+// Metadata describes a tileset, as stored in the metadata of the output
+// formats.
+type Metadata struct {
+	Name        string
+	Description string
+	Type        string // overlay or baselayer
+	Version     string
+	Format      string // format of the tile data, e.g. webp
+
+	MinZoom, MaxZoom int
+	Bounds           [4]float64 // [minLat, minLon, maxLat, maxLon]
+}
+
+// DefaultMetadata returns the metadata of the tilesets written by
+// grib2tiles.
+func DefaultMetadata(minZoom, maxZoom int, bounds [4]float64) Metadata {
+	return Metadata{
+		Name:        "GRIB Tiles",
+		Description: "Tiles generated using GRIB2Tiles (https://github.com/hstin-de/grib2tiles)",
+		Type:        "overlay",
+		Version:     "1.1",
+		Format:      "webp",
+		MinZoom:     minZoom,
+		MaxZoom:     maxZoom,
+		Bounds:      bounds,
+	}
+}
+
+// Center returns the longitude, latitude and zoom level of the center of
+// the tileset.
+func (m Metadata) Center() (float64, float64, int) {
+	return (m.Bounds[1] + m.Bounds[3]) / 2, (m.Bounds[0] + m.Bounds[2]) / 2, (m.MinZoom + m.MaxZoom) / 2
+}
+
+// TileBounds returns the bounds clamped to the area covered by Web Mercator
+// tiles, longitudes to [-180, 180] and latitudes to [-MaxLatitude,
+// MaxLatitude], as tile readers expect them.
+func (m Metadata) TileBounds() [4]float64 {
+	clamp := func(v, limit float64) float64 { return math.Max(-limit, math.Min(limit, v)) }
+	b := m.Bounds
+	return [4]float64{clamp(b[0], MaxLatitude), clamp(b[1], 180), clamp(b[2], MaxLatitude), clamp(b[3], 180)}
+}
+
+// BoundsString formats the bounds as "minLon,minLat,maxLon,maxLat", as in
+// MBTiles metadata.
+func (m Metadata) BoundsString() string {
+	return fmt.Sprintf("%f,%f,%f,%f", m.Bounds[1], m.Bounds[0], m.Bounds[3], m.Bounds[2])
+}
+
+// CenterString formats the center as "lon,lat,zoom", as in MBTiles
+// metadata.
+func (m Metadata) CenterString() string {
+	lon, lat, zoom := m.Center()
+	return fmt.Sprintf("%f,%f,%d", lon, lat, zoom)
+}
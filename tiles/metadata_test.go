@@ -0,0 +1,34 @@
+package tiles
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestTileBounds(t *testing.T) {
+	tests := []struct {
+		bounds, want [4]float64
+	}{
+		{[4]float64{-90, -180.5, 90, 359.5}, [4]float64{-MaxLatitude, -180, MaxLatitude, 180}},
+		{[4]float64{30, 0, 60, 20}, [4]float64{30, 0, 60, 20}},
+		{[4]float64{-89, -10, 86, 10}, [4]float64{-MaxLatitude, -10, MaxLatitude, 10}},
+	}
+	for _, tt := range tests {
+		if got := (Metadata{Bounds: tt.bounds}).TileBounds(); got != tt.want {
+			t.Errorf("TileBounds(%v) = %v, want %v", tt.bounds, got, tt.want)
+		}
+	}
+}
+
+func TestPMHeaderBounds(t *testing.T) {
+	h := pmHeader{meta: DefaultMetadata(0, 5, [4]float64{-90, -180.25, 90, 180.25})}
+	b := h.encode()
+
+	// Bounds follow the header flags and the zoom levels at offset 102.
+	want := []int32{-1800000000, -850511288, 1800000000, 850511288}
+	for i, w := range want {
+		if got := int32(binary.LittleEndian.Uint32(b[102+4*i:])); got != w {
+			t.Errorf("bound %d = %d, want %d", i, got, w)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package tiles
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// Sink receives the tiles rendered by a Pipeline. WriteTile is called from
+// a single goroutine, with XYZ tile coordinates and WebP encoded data.
+type Sink interface {
+	WriteTile(z, x, y int, data []byte) error
+}
+
+// SinkFunc adapts a function to a Sink.
+type SinkFunc func(z, x, y int, data []byte) error
+
+// WriteTile calls f.
+func (f SinkFunc) WriteTile(z, x, y int, data []byte) error {
+	return f(z, x, y, data)
+}
+
+// Pipeline renders all tiles of a range of zoom levels in parallel.
+type Pipeline struct {
+	Renderer *Renderer
+
+	MinZoom, MaxZoom int
+
+	// Bounds are the [minLat, minLon, maxLat, maxLon] covered by the tiles,
+	// the bounds of the grid when zero.
+	Bounds [4]float64
+
+	// Workers is the number of rendering goroutines, the number of CPUs
+	// when zero.
+	Workers int
+
+	// Progress, if set, is called after every tile handed to the sink with
+	// the number of tiles done and the total. It is called from the sink
+	// goroutine and should return quickly.
+	Progress func(done, total int64)
+}
+
+type tileJob struct {
+	z, x, y int
+}
+
+type tileResult struct {
+	tileJob
+	data []byte
+	err  error
+}
+
+// bounds returns the bounds of the tiles to render.
+func (p *Pipeline) bounds() [4]float64 {
+	if p.Bounds != [4]float64{} {
+		return p.Bounds
+	}
+	minLat, minLon, maxLat, maxLon := p.Renderer.Grid().Bounds()
+	return [4]float64{minLat, minLon, maxLat, maxLon}
+}
+
+// TileCount returns the number of tiles Run renders.
+func (p *Pipeline) TileCount() int64 {
+	bounds := p.bounds()
+
+	var total int64
+	for z := p.MinZoom; z <= p.MaxZoom; z++ {
+		minX, minY, maxX, maxY := TileRange(bounds, z)
+		total += int64(maxX-minX+1) * int64(maxY-minY+1)
+	}
+	return total
+}
+
+// Run renders the tiles and writes them to sink. It stops at the first
+// error of the renderer or the sink and returns it.
+func (p *Pipeline) Run(sink Sink) error {
+	if p.Renderer == nil {
+		return fmt.Errorf("pipeline without a renderer")
+	}
+	if p.MinZoom < 0 || p.MaxZoom < p.MinZoom {
+		return fmt.Errorf("invalid zoom range %d-%d", p.MinZoom, p.MaxZoom)
+	}
+
+	workers := p.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	bounds := p.bounds()
+	total := p.TileCount()
+
+	jobQueue := make(chan tileJob, 1000)
+	resultQueue := make(chan tileResult, 1000)
+	stop := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobQueue {
+				data, err := p.Renderer.Tile(job.z, job.x, job.y)
+				resultQueue <- tileResult{tileJob: job, data: data, err: err}
+			}
+		}()
+	}
+
+	var sinkErr error
+	var sinkWg sync.WaitGroup
+	sinkWg.Add(1)
+	go func() {
+		defer sinkWg.Done()
+
+		var done int64
+		for result := range resultQueue {
+			if sinkErr != nil {
+				continue
+			}
+
+			err := result.err
+			if err == nil {
+				err = sink.WriteTile(result.z, result.x, result.y, result.data)
+			}
+			if err != nil {
+				sinkErr = fmt.Errorf("tile %d/%d/%d: %w", result.z, result.x, result.y, err)
+				close(stop)
+				continue
+			}
+
+			done++
+			if p.Progress != nil {
+				p.Progress(done, total)
+			}
+		}
+	}()
+
+queue:
+	for z := p.MinZoom; z <= p.MaxZoom; z++ {
+		minX, minY, maxX, maxY := TileRange(bounds, z)
+		for x := minX; x <= maxX; x++ {
+			for y := minY; y <= maxY; y++ {
+				select {
+				case jobQueue <- tileJob{z, x, y}:
+				case <-stop:
+					break queue
+				}
+			}
+		}
+	}
+
+	close(jobQueue)
+	wg.Wait()
+
+	close(resultQueue)
+	sinkWg.Wait()
+
+	return sinkErr
+}
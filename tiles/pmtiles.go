@@ -0,0 +1,358 @@
+package tiles
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"hstin/grib2tiles/internal/atomicfile"
+)
+
+// PMTiles v3 constants, see https://github.com/protomaps/PMTiles/blob/main/spec/v3/spec.md.
+const (
+	pmHeaderSize    = 127
+	pmRootSizeLimit = 16384 - pmHeaderSize
+
+	pmCompressionNone = 1
+	pmCompressionGzip = 2
+
+	pmTileTypeUnknown = 0
+	pmTileTypePNG     = 2
+	pmTileTypeJPEG    = 3
+	pmTileTypeWebP    = 4
+)
+
+type pmEntry struct {
+	TileID    uint64
+	Offset    uint64
+	Length    uint32
+	RunLength uint32
+}
+
+type pmContent struct {
+	offset uint64 // offset in the spool file
+	length uint32
+}
+
+// PMTilesWriter writes a PMTiles v3 archive. Tiles are spooled to a
+// temporary file next to the archive as they arrive, identical tiles only
+// once, and the archive is assembled by Finish with its tile data in
+// Hilbert order.
+type PMTilesWriter struct {
+	filename string
+	spool    *os.File
+	size     uint64
+
+	contents map[[sha256.Size]byte]pmContent
+	tiles    map[uint64]pmContent
+}
+
+// CreatePMTiles starts a PMTiles archive. Finish writes it under a
+// temporary name and then replaces any existing file in one rename.
+func CreatePMTiles(filename string) (*PMTilesWriter, error) {
+	spool, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*.tiles")
+	if err != nil {
+		return nil, err
+	}
+	return &PMTilesWriter{
+		filename: filename,
+		spool:    spool,
+		contents: make(map[[sha256.Size]byte]pmContent),
+		tiles:    make(map[uint64]pmContent),
+	}, nil
+}
+
+// WriteTile adds the XYZ tile z/x/y to the archive.
+func (w *PMTilesWriter) WriteTile(z, x, y int, data []byte) error {
+	hash := sha256.Sum256(data)
+	content, ok := w.contents[hash]
+	if !ok {
+		if _, err := w.spool.Write(data); err != nil {
+			return err
+		}
+		content = pmContent{offset: w.size, length: uint32(len(data))}
+		w.contents[hash] = content
+		w.size += uint64(len(data))
+	}
+
+	w.tiles[TileID(z, x, y)] = content
+	return nil
+}
+
+// Finish writes the archive with its directories and metadata.
+func (w *PMTilesWriter) Finish(meta Metadata) error {
+	ids := make([]uint64, 0, len(w.tiles))
+	for id := range w.tiles {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	// Lay out the tile contents in the order of their first tile, merging
+	// runs of consecutive tiles with the same content.
+	var entries []pmEntry
+	var layout []pmContent
+	offsets := make(map[uint64]uint64) // spool offset -> archive offset
+	var dataLength uint64
+	for _, id := range ids {
+		content := w.tiles[id]
+		offset, ok := offsets[content.offset]
+		if !ok {
+			offset = dataLength
+			offsets[content.offset] = offset
+			layout = append(layout, content)
+			dataLength += uint64(content.length)
+		}
+
+		if n := len(entries); n > 0 {
+			last := &entries[n-1]
+			if last.Offset == offset && last.TileID+uint64(last.RunLength) == id {
+				last.RunLength++
+				continue
+			}
+		}
+		entries = append(entries, pmEntry{TileID: id, Offset: offset, Length: content.length, RunLength: 1})
+	}
+
+	root, leaves, err := pmDirectories(entries)
+	if err != nil {
+		return err
+	}
+
+	metadata, err := pmMetadata(meta)
+	if err != nil {
+		return err
+	}
+
+	header := pmHeader{
+		rootOffset:     pmHeaderSize,
+		rootLength:     uint64(len(root)),
+		metadataLength: uint64(len(metadata)),
+		leavesLength:   uint64(len(leaves)),
+		dataLength:     dataLength,
+		addressedTiles: uint64(len(ids)),
+		tileEntries:    uint64(len(entries)),
+		tileContents:   uint64(len(layout)),
+		tileType:       pmTileType(meta.Format),
+		meta:           meta,
+	}
+	header.metadataOffset = header.rootOffset + header.rootLength
+	header.leavesOffset = header.metadataOffset + header.metadataLength
+	header.dataOffset = header.leavesOffset + header.leavesLength
+
+	out, err := atomicfile.Create(w.filename)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	for _, section := range [][]byte{header.encode(), root, metadata, leaves} {
+		if _, err := out.Write(section); err != nil {
+			return err
+		}
+	}
+
+	for _, content := range layout {
+		if _, err := io.Copy(out, io.NewSectionReader(w.spool, int64(content.offset), int64(content.length))); err != nil {
+			return err
+		}
+	}
+
+	return out.Commit()
+}
+
+// Close removes the spooled tiles. It does not finish the archive.
+func (w *PMTilesWriter) Close() error {
+	err := w.spool.Close()
+	os.Remove(w.spool.Name())
+	return err
+}
+
+// TileID returns the PMTiles ID of the XYZ tile z/x/y, its position along
+// the Hilbert curves of the zoom levels up to z.
+func TileID(z, x, y int) uint64 {
+	id := (uint64(1)<<(2*uint(z)) - 1) / 3
+
+	tx, ty := uint64(x), uint64(y)
+	for s := uint64(1) << uint(z) >> 1; s > 0; s >>= 1 {
+		var rx, ry uint64
+		if tx&s != 0 {
+			rx = 1
+		}
+		if ty&s != 0 {
+			ry = 1
+		}
+		id += s * s * ((3 * rx) ^ ry)
+
+		// Rotate the quadrant.
+		if ry == 0 {
+			if rx == 1 {
+				tx = s - 1 - tx&(s-1)
+				ty = s - 1 - ty&(s-1)
+			}
+			tx, ty = ty, tx
+		}
+	}
+	return id
+}
+
+func pmTileType(format string) uint8 {
+	switch format {
+	case "png":
+		return pmTileTypePNG
+	case "jpg", "jpeg":
+		return pmTileTypeJPEG
+	case "webp":
+		return pmTileTypeWebP
+	}
+	return pmTileTypeUnknown
+}
+
+// pmDirectories encodes the root directory, and the leaf directories when
+// the entries do not fit into the first 16 KiB of the archive with the
+// header.
+func pmDirectories(entries []pmEntry) ([]byte, []byte, error) {
+	root, err := pmEncodeDirectory(entries)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(root) <= pmRootSizeLimit {
+		return root, nil, nil
+	}
+
+	for leafSize := 4096; ; leafSize += leafSize / 5 {
+		var rootEntries []pmEntry
+		var leaves bytes.Buffer
+		for start := 0; start < len(entries); start += leafSize {
+			end := min(start+leafSize, len(entries))
+			leaf, err := pmEncodeDirectory(entries[start:end])
+			if err != nil {
+				return nil, nil, err
+			}
+			rootEntries = append(rootEntries, pmEntry{
+				TileID: entries[start].TileID,
+				Offset: uint64(leaves.Len()),
+				Length: uint32(len(leaf)),
+			})
+			leaves.Write(leaf)
+		}
+
+		root, err := pmEncodeDirectory(rootEntries)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(root) <= pmRootSizeLimit {
+			return root, leaves.Bytes(), nil
+		}
+	}
+}
+
+// pmEncodeDirectory encodes directory entries column by column as varints
+// and compresses them with gzip.
+func pmEncodeDirectory(entries []pmEntry) ([]byte, error) {
+	var raw []byte
+	raw = binary.AppendUvarint(raw, uint64(len(entries)))
+
+	var lastID uint64
+	for _, entry := range entries {
+		raw = binary.AppendUvarint(raw, entry.TileID-lastID)
+		lastID = entry.TileID
+	}
+	for _, entry := range entries {
+		raw = binary.AppendUvarint(raw, uint64(entry.RunLength))
+	}
+	for _, entry := range entries {
+		raw = binary.AppendUvarint(raw, uint64(entry.Length))
+	}
+	for i, entry := range entries {
+		// Zero marks an entry directly following the previous one.
+		if i > 0 && entry.Offset == entries[i-1].Offset+uint64(entries[i-1].Length) {
+			raw = binary.AppendUvarint(raw, 0)
+		} else {
+			raw = binary.AppendUvarint(raw, entry.Offset+1)
+		}
+	}
+
+	return pmGzip(raw)
+}
+
+func pmGzip(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// pmMetadata encodes the metadata JSON of the archive, with the keys of
+// MBTiles metadata.
+func pmMetadata(meta Metadata) ([]byte, error) {
+	meta.Bounds = meta.TileBounds()
+	data, err := json.Marshal(map[string]any{
+		"name":        meta.Name,
+		"description": meta.Description,
+		"type":        meta.Type,
+		"version":     meta.Version,
+		"format":      meta.Format,
+		"minzoom":     meta.MinZoom,
+		"maxzoom":     meta.MaxZoom,
+		"bounds":      meta.BoundsString(),
+		"center":      meta.CenterString(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pmGzip(data)
+}
+
+type pmHeader struct {
+	rootOffset, rootLength         uint64
+	metadataOffset, metadataLength uint64
+	leavesOffset, leavesLength     uint64
+	dataOffset, dataLength         uint64
+
+	addressedTiles, tileEntries, tileContents uint64
+	tileType                                  uint8
+	meta                                      Metadata
+}
+
+func (h pmHeader) encode() []byte {
+	b := make([]byte, 0, pmHeaderSize)
+	b = append(b, "PMTiles"...)
+	b = append(b, 3)
+	for _, v := range []uint64{
+		h.rootOffset, h.rootLength,
+		h.metadataOffset, h.metadataLength,
+		h.leavesOffset, h.leavesLength,
+		h.dataOffset, h.dataLength,
+		h.addressedTiles, h.tileEntries, h.tileContents,
+	} {
+		b = binary.LittleEndian.AppendUint64(b, v)
+	}
+
+	// Clustered, internal and tile compression, tile type.
+	b = append(b, 1, pmCompressionGzip, pmCompressionNone, h.tileType)
+
+	e7 := func(degrees float64) uint32 { return uint32(int32(math.Round(degrees * 1e7))) }
+	bounds := h.meta.TileBounds()
+	lon, lat, zoom := h.meta.Center()
+	b = append(b, uint8(h.meta.MinZoom), uint8(h.meta.MaxZoom))
+	b = binary.LittleEndian.AppendUint32(b, e7(bounds[1]))
+	b = binary.LittleEndian.AppendUint32(b, e7(bounds[0]))
+	b = binary.LittleEndian.AppendUint32(b, e7(bounds[3]))
+	b = binary.LittleEndian.AppendUint32(b, e7(bounds[2]))
+	b = append(b, uint8(zoom))
+	b = binary.LittleEndian.AppendUint32(b, e7(lon))
+	b = binary.LittleEndian.AppendUint32(b, e7(lat))
+	return b
+}
@@ -0,0 +1,279 @@
+package tiles
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestTileID(t *testing.T) {
+	tests := []struct {
+		z, x, y int
+		want    uint64
+	}{
+		{0, 0, 0, 0},
+		{1, 0, 0, 1},
+		{1, 0, 1, 2},
+		{1, 1, 1, 3},
+		{1, 1, 0, 4},
+		{2, 0, 0, 5},
+		{3, 0, 0, 21},
+	}
+	for _, tt := range tests {
+		if got := TileID(tt.z, tt.x, tt.y); got != tt.want {
+			t.Errorf("TileID(%d, %d, %d) = %d, want %d", tt.z, tt.x, tt.y, got, tt.want)
+		}
+	}
+
+	// The tiles of a zoom level fill the IDs between those of the levels
+	// above and below, each ID once.
+	for z := 0; z <= 4; z++ {
+		first := TileID(z, 0, 0)
+		seen := make(map[uint64]bool)
+		for x := 0; x < 1<<z; x++ {
+			for y := 0; y < 1<<z; y++ {
+				id := TileID(z, x, y)
+				if id < first || id >= first+1<<(2*z) || seen[id] {
+					t.Fatalf("TileID(%d, %d, %d) = %d, outside zoom level %d or repeated", z, x, y, id, z)
+				}
+				seen[id] = true
+			}
+		}
+	}
+}
+
+// pmDecodeDirectory decodes a directory encoded by pmEncodeDirectory.
+func pmDecodeDirectory(t *testing.T, data []byte) []pmEntry {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := bytes.NewReader(raw)
+	uvarint := func() uint64 {
+		v, err := binary.ReadUvarint(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	entries := make([]pmEntry, uvarint())
+	var id uint64
+	for i := range entries {
+		id += uvarint()
+		entries[i].TileID = id
+	}
+	for i := range entries {
+		entries[i].RunLength = uint32(uvarint())
+	}
+	for i := range entries {
+		entries[i].Length = uint32(uvarint())
+	}
+	for i := range entries {
+		if offset := uvarint(); offset == 0 && i > 0 {
+			entries[i].Offset = entries[i-1].Offset + uint64(entries[i-1].Length)
+		} else {
+			entries[i].Offset = offset - 1
+		}
+	}
+	if r.Len() != 0 {
+		t.Fatalf("%d bytes after the directory", r.Len())
+	}
+	return entries
+}
+
+func checkEntries(t *testing.T, got, want []pmEntry) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%d entries, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("entry %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestPMEncodeDirectory(t *testing.T) {
+	entries := []pmEntry{
+		{TileID: 0, Offset: 0, Length: 100, RunLength: 1},
+		{TileID: 1, Offset: 100, Length: 20, RunLength: 4},
+		{TileID: 5, Offset: 0, Length: 100, RunLength: 1},
+		{TileID: 300, Offset: 120, Length: 70000, RunLength: 1},
+		{TileID: 1 << 40, Offset: 70120, Length: 1, RunLength: 200},
+		{TileID: 1<<40 + 500, Offset: 4096, Length: 512, RunLength: 0},
+	}
+	data, err := pmEncodeDirectory(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, pmDecodeDirectory(t, data), entries)
+}
+
+// pmArchive is a PMTiles archive read back with its directories resolved.
+type pmArchive struct {
+	header  []uint64 // the offsets, lengths and counts of the header
+	entries []pmEntry
+	leaves  int
+	data    []byte
+}
+
+// tile returns the content of a tile and whether the archive has it.
+func (a pmArchive) tile(z, x, y int) ([]byte, bool) {
+	id := TileID(z, x, y)
+	i := sort.Search(len(a.entries), func(i int) bool { return a.entries[i].TileID > id }) - 1
+	if i < 0 || id >= a.entries[i].TileID+uint64(a.entries[i].RunLength) {
+		return nil, false
+	}
+	e := a.entries[i]
+	return a.data[e.Offset : e.Offset+uint64(e.Length)], true
+}
+
+func readPMTiles(t *testing.T, filename string) pmArchive {
+	t.Helper()
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:7]) != "PMTiles" || b[7] != 3 {
+		t.Fatalf("header %q, want PMTiles v3", b[:8])
+	}
+
+	var a pmArchive
+	for i := 0; i < 11; i++ {
+		a.header = append(a.header, binary.LittleEndian.Uint64(b[8+8*i:]))
+	}
+	rootOffset, rootLength := a.header[0], a.header[1]
+	leavesOffset := a.header[4]
+	dataOffset, dataLength := a.header[6], a.header[7]
+	if dataOffset+dataLength != uint64(len(b)) {
+		t.Fatalf("tile data ends at %d, file at %d", dataOffset+dataLength, len(b))
+	}
+	a.data = b[dataOffset:]
+
+	var walk func(dir []pmEntry)
+	walk = func(dir []pmEntry) {
+		for _, e := range dir {
+			if e.RunLength > 0 {
+				a.entries = append(a.entries, e)
+				continue
+			}
+			a.leaves++
+			start := leavesOffset + e.Offset
+			leaf := pmDecodeDirectory(t, b[start:start+uint64(e.Length)])
+			if len(leaf) == 0 || leaf[0].TileID != e.TileID {
+				t.Fatalf("leaf directory at %d does not start with tile %d", e.Offset, e.TileID)
+			}
+			walk(leaf)
+		}
+	}
+	walk(pmDecodeDirectory(t, b[rootOffset:rootOffset+rootLength]))
+	return a
+}
+
+func writePMTiles(t *testing.T, tiles map[[3]int][]byte) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "tiles.pmtiles")
+	w, err := CreatePMTiles(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for tile, data := range tiles {
+		if err := w.WriteTile(tile[0], tile[1], tile[2], data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Finish(DefaultMetadata(0, 9, [4]float64{-90, -180, 90, 180})); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func checkTiles(t *testing.T, a pmArchive, tiles map[[3]int][]byte) {
+	t.Helper()
+	for tile, want := range tiles {
+		got, ok := a.tile(tile[0], tile[1], tile[2])
+		if !ok || !bytes.Equal(got, want) {
+			t.Fatalf("tile %d/%d/%d = %q, want %q", tile[0], tile[1], tile[2], got, want)
+		}
+	}
+}
+
+func TestPMTilesDedup(t *testing.T) {
+	sea, land, coast := []byte("sea"), []byte("land"), []byte("coast")
+	tiles := map[[3]int][]byte{
+		{0, 0, 0}: sea,
+		{1, 0, 0}: land, // ID 1
+		{1, 0, 1}: land, // ID 2
+		{1, 1, 1}: coast,
+		{1, 1, 0}: sea, // ID 4, not next to the tile 0/0/0
+		{2, 0, 0}: coast,
+		{2, 1, 0}: coast, // ID 6, continuing the run of 2/0/0
+	}
+	a := readPMTiles(t, writePMTiles(t, tiles))
+
+	// Each content is stored once, identical tiles with consecutive IDs
+	// share a run.
+	checkEntries(t, a.entries, []pmEntry{
+		{TileID: 0, Offset: 0, Length: 3, RunLength: 1},
+		{TileID: 1, Offset: 3, Length: 4, RunLength: 2},
+		{TileID: 3, Offset: 7, Length: 5, RunLength: 1},
+		{TileID: 4, Offset: 0, Length: 3, RunLength: 1},
+		{TileID: 5, Offset: 7, Length: 5, RunLength: 2},
+	})
+	if addressed, entries, contents := a.header[8], a.header[9], a.header[10]; addressed != 7 || entries != 5 || contents != 3 {
+		t.Errorf("%d addressed tiles, %d entries and %d contents, want 7, 5 and 3", addressed, entries, contents)
+	}
+	if dataLength := a.header[7]; dataLength != 12 {
+		t.Errorf("%d bytes of tile data, want 12", dataLength)
+	}
+	checkTiles(t, a, tiles)
+}
+
+func TestPMTilesLeafDirectories(t *testing.T) {
+	// Scattered tiles of random lengths do not compress into a root
+	// directory of 16 KiB.
+	rng := rand.New(rand.NewSource(1))
+	tiles := make(map[[3]int][]byte)
+	for x := 0; x < 512; x++ {
+		for y := 0; y < 512; y++ {
+			if rng.Intn(8) == 0 {
+				tiles[[3]int{9, x, y}] = []byte(fmt.Sprintf("%d/%d %0*d", x, y, rng.Intn(200), 0))
+			}
+		}
+	}
+	a := readPMTiles(t, writePMTiles(t, tiles))
+
+	if a.leaves < 2 {
+		t.Errorf("%d leaf directories for %d tiles, want several", a.leaves, len(tiles))
+	}
+	if rootLength := a.header[1]; rootLength > pmRootSizeLimit {
+		t.Errorf("root directory of %d bytes exceeds %d", rootLength, pmRootSizeLimit)
+	}
+	if a.header[5] == 0 {
+		t.Error("no leaf directory section")
+	}
+	if len(a.entries) != len(tiles) {
+		t.Errorf("%d entries, want one for each of the %d tiles", len(a.entries), len(tiles))
+	}
+	for i := 1; i < len(a.entries); i++ {
+		if a.entries[i].TileID <= a.entries[i-1].TileID {
+			t.Fatalf("entry %d for tile %d follows tile %d", i, a.entries[i].TileID, a.entries[i-1].TileID)
+		}
+	}
+	checkTiles(t, a, tiles)
+}
@@ -0,0 +1,136 @@
+// Package tiles renders decoded GRIB fields into Web Mercator map tiles.
+//
+// A Renderer draws single tiles of a field with a color map, and a Pipeline
+// renders a whole pyramid of tiles in parallel and hands them to a Sink:
+//
+//	files, _ := parser.ProcessGRIBFile("t_2m.grib2")
+//	colors, _ := colormap.Load("name:temperature")
+//	renderer, _ := tiles.NewRenderer(&files[0], colors, tiles.Options{})
+//	img := renderer.Image(5, 16, 10)
+package tiles
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+
+	"hstin/grib2tiles/colormap"
+	"hstin/grib2tiles/parser"
+
+	"github.com/chai2010/webp"
+)
+
+// DefaultQuality is the WebP quality used when Options.Quality is zero.
+const DefaultQuality = 90
+
+// Options control the rendering of tiles.
+type Options struct {
+	// Interpolator estimates the field between grid points, parser.Bicubic
+	// when nil.
+	Interpolator parser.Interpolator
+
+	// Bounds clips the tiles to [minLat, minLon, maxLat, maxLon], pixels
+	// outside are transparent. The zero value does not clip.
+	Bounds [4]float64
+
+	// Quality of the WebP encoding from 1 to 100, DefaultQuality when zero.
+	Quality int
+}
+
+// Renderer renders tiles of a field. It is not modified after creation and
+// may be used by concurrent goroutines.
+type Renderer struct {
+	grid   *parser.GRIBFile
+	colors *colormap.ColorMap
+	opts   Options
+}
+
+// NewRenderer returns a renderer of a decoded field with a color map. Color
+// maps with thresholds relative to the range of the field must be resolved
+// with ColorMap.WithRange first.
+func NewRenderer(grid *parser.GRIBFile, colors *colormap.ColorMap, opts Options) (*Renderer, error) {
+	if grid == nil {
+		return nil, fmt.Errorf("no grid to render")
+	}
+	if colors == nil {
+		return nil, fmt.Errorf("no color map configured")
+	}
+	if opts.Interpolator == nil {
+		opts.Interpolator = parser.Bicubic
+	}
+	if opts.Quality == 0 {
+		opts.Quality = DefaultQuality
+	}
+	if opts.Quality < 1 || opts.Quality > 100 {
+		return nil, fmt.Errorf("invalid WebP quality %d", opts.Quality)
+	}
+	return &Renderer{grid: grid, colors: colors, opts: opts}, nil
+}
+
+// Grid returns the field drawn by the renderer.
+func (r *Renderer) Grid() *parser.GRIBFile {
+	return r.grid
+}
+
+// Image renders the XYZ tile z/x/y. Pixels without a value are transparent,
+// or take the no-data color of the color map.
+func (r *Renderer) Image(z, x, y int) *image.RGBA {
+	bounds := r.opts.Bounds
+	clip := bounds != [4]float64{}
+	noDataColor, hasNoData := r.colors.NoDataColor()
+
+	img := image.NewRGBA(image.Rect(0, 0, TileSize, TileSize))
+
+	s := WorldSizeWM / (float64(TileSize) * float64(uint32(1)<<z))
+	baseX := x * TileSize
+	baseY := y * TileSize
+
+	for py := 0; py < TileSize; py++ {
+		rowOffset := py * img.Stride
+		worldY := float64(baseY + py)
+		for px := 0; px < TileSize; px++ {
+			worldX := float64(baseX + px)
+			mercX := worldX*s - OffsetWM
+			mercY := OffsetWM - worldY*s
+
+			lat, lon := MercatorToLatLon(mercX, mercY)
+
+			if clip && (lat < bounds[0] || lat > bounds[2] ||
+				lon < bounds[1] || lon > bounds[3]) {
+				continue
+			}
+
+			val := r.grid.Interpolate(lat, lon, r.opts.Interpolator)
+
+			pixelColor := noDataColor
+			if val != r.grid.Header.MissingValue {
+				pixelColor = r.colors.Color(val)
+			} else if !hasNoData {
+				continue
+			}
+
+			idx := rowOffset + px*4
+			img.Pix[idx] = pixelColor.R
+			img.Pix[idx+1] = pixelColor.G
+			img.Pix[idx+2] = pixelColor.B
+			img.Pix[idx+3] = pixelColor.A
+		}
+	}
+
+	return img
+}
+
+// Tile renders the XYZ tile z/x/y and encodes it as WebP.
+func (r *Renderer) Tile(z, x, y int) ([]byte, error) {
+	return r.Encode(r.Image(z, x, y))
+}
+
+// Encode encodes a tile image as WebP with the quality of the renderer.
+func (r *Renderer) Encode(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	options := &webp.Options{Lossless: false, Quality: float32(r.opts.Quality)}
+	if err := webp.Encode(&buf, img, options); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}